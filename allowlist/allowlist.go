@@ -0,0 +1,131 @@
+// Package allowlist restricts the clients Hegel serves to a list of source CIDRs. Clients are identified by the address
+// X-Forwarded-For resolves to so the HTTP handler and gRPC interceptors must run after those of the xff package. The
+// trusted proxies configured with xff are not implicitly allowed.
+package allowlist
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"strings"
+
+	"github.com/pkg/errors"
+	"github.com/tinkerbell/hegel/metrics"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+)
+
+// List is a list of source CIDRs. A nil List allows all sources.
+type List struct {
+	nets []*net.IPNet
+}
+
+// Parse parses a comma separated list of CIDRs and IPs. An empty list returns nil which allows all sources.
+func Parse(cidrs string) (*List, error) {
+	var nets []*net.IPNet
+	for _, cidr := range strings.Split(cidrs, ",") {
+		cidr = strings.TrimSpace(cidr)
+		if cidr == "" {
+			continue
+		}
+
+		if !strings.Contains(cidr, "/") {
+			ip := net.ParseIP(cidr)
+			if ip == nil {
+				return nil, errors.Errorf("invalid ip: %v", cidr)
+			}
+
+			bits := 8 * net.IPv6len
+			if ip.To4() != nil {
+				ip, bits = ip.To4(), 8*net.IPv4len
+			}
+			nets = append(nets, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+
+		_, network, err := net.ParseCIDR(cidr)
+		if err != nil {
+			return nil, err
+		}
+		nets = append(nets, network)
+	}
+
+	if len(nets) == 0 {
+		return nil, nil
+	}
+	return &List{nets: nets}, nil
+}
+
+// Allows returns true if ip is within l or l is nil.
+func (l *List) Allows(ip net.IP) bool {
+	if l == nil {
+		return true
+	}
+
+	for _, network := range l.nets {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// allowsAddr returns true if the host of addr, which may include a port, is within l.
+func (l *List) allowsAddr(addr string) bool {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		host = addr
+	}
+	return l.Allows(net.ParseIP(host))
+}
+
+// HTTPHandler wraps handler rejecting requests whose remote address isn't within l with http.StatusForbidden. If l is
+// nil handler is returned as is.
+func HTTPHandler(handler http.Handler, l *List) http.Handler {
+	if l == nil {
+		return handler
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !l.allowsAddr(r.RemoteAddr) {
+			metrics.DisallowedRequests.WithLabelValues("http").Inc()
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		handler.ServeHTTP(w, r)
+	})
+}
+
+// GRPCInterceptors returns interceptors that reject calls from peers that aren't within l with codes.PermissionDenied.
+// If l is nil the interceptors allow all calls.
+func GRPCInterceptors(l *List) (grpc.StreamServerInterceptor, grpc.UnaryServerInterceptor) {
+	streamer := func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		if err := l.checkPeer(ss.Context()); err != nil {
+			return err
+		}
+		return handler(srv, ss)
+	}
+	unaryer := func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		if err := l.checkPeer(ctx); err != nil {
+			return nil, err
+		}
+		return handler(ctx, req)
+	}
+	return streamer, unaryer
+}
+
+// checkPeer returns a codes.PermissionDenied error if the peer of ctx isn't within l.
+func (l *List) checkPeer(ctx context.Context) error {
+	if l == nil {
+		return nil
+	}
+
+	if p, ok := peer.FromContext(ctx); ok && l.allowsAddr(p.Addr.String()) {
+		return nil
+	}
+
+	metrics.DisallowedRequests.WithLabelValues("grpc").Inc()
+	return status.Error(codes.PermissionDenied, "source address not allowed")
+}
@@ -0,0 +1,101 @@
+package allowlist
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+)
+
+func TestParse(t *testing.T) {
+	tests := map[string]struct {
+		cidrs      string
+		allowed    []string
+		disallowed []string
+		error      bool
+	}{
+		"Empty": {
+			cidrs:   "",
+			allowed: []string{"10.0.0.1", "fd00::1"},
+		},
+		"CIDRs": {
+			cidrs:      "10.0.0.0/24, fd00::/64",
+			allowed:    []string{"10.0.0.1", "10.0.0.255", "fd00::1"},
+			disallowed: []string{"10.0.1.1", "fd01::1", "invalid"},
+		},
+		"IPs": {
+			cidrs:      "10.0.0.1,fd00::1",
+			allowed:    []string{"10.0.0.1", "fd00::1"},
+			disallowed: []string{"10.0.0.2", "fd00::2"},
+		},
+		"InvalidIP": {
+			cidrs: "10.0.0.1,nope",
+			error: true,
+		},
+		"InvalidCIDR": {
+			cidrs: "10.0.0.0/33",
+			error: true,
+		},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			list, err := Parse(test.cidrs)
+			if test.error {
+				require.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+
+			for _, ip := range test.allowed {
+				require.True(t, list.Allows(net.ParseIP(ip)), ip)
+			}
+			for _, ip := range test.disallowed {
+				require.False(t, list.Allows(net.ParseIP(ip)), ip)
+			}
+		})
+	}
+}
+
+func TestHTTPHandler(t *testing.T) {
+	list, err := Parse("192.168.1.0/24")
+	require.NoError(t, err)
+
+	handler := HTTPHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}), list)
+	for remoteAddr, code := range map[string]int{
+		"192.168.1.5:4242": http.StatusOK,
+		"192.168.2.5:4242": http.StatusForbidden,
+	} {
+		req := httptest.NewRequest("GET", "/2009-04-04/meta-data", nil)
+		req.RemoteAddr = remoteAddr
+		resp := httptest.NewRecorder()
+		handler.ServeHTTP(resp, req)
+		require.Equal(t, code, resp.Code, remoteAddr)
+	}
+}
+
+func TestGRPCInterceptors(t *testing.T) {
+	list, err := Parse("192.168.1.0/24")
+	require.NoError(t, err)
+
+	_, unary := GRPCInterceptors(list)
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) { return "ok", nil }
+	call := func(ip string) error {
+		ctx := peer.NewContext(context.Background(), &peer.Peer{Addr: &net.TCPAddr{IP: net.ParseIP(ip), Port: 4242}})
+		_, err := unary(ctx, nil, &grpc.UnaryServerInfo{}, handler)
+		return err
+	}
+
+	require.NoError(t, call("192.168.1.5"))
+	require.Equal(t, codes.PermissionDenied, status.Code(call("192.168.2.5")))
+
+	_, err = unary(context.Background(), nil, &grpc.UnaryServerInfo{}, handler)
+	require.Equal(t, codes.PermissionDenied, status.Code(err))
+}
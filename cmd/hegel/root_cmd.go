@@ -17,6 +17,7 @@ import (
 	"github.com/spf13/cobra"
 	"github.com/spf13/pflag"
 	"github.com/spf13/viper"
+	"github.com/tinkerbell/hegel/allowlist"
 	"github.com/tinkerbell/hegel/datamodel"
 	"github.com/tinkerbell/hegel/grpc"
 	"github.com/tinkerbell/hegel/hardware"
@@ -61,12 +62,29 @@ const EnvNamePrefix = "HEGEL"
 
 // RootCommandOptions encompasses all the configurability of the RootCommand.
 type RootCommandOptions struct {
-	DataModel      string `mapstructure:"data-model"`
-	Facility       string `mapstructure:"facility"`
-	TrustedProxies string `mapstructure:"trusted-proxies"`
+	DataModel         string `mapstructure:"data-model"`
+	DataModelFallback string `mapstructure:"data-model-fallback"`
+	Facility          string `mapstructure:"facility"`
+	TrustedProxies    string `mapstructure:"trusted-proxies"`
+	AllowedSources    string `mapstructure:"allowed-sources"`
 
-	HTTPCustomEndpoints string `mapstructure:"http-custom-endpoints"`
-	HTTPPort            int    `mapstructure:"http-port"`
+	ShutdownTimeout time.Duration `mapstructure:"shutdown-timeout"`
+
+	HTTPCustomEndpoints     string `mapstructure:"http-custom-endpoints"`
+	HTTPCustomEndpointsFile string `mapstructure:"http-custom-endpoints-file"`
+	HTTPPort                int    `mapstructure:"http-port"`
+
+	HTTPRateLimit      float64 `mapstructure:"http-rate-limit"`
+	HTTPRateLimitBurst int     `mapstructure:"http-rate-limit-burst"`
+	HTTPMaxInFlight    int     `mapstructure:"http-max-in-flight"`
+
+	EC2TokenMode        string `mapstructure:"ec2-token-mode"`
+	EC2IdentityKeyPath  string `mapstructure:"ec2-identity-key"`
+	EC2IdentityCertPath string `mapstructure:"ec2-identity-cert"`
+
+	NoCloudPrefix string `mapstructure:"nocloud-prefix"`
+
+	VendorDataPath string `mapstructure:"vendor-data"`
 
 	GRPCPort        int    `mapstructure:"grpc-port"`
 	GRPCTLSCertPath string `mapstructure:"grpc-tls-cert"`
@@ -77,13 +95,46 @@ type RootCommandOptions struct {
 	Kubeconfig       string `mapstructure:"kubeconfig"`
 	KubeNamespace    string `mapstructure:"kube-namespace"`
 
-	HegelAPI bool `mapstructure:"hegel-api"`
+	HardwarePath             string        `mapstructure:"hardware-path"`
+	HardwareCacheTTL         time.Duration `mapstructure:"hardware-cache-ttl"`
+	HardwareCacheNegativeTTL time.Duration `mapstructure:"hardware-cache-negative-ttl"`
+
+	MetadataAPI bool `mapstructure:"metadata-api"`
+	HegelAPI    bool `mapstructure:"hegel-api"`
+
+	AdminPort         int    `mapstructure:"admin-port"`
+	AdminTokenPath    string `mapstructure:"admin-token-file"`
+	AdminTLSCertPath  string `mapstructure:"admin-tls-cert"`
+	AdminTLSKeyPath   string `mapstructure:"admin-tls-key"`
+	AdminClientCAPath string `mapstructure:"admin-client-ca"`
 }
 
 func (o RootCommandOptions) GetDataModel() datamodel.DataModel {
 	return datamodel.DataModel(o.DataModel)
 }
 
+// GetFallbackDataModels parses the comma separated DataModelFallback.
+func (o RootCommandOptions) GetFallbackDataModels() []datamodel.DataModel {
+	var models []datamodel.DataModel
+	for _, model := range strings.Split(o.DataModelFallback, ",") {
+		model = strings.TrimSpace(model)
+		if model == "" {
+			continue
+		}
+		models = append(models, datamodel.DataModel(model))
+	}
+	return models
+}
+
+// requestLimits returns the limits applied to HTTP requests.
+func (o RootCommandOptions) requestLimits() http.RequestLimits {
+	return http.RequestLimits{
+		Rate:        o.HTTPRateLimit,
+		Burst:       o.HTTPRateLimitBurst,
+		MaxInFlight: o.HTTPMaxInFlight,
+	}
+}
+
 // RootCommand is the root command that represents the entrypoint to Hegel.
 type RootCommand struct {
 	*cobra.Command
@@ -148,16 +199,59 @@ func (c *RootCommand) Run(cmd *cobra.Command, _ []string) error {
 	metrics.State.Set(metrics.Initializing)
 
 	hardwareClient, err := hardware.NewClient(hardware.ClientConfig{
-		Model:         c.Opts.GetDataModel(),
-		Facility:      c.Opts.Facility,
-		KubeAPI:       c.Opts.KubernetesAPIURL,
-		Kubeconfig:    c.Opts.Kubeconfig,
-		KubeNamespace: c.Opts.KubeNamespace,
+		Model:            c.Opts.GetDataModel(),
+		FallbackModels:   c.Opts.GetFallbackDataModels(),
+		Facility:         c.Opts.Facility,
+		KubeAPI:          c.Opts.KubernetesAPIURL,
+		Kubeconfig:       c.Opts.Kubeconfig,
+		KubeNamespace:    c.Opts.KubeNamespace,
+		FilePath:         c.Opts.HardwarePath,
+		CacheTTL:         c.Opts.HardwareCacheTTL,
+		CacheNegativeTTL: c.Opts.HardwareCacheNegativeTTL,
+		Logger:           logger,
 	})
 	if err != nil {
 		return errors.Errorf("create client: %v", err)
 	}
 
+	var identitySigner *http.InstanceIdentitySigner
+	if c.Opts.EC2IdentityKeyPath != "" {
+		identitySigner, err = http.LoadInstanceIdentitySigner(c.Opts.EC2IdentityKeyPath, c.Opts.EC2IdentityCertPath)
+		if err != nil {
+			return errors.Errorf("load instance identity signer: %v", err)
+		}
+	}
+
+	var vendorData *http.VendorData
+	if c.Opts.VendorDataPath != "" {
+		vendorData, err = http.LoadVendorData(c.Opts.VendorDataPath)
+		if err != nil {
+			return errors.Errorf("load vendor data: %v", err)
+		}
+	}
+
+	var adminToken string
+	if c.Opts.AdminTokenPath != "" {
+		token, err := os.ReadFile(c.Opts.AdminTokenPath)
+		if err != nil {
+			return errors.Errorf("read admin token: %v", err)
+		}
+		adminToken = strings.TrimSpace(string(token))
+	}
+
+	adminConfig := http.AdminConfig{
+		Port:         c.Opts.AdminPort,
+		Token:        adminToken,
+		TLSCertPath:  c.Opts.AdminTLSCertPath,
+		TLSKeyPath:   c.Opts.AdminTLSKeyPath,
+		ClientCAPath: c.Opts.AdminClientCAPath,
+	}
+	if adminConfig.Port != 0 {
+		if err := adminConfig.Validate(); err != nil {
+			return errors.Errorf("admin API: %v", err)
+		}
+	}
+
 	grpcServer := grpc.NewServer(logger, hardwareClient)
 
 	ctx, cancel := signal.NotifyContext(ctx, syscall.SIGINT, syscall.SIGTERM)
@@ -165,18 +259,24 @@ func (c *RootCommand) Run(cmd *cobra.Command, _ []string) error {
 
 	routines.Add(
 		func() error {
-			return http.Serve(
-				ctx,
-				logger,
-				hardwareClient,
-				grpcServer,
-				c.Opts.HTTPPort,
-				time.Now(),
-				c.Opts.GetDataModel(),
-				c.Opts.HTTPCustomEndpoints,
-				c.Opts.TrustedProxies,
-				c.Opts.HegelAPI,
-			)
+			return http.Serve(ctx, logger, hardwareClient, grpcServer, http.ServerConfig{
+				Port:                c.Opts.HTTPPort,
+				Start:               time.Now(),
+				Model:               c.Opts.GetDataModel(),
+				CustomEndpoints:     c.Opts.HTTPCustomEndpoints,
+				CustomEndpointsFile: c.Opts.HTTPCustomEndpointsFile,
+				TrustedProxies:      c.Opts.TrustedProxies,
+				AllowedSources:      c.Opts.AllowedSources,
+				MetadataAPI:         c.Opts.MetadataAPI,
+				HegelAPI:            c.Opts.HegelAPI,
+				EC2TokenMode:        http.EC2TokenMode(c.Opts.EC2TokenMode),
+				IdentitySigner:      identitySigner,
+				NoCloudPrefix:       c.Opts.NoCloudPrefix,
+				VendorData:          vendorData,
+				Admin:               adminConfig,
+				Limits:              c.Opts.requestLimits(),
+				ShutdownTimeout:     c.Opts.ShutdownTimeout,
+			})
 		},
 		func(error) { cancel() },
 	)
@@ -189,9 +289,11 @@ func (c *RootCommand) Run(cmd *cobra.Command, _ []string) error {
 				grpcServer,
 				c.Opts.GRPCPort,
 				c.Opts.TrustedProxies,
+				c.Opts.AllowedSources,
 				c.Opts.GRPCTLSCertPath,
 				c.Opts.GRPCTLSKeyPath,
 				c.Opts.GRPCUseTLS,
+				c.Opts.ShutdownTimeout,
 			)
 		},
 		func(error) { cancel() },
@@ -202,7 +304,8 @@ func (c *RootCommand) Run(cmd *cobra.Command, _ []string) error {
 
 func (c *RootCommand) configureFlags() error {
 	// Alphabetically ordereed
-	c.Flags().String("data-model", string(datamodel.TinkServer), "The back-end data source: [\"1\", \"kubernetes\"] (1 indicates tink server)")
+	c.Flags().String("data-model", string(datamodel.TinkServer), "The back-end data source: [\"1\", \"kubernetes\", \"file\"] (1 indicates tink server)")
+	c.Flags().String("data-model-fallback", "", "A comma separated list of back-end data sources queried, in order, when hardware isn't found in --data-model")
 	c.Flags().String("facility", "onprem", "The facility we are running in (mostly to connect to cacher)")
 
 	c.Flags().Int("grpc-port", 42115, "Port to listen on for gRPC requests")
@@ -211,15 +314,41 @@ func (c *RootCommand) configureFlags() error {
 	c.Flags().Bool("grpc-use-tls", true, "Toggle for gRPC TLS usage")
 
 	c.Flags().String("http-custom-endpoints", `{"/metadata":".metadata.instance"}`, "JSON encoded object specifying custom endpoint => metadata mappings")
+	c.Flags().String("http-custom-endpoints-file", "", "Path to a YAML file of custom endpoints with content types, formats and per data model filters; it's reloaded when changed and replaces --http-custom-endpoints")
 	c.Flags().Int("http-port", 50061, "Port to listen on for HTTP requests")
+	c.Flags().Float64("http-rate-limit", 0, "Requests per second each client IP may make to the HTTP server; 0 disables rate limiting")
+	c.Flags().Int("http-rate-limit-burst", 0, "Requests each client IP may make at once in excess of --http-rate-limit; defaults to --http-rate-limit rounded up")
+	c.Flags().Int("http-max-in-flight", 0, "Maximum number of HTTP requests served at once; 0 disables the limit")
+
+	c.Flags().String("ec2-token-mode", string(http.EC2TokenOptional), "Whether metadata requests must carry an EC2 IMDSv2 session token, in required mode every metadata endpoint requires one: [\"optional\", \"required\"]")
+	c.Flags().String("ec2-identity-key", "", "Path to a PEM encoded RSA or ECDSA private key used to sign EC2 instance identity documents")
+	c.Flags().String("ec2-identity-cert", "", "Path to a PEM encoded certificate for --ec2-identity-key")
+
+	c.Flags().String("nocloud-prefix", "", "URL path prefix, such as /nocloud, to serve the cloud-init NoCloud datasource under; disabled when empty")
+
+	c.Flags().String("vendor-data", "", "Path to a YAML file of cloud-init vendor-data with default, per facility and per plan entries")
 
 	c.Flags().String("kubeconfig", "", "Path to a kubeconfig file")
 	c.Flags().String("kubernetes", "", "URL of the Kubernetes API Server")
 	c.Flags().String("kube-namespace", "", "The Kubernetes namespace to target; defaults to the service account")
 
+	c.Flags().String("hardware-path", "", "Path to a YAML or JSON file, or a directory of them, containing Hardware resources for the file data model")
+	c.Flags().Duration("hardware-cache-ttl", 0, "How long to cache hardware looked up from the back-end data source; 0 disables caching")
+	c.Flags().Duration("hardware-cache-negative-ttl", 0, "How long to cache lookups for unknown hardware when caching is enabled; 0 disables negative caching")
+
 	c.Flags().String("trusted-proxies", "", "A commma separated list of allowed peer IPs and/or CIDR blocks to replace with X-Forwarded-For for both gRPC and HTTP endpoints")
+	c.Flags().String("allowed-sources", "", "A comma separated list of client IPs and/or CIDR blocks allowed to query the gRPC and HTTP endpoints once X-Forwarded-For is resolved; empty allows all. Monitoring and admin endpoints are exempt")
+
+	c.Flags().Duration("shutdown-timeout", 10*time.Second, "How long in-flight gRPC and HTTP requests may take to finish on shutdown before their connections are closed; subscriptions are ended straight away")
 
-	c.Flags().Bool("hegel-api", false, "Toggle to true to enable Hegel's new experimental API. Default is false.")
+	c.Flags().Bool("metadata-api", true, "Toggle serving the EC2, OpenStack, GCE and NoCloud compatible metadata APIs")
+	c.Flags().Bool("hegel-api", false, "Toggle to true to enable Hegel's new experimental API under /v0 alongside --metadata-api. Default is false.")
+
+	c.Flags().Int("admin-port", 0, "Port to listen on for admin API requests that inspect any machine's metadata; 0 disables the admin API")
+	c.Flags().String("admin-token-file", "", "Path to a file containing the bearer token admin API requests may authenticate with. Requires --admin-tls-cert")
+	c.Flags().String("admin-tls-cert", "", "Path of a TLS certificate for the admin API")
+	c.Flags().String("admin-tls-key", "", "Path to the private key for --admin-tls-cert")
+	c.Flags().String("admin-client-ca", "", "Path to a PEM encoded CA bundle; admin API requests with a client certificate it signed are authenticated. Requires --admin-tls-cert")
 
 	if err := c.vpr.BindPFlags(c.Flags()); err != nil {
 		return err
@@ -279,5 +408,29 @@ func (c *RootCommand) validateOpts() error {
 		}
 	}
 
+	if c.Opts.NoCloudPrefix != "" && !strings.HasPrefix(c.Opts.NoCloudPrefix, "/") {
+		return errors.New("--nocloud-prefix must start with '/'")
+	}
+
+	if (c.Opts.EC2IdentityKeyPath == "") != (c.Opts.EC2IdentityCertPath == "") {
+		return errors.New("--ec2-identity-key and --ec2-identity-cert must be specified together")
+	}
+
+	if err := http.ValidateEC2TokenMode(http.EC2TokenMode(c.Opts.EC2TokenMode)); err != nil {
+		return errors.Errorf("--ec2-token-mode: %v", err)
+	}
+
+	if _, err := allowlist.Parse(c.Opts.AllowedSources); err != nil {
+		return errors.Errorf("--allowed-sources: %v", err)
+	}
+
+	if c.Opts.ShutdownTimeout < 0 {
+		return errors.New("--shutdown-timeout must not be negative")
+	}
+
+	if err := c.Opts.requestLimits().Validate(); err != nil {
+		return errors.Errorf("invalid http limits: %v", err)
+	}
+
 	return nil
 }
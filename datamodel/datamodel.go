@@ -8,4 +8,5 @@ const (
 	Cacher     DataModel = ""
 	TinkServer DataModel = "1"
 	Kubernetes DataModel = "kubernetes"
+	File       DataModel = "file"
 )
@@ -49,8 +49,8 @@ func (zt *zapT) Errorf(format string, args ...interface{}) {
 // startServersAndConnectClient starts 2 grpc services.
 // First is a fake upstream cacher (fakeServer) that just sends back the provided interface and error.
 // Second is an instance of hegel that uses fakeServer as its upstream.
-// A hegel client connected to the second server is returned.
-func startServersAndConnectClient(t *testing.T, d map[string]string, err error) (context.Context, context.CancelFunc, cacher.CacherClient, hegel.HegelClient) {
+// A hegel client connected to the second server is returned alongside the hegel server.
+func startServersAndConnectClient(t *testing.T, d map[string]string, err error) (context.Context, context.CancelFunc, cacher.CacherClient, hegel.HegelClient, *Server) {
 	t.Helper()
 
 	ctx, cancelCtx := context.WithCancel(context.Background())
@@ -115,7 +115,7 @@ func startServersAndConnectClient(t *testing.T, d map[string]string, err error)
 	client := hegel.NewHegelClient(startServerAndConnectClient(name, server))
 	assert.NotNil(t, client)
 
-	return ctx, cancel, cClient, client
+	return ctx, cancel, cClient, client, hegelServer
 }
 
 func assertGRPCError(t *testing.T, errWanted, errGot error) {
@@ -13,6 +13,7 @@ import (
 	"github.com/packethost/pkg/log"
 	"github.com/pkg/errors"
 	"github.com/prometheus/client_golang/prometheus"
+	"github.com/tinkerbell/hegel/allowlist"
 	"github.com/tinkerbell/hegel/grpc/protos/hegel"
 	"github.com/tinkerbell/hegel/hardware"
 	"github.com/tinkerbell/hegel/metrics"
@@ -21,6 +22,7 @@ import (
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/metadata"
 	"google.golang.org/grpc/peer"
 	"google.golang.org/grpc/status"
 )
@@ -33,6 +35,10 @@ type Server struct {
 
 	subscriptionMu *sync.RWMutex
 	subscriptions  map[string]*Subscription
+
+	// done is closed when the server shuts down to end subscriptions.
+	done     chan struct{}
+	doneOnce *sync.Once
 }
 
 type Subscription struct {
@@ -50,33 +56,51 @@ func NewServer(l log.Logger, hc hardware.Client) *Server {
 		hardwareClient: hc,
 		subscriptionMu: &sync.RWMutex{},
 		subscriptions:  make(map[string]*Subscription),
+		done:           make(chan struct{}),
+		doneOnce:       &sync.Once{},
 	}
 }
 
-func Serve(_ context.Context, l log.Logger, srv *Server, port int, unparsedProxies, tlsCertPath, tlsKeyPath string, useTLS bool) error {
+// endSubscriptions ends active and future subscriptions with codes.Unavailable so their clients reconnect to another
+// server.
+func (s *Server) endSubscriptions() {
+	s.doneOnce.Do(func() { close(s.done) })
+}
+
+// Serve serves srv on port until ctx is done. It then stops accepting connections, ends subscriptions so their clients
+// reconnect elsewhere and waits up to shutdownTimeout for in-flight calls to finish before closing their connections.
+func Serve(ctx context.Context, l log.Logger, srv *Server, port int, unparsedProxies, unparsedAllowedSources, tlsCertPath, tlsKeyPath string, useTLS bool, shutdownTimeout time.Duration) error {
 	serverOpts := make([]grpc.ServerOption, 0)
 
 	if useTLS {
 		creds, err := credentials.NewServerTLSFromFile(tlsCertPath, tlsKeyPath)
 		if err != nil {
-			l.Error(err, "failed to initialize server credentials")
-			panic(err)
+			return errors.Wrap(err, "failed to initialize server credentials")
 		}
 		serverOpts = append(serverOpts, grpc.Creds(creds))
 	}
 
 	proxies := xff.ParseTrustedProxies(unparsedProxies)
 	xffStream, xffUnary := xff.GRPCMiddlewares(l, proxies)
+
+	allowed, err := allowlist.Parse(unparsedAllowedSources)
+	if err != nil {
+		return errors.Wrap(err, "failed to parse allowed sources")
+	}
+	allowedStream, allowedUnary := allowlist.GRPCInterceptors(allowed)
+
 	streamLogger, unaryLogger := l.GRPCLoggers()
 	serverOpts = append(serverOpts,
 		grpcmiddleware.WithUnaryServerChain(
 			xffUnary,
+			allowedUnary,
 			unaryLogger,
 			grpcprometheus.UnaryServerInterceptor,
 			otelgrpc.UnaryServerInterceptor(),
 		),
 		grpcmiddleware.WithStreamServerChain(
 			xffStream,
+			allowedStream,
 			streamLogger,
 			grpcprometheus.StreamServerInterceptor,
 			otelgrpc.StreamServerInterceptor(),
@@ -91,19 +115,45 @@ func Serve(_ context.Context, l log.Logger, srv *Server, port int, unparsedProxi
 
 	lis, err := net.Listen("tcp", fmt.Sprintf(":%d", port))
 	if err != nil {
-		err = errors.Wrap(err, "failed to listen")
-		l.Error(err)
-		panic(err)
+		return errors.Wrap(err, "failed to listen")
 	}
 
 	metrics.State.Set(metrics.Ready)
 	l.Info("serving grpc")
-	err = grpcServer.Serve(lis)
-	if err != nil {
-		l.Fatal(err, "failed to serve grpc")
+
+	errs := make(chan error, 1)
+	go func() {
+		errs <- grpcServer.Serve(lis)
+	}()
+
+	select {
+	case <-ctx.Done():
+	case err := <-errs:
+		return errors.Wrap(err, "failed to serve grpc")
 	}
 
-	return nil
+	l.With("timeout", shutdownTimeout).Info("shutting down grpc")
+
+	stopped := make(chan struct{})
+	go func() {
+		grpcServer.GracefulStop()
+		close(stopped)
+	}()
+
+	// Subscriptions last as long as their client is connected so GracefulStop would wait for the timeout without
+	// ending them.
+	srv.endSubscriptions()
+
+	timer := time.NewTimer(shutdownTimeout)
+	defer timer.Stop()
+
+	select {
+	case <-stopped:
+		return nil
+	case <-timer.C:
+		grpcServer.Stop()
+		return errors.New("timed out waiting for grpc calls to finish")
+	}
 }
 
 func (s *Server) Subscription(id string) (*Subscription, error) {
@@ -126,6 +176,17 @@ func peerIP(a net.Addr) string {
 	return a.String()
 }
 
+// lookupHardware retrieves the hardware of the peer with ip. A MAC supplied by a trusted proxy or relay in the
+// xff.ClientMACMetadataKey takes precedence over ip.
+func (s *Server) lookupHardware(ctx context.Context, ip string) (hardware.Hardware, error) {
+	if md, ok := metadata.FromIncomingContext(ctx); ok {
+		if macs := md.Get(xff.ClientMACMetadataKey); len(macs) > 0 && macs[0] != "" {
+			return s.hardwareClient.ByMAC(ctx, macs[0])
+		}
+	}
+	return s.hardwareClient.ByIP(ctx, ip)
+}
+
 func (s *Server) Get(ctx context.Context, _ *hegel.GetRequest) (*hegel.GetResponse, error) {
 	p, ok := peer.FromContext(ctx)
 	if !ok {
@@ -135,7 +196,7 @@ func (s *Server) Get(ctx context.Context, _ *hegel.GetRequest) (*hegel.GetRespon
 
 	ip := peerIP(p.Addr)
 
-	hw, err := s.hardwareClient.ByIP(ctx, ip)
+	hw, err := s.lookupHardware(ctx, ip)
 	if err != nil {
 		return nil, err
 	}
@@ -175,7 +236,7 @@ func (s *Server) Subscribe(_ *hegel.SubscribeRequest, stream hegel.Hegel_Subscri
 
 	logger.Info()
 
-	hw, err := s.hardwareClient.ByIP(stream.Context(), ip)
+	hw, err := s.lookupHardware(stream.Context(), ip)
 	if err != nil {
 		return handleError(err)
 	}
@@ -237,7 +298,8 @@ func (s *Server) Subscribe(_ *hegel.SubscribeRequest, stream hegel.Hegel_Subscri
 		return err
 	}
 
-	errs := make(chan error, 1)
+	// Both the receiving and sending goroutines may report an error after Subscribe stopped waiting for one.
+	errs := make(chan error, 2)
 	go func() {
 		for {
 			hw, err := watch.Recv()
@@ -276,8 +338,16 @@ func (s *Server) Subscribe(_ *hegel.SubscribeRequest, stream hegel.Hegel_Subscri
 	}()
 
 	var retErr error
-	if err := <-errs; status.Code(err) != codes.OK && retErr == nil {
-		retErr = err
+	select {
+	case err := <-errs:
+		if status.Code(err) != codes.OK && retErr == nil {
+			retErr = err
+		}
+	case <-s.done:
+		cancel()
+		logger.Info("ending subscription as the server is shutting down")
+		metrics.Subscriptions.WithLabelValues("active").Dec()
+		return status.Error(codes.Unavailable, "server is shutting down")
 	}
 	return activeError(retErr)
 }
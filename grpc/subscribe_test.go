@@ -17,7 +17,7 @@ import (
 func TestSubscribe(t *testing.T) {
 	t.Run("error", func(t *testing.T) {
 		terr := status.Error(codes.Unknown, "error pushing")
-		ctx, cancel, _, client := startServersAndConnectClient(t, nil, terr)
+		ctx, cancel, _, client, _ := startServersAndConnectClient(t, nil, terr)
 		defer cancel()
 
 		w, err := client.Subscribe(ctx, &hegel.SubscribeRequest{ID: "doesn't matter"})
@@ -37,7 +37,7 @@ func TestSubscribe(t *testing.T) {
 			id: value,
 		}
 
-		ctx, cancel, cClient, hClient := startServersAndConnectClient(t, data, nil)
+		ctx, cancel, cClient, hClient, _ := startServersAndConnectClient(t, data, nil)
 		defer cancel()
 
 		w, err := hClient.Subscribe(ctx, &hegel.SubscribeRequest{ID: id})
@@ -98,4 +98,23 @@ func TestSubscribe(t *testing.T) {
 
 		assert.Equal(t, 42, count)
 	})
+
+	t.Run("shutdown", func(t *testing.T) {
+		id := "bufconn"
+		data := map[string]string{
+			id: fmt.Sprintf(`{"id": "%s", "ip": "%s"}`, id, id),
+		}
+
+		ctx, cancel, _, client, server := startServersAndConnectClient(t, data, nil)
+		defer cancel()
+
+		w, err := client.Subscribe(ctx, &hegel.SubscribeRequest{ID: id})
+		assert.NoError(t, err)
+
+		server.endSubscriptions()
+
+		hw, err := w.Recv()
+		assert.Equal(t, codes.Unavailable, status.Code(err))
+		assert.Nil(t, hw)
+	})
 }
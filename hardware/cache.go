@@ -0,0 +1,192 @@
+package hardware
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync"
+	"time"
+)
+
+// cacheLookupTimeout bounds lookups made by the CachingClient. Lookups aren't bound to the context of the caller that
+// triggers them because they're shared with concurrent callers.
+const cacheLookupTimeout = 30 * time.Second
+
+var (
+	_ Client            = &CachingClient{}
+	_ PhoneHomeRecorder = &CachingClient{}
+)
+
+// CachingClient wraps a Client caching the hardware returned by ByIP, ByMAC and ByID. Lookups that fail with ErrNotFound
+// are cached separately so unknown machines don't reach the data provider on every request. Concurrent lookups for
+// the same key are collapsed into a single call to the wrapped Client.
+//
+// Cached hardware also caches its Export() so the result is computed once per cache entry.
+type CachingClient struct {
+	client      Client
+	ttl         time.Duration
+	negativeTTL time.Duration
+	now         func() time.Time
+
+	mu        sync.Mutex
+	entries   map[string]cacheEntry
+	inflight  map[string]*cacheCall
+	nextSweep time.Time
+}
+
+// cacheEntry is the cached result of a lookup.
+type cacheEntry struct {
+	hw      Hardware
+	err     error
+	expires time.Time
+}
+
+// cacheCall is a lookup in progress that concurrent lookups for the same key wait on.
+type cacheCall struct {
+	done chan struct{}
+	hw   Hardware
+	err  error
+}
+
+// NewCachingClient creates a CachingClient that caches hardware found by client for ttl and lookups that fail with
+// ErrNotFound for negativeTTL. A negativeTTL of 0 disables negative caching.
+func NewCachingClient(client Client, ttl, negativeTTL time.Duration) *CachingClient {
+	return &CachingClient{
+		client:      client,
+		ttl:         ttl,
+		negativeTTL: negativeTTL,
+		now:         time.Now,
+		entries:     make(map[string]cacheEntry),
+		inflight:    make(map[string]*cacheCall),
+	}
+}
+
+// IsHealthy reports the health of the wrapped client.
+func (c *CachingClient) IsHealthy(ctx context.Context) bool {
+	return c.client.IsHealthy(ctx)
+}
+
+// ByIP retrieves the hardware with ip from the cache, or the wrapped client if it isn't cached.
+func (c *CachingClient) ByIP(ctx context.Context, ip string) (Hardware, error) {
+	return c.lookup(ctx, "ip/"+ip, func(ctx context.Context) (Hardware, error) {
+		return c.client.ByIP(ctx, ip)
+	})
+}
+
+// ByMAC retrieves the hardware with mac from the cache, or the wrapped client if it isn't cached.
+func (c *CachingClient) ByMAC(ctx context.Context, mac string) (Hardware, error) {
+	return c.lookup(ctx, "mac/"+strings.ToLower(mac), func(ctx context.Context) (Hardware, error) {
+		return c.client.ByMAC(ctx, mac)
+	})
+}
+
+// ByID retrieves the hardware with id from the cache, or the wrapped client if it isn't cached.
+func (c *CachingClient) ByID(ctx context.Context, id string) (Hardware, error) {
+	return c.lookup(ctx, "id/"+id, func(ctx context.Context) (Hardware, error) {
+		return c.client.ByID(ctx, id)
+	})
+}
+
+// Watch watches id using the wrapped client. Watches aren't cached.
+func (c *CachingClient) Watch(ctx context.Context, id string) (Watcher, error) {
+	return c.client.Watch(ctx, id)
+}
+
+// RecordPhoneHome records report with the wrapped client if it records phone homes.
+func (c *CachingClient) RecordPhoneHome(ctx context.Context, id string, report PhoneHome) error {
+	if recorder, ok := c.client.(PhoneHomeRecorder); ok {
+		return recorder.RecordPhoneHome(ctx, id, report)
+	}
+	return nil
+}
+
+// lookup returns the cached result for key or calls fetch. Concurrent lookups for key wait on a single call to fetch
+// that isn't cancelled when any one caller's ctx is done; callers stop waiting when their own ctx is done.
+func (c *CachingClient) lookup(ctx context.Context, key string, fetch func(context.Context) (Hardware, error)) (Hardware, error) {
+	c.mu.Lock()
+	now := c.now()
+	if entry, ok := c.entries[key]; ok {
+		if now.Before(entry.expires) {
+			c.mu.Unlock()
+			return entry.hw, entry.err
+		}
+		delete(c.entries, key)
+	}
+
+	call, ok := c.inflight[key]
+	if !ok {
+		call = &cacheCall{done: make(chan struct{})}
+		c.inflight[key] = call
+		go c.fetch(key, call, fetch)
+	}
+	c.mu.Unlock()
+
+	select {
+	case <-call.done:
+		return call.hw, call.err
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// fetch completes call using fetch and caches the result under key.
+func (c *CachingClient) fetch(key string, call *cacheCall, fetch func(context.Context) (Hardware, error)) {
+	ctx, cancel := context.WithTimeout(context.Background(), cacheLookupTimeout)
+	defer cancel()
+
+	hw, err := fetch(ctx)
+	if err == nil {
+		hw = &cachedHardware{Hardware: hw}
+	}
+
+	c.mu.Lock()
+	call.hw, call.err = hw, err
+	delete(c.inflight, key)
+	c.store(key, hw, err)
+	c.mu.Unlock()
+	close(call.done)
+}
+
+// store caches the result of a lookup if its cacheable. Only found hardware and ErrNotFound errors are cached; other
+// errors are likely transient. It periodically removes expired entries so the cache doesn't grow unbounded with
+// entries that are never looked up again. c.mu must be held.
+func (c *CachingClient) store(key string, hw Hardware, err error) {
+	now := c.now()
+
+	if now.After(c.nextSweep) {
+		for k, entry := range c.entries {
+			if !now.Before(entry.expires) {
+				delete(c.entries, k)
+			}
+		}
+		c.nextSweep = now.Add(c.ttl)
+	}
+
+	switch {
+	case err == nil:
+		if c.ttl > 0 {
+			c.entries[key] = cacheEntry{hw: hw, expires: now.Add(c.ttl)}
+		}
+	case errors.Is(err, ErrNotFound):
+		if c.negativeTTL > 0 {
+			c.entries[key] = cacheEntry{err: err, expires: now.Add(c.negativeTTL)}
+		}
+	}
+}
+
+// cachedHardware memoizes the Export() of the wrapped Hardware.
+type cachedHardware struct {
+	Hardware
+
+	once     sync.Once
+	exported []byte
+	err      error
+}
+
+// Export returns the result of the wrapped Hardware's Export(), calling it only once.
+func (hw *cachedHardware) Export() ([]byte, error) {
+	hw.once.Do(func() {
+		hw.exported, hw.err = hw.Hardware.Export()
+	})
+	return hw.exported, hw.err
+}
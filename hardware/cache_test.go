@@ -0,0 +1,200 @@
+package hardware_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/tinkerbell/hegel/hardware"
+)
+
+// countingClient is a hardware.Client that counts ByIP, ByMAC and ByID calls. ByIP blocks until release is closed, if set.
+type countingClient struct {
+	staticClient
+	calls   int32
+	err     error
+	release chan struct{}
+}
+
+func (c *countingClient) ByIP(ctx context.Context, ip string) (hardware.Hardware, error) {
+	atomic.AddInt32(&c.calls, 1)
+	if c.release != nil {
+		<-c.release
+	}
+	if c.err != nil {
+		return nil, c.err
+	}
+	return c.staticClient.ByIP(ctx, ip)
+}
+
+func (c *countingClient) ByMAC(ctx context.Context, mac string) (hardware.Hardware, error) {
+	atomic.AddInt32(&c.calls, 1)
+	return c.staticClient.ByMAC(ctx, mac)
+}
+
+func (c *countingClient) ByID(ctx context.Context, id string) (hardware.Hardware, error) {
+	atomic.AddInt32(&c.calls, 1)
+	return c.staticClient.ByID(ctx, id)
+}
+
+// fakeClock is a settable clock for the CachingClient.
+type fakeClock struct {
+	mu  sync.Mutex
+	now time.Time
+}
+
+func (c *fakeClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *fakeClock) Advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.now = c.now.Add(d)
+}
+
+func TestCachingClientCachesHardware(t *testing.T) {
+	backend := &countingClient{staticClient: staticClient{hardware: map[string]hardware.Hardware{
+		"10.0.0.1": newTestHardware("instance-1"),
+	}}}
+	client := hardware.NewCachingClient(backend, time.Minute, 0)
+	clock := &fakeClock{now: time.Now()}
+	client.SetNow(clock.Now)
+
+	for i := 0; i < 3; i++ {
+		hw, err := client.ByIP(context.Background(), "10.0.0.1")
+		require.NoError(t, err)
+
+		id, err := hw.ID()
+		require.NoError(t, err)
+		assert.Equal(t, "instance-1", id)
+	}
+	assert.EqualValues(t, 1, atomic.LoadInt32(&backend.calls))
+
+	clock.Advance(2 * time.Minute)
+
+	_, err := client.ByIP(context.Background(), "10.0.0.1")
+	require.NoError(t, err)
+	assert.EqualValues(t, 2, atomic.LoadInt32(&backend.calls))
+}
+
+func TestCachingClientNegativeCaching(t *testing.T) {
+	tests := map[string]struct {
+		negativeTTL time.Duration
+		err         error
+		calls       int32
+	}{
+		"not found cached": {
+			negativeTTL: time.Minute,
+			calls:       1,
+		},
+		"negative caching disabled": {
+			calls: 2,
+		},
+		"other errors not cached": {
+			negativeTTL: time.Minute,
+			err:         errors.New("connection refused"),
+			calls:       2,
+		},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			backend := &countingClient{err: test.err}
+			client := hardware.NewCachingClient(backend, time.Minute, test.negativeTTL)
+
+			for i := 0; i < 2; i++ {
+				_, err := client.ByIP(context.Background(), "10.0.0.1")
+				require.Error(t, err)
+			}
+			assert.Equal(t, test.calls, atomic.LoadInt32(&backend.calls))
+		})
+	}
+}
+
+func TestCachingClientCoalescesLookups(t *testing.T) {
+	backend := &countingClient{
+		staticClient: staticClient{hardware: map[string]hardware.Hardware{
+			"10.0.0.1": newTestHardware("instance-1"),
+		}},
+		release: make(chan struct{}),
+	}
+	client := hardware.NewCachingClient(backend, time.Minute, 0)
+
+	var wg sync.WaitGroup
+	errs := make(chan error, 10)
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := client.ByIP(context.Background(), "10.0.0.1"); err != nil {
+				errs <- fmt.Errorf("lookup: %w", err)
+			}
+		}()
+	}
+
+	// Give the lookups a chance to queue behind the first.
+	time.Sleep(50 * time.Millisecond)
+	close(backend.release)
+	wg.Wait()
+	close(errs)
+
+	for err := range errs {
+		assert.NoError(t, err)
+	}
+	assert.EqualValues(t, 1, atomic.LoadInt32(&backend.calls))
+}
+
+func TestCachingClientNormalizesMAC(t *testing.T) {
+	backend := &countingClient{}
+	client := hardware.NewCachingClient(backend, time.Minute, time.Minute)
+
+	_, err := client.ByMAC(context.Background(), "AA:BB:CC:DD:EE:FF")
+	require.Error(t, err)
+
+	_, err = client.ByMAC(context.Background(), "aa:bb:cc:dd:ee:ff")
+	require.Error(t, err)
+
+	assert.EqualValues(t, 1, atomic.LoadInt32(&backend.calls))
+}
+
+func TestCachingClientCancelledCaller(t *testing.T) {
+	backend := &countingClient{
+		staticClient: staticClient{hardware: map[string]hardware.Hardware{
+			"10.0.0.1": newTestHardware("instance-1"),
+		}},
+		release: make(chan struct{}),
+	}
+	client := hardware.NewCachingClient(backend, time.Minute, 0)
+
+	// The first caller gives up while the lookup is in flight.
+	ctx, cancel := context.WithCancel(context.Background())
+	first := make(chan error)
+	go func() {
+		_, err := client.ByIP(ctx, "10.0.0.1")
+		first <- err
+	}()
+
+	second := make(chan error)
+	go func() {
+		_, err := client.ByIP(context.Background(), "10.0.0.1")
+		second <- err
+	}()
+
+	// Give the lookups a chance to queue behind the first.
+	time.Sleep(50 * time.Millisecond)
+	cancel()
+	assert.ErrorIs(t, <-first, context.Canceled)
+
+	close(backend.release)
+	assert.NoError(t, <-second)
+	assert.EqualValues(t, 1, atomic.LoadInt32(&backend.calls))
+}
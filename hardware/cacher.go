@@ -3,6 +3,7 @@ package hardware
 import (
 	"context"
 	"encoding/json"
+	"fmt"
 
 	"github.com/packethost/cacher/protos/cacher"
 	"github.com/pkg/errors"
@@ -43,7 +44,43 @@ func (hg clientCacher) ByIP(ctx context.Context, ip string) (Hardware, error) {
 	}
 	hw, err := hg.client.ByIP(ctx, in)
 	if err != nil {
-		return nil, err
+		return nil, wrapNotFound(err)
+	}
+	// Cacher responds with empty hardware when it has no hardware with the IP.
+	if hw.JSON == "" {
+		return nil, fmt.Errorf("%w: no hardware with ip '%v'", ErrNotFound, ip)
+	}
+	return &Cacher{hw}, nil
+}
+
+// ByMAC retrieves from Cacher the piece of hardware with the specified MAC.
+func (hg clientCacher) ByMAC(ctx context.Context, mac string) (Hardware, error) {
+	in := &cacher.GetRequest{
+		MAC: mac,
+	}
+	hw, err := hg.client.ByMAC(ctx, in)
+	if err != nil {
+		return nil, wrapNotFound(err)
+	}
+	// Cacher responds with empty hardware when it has no hardware with the MAC.
+	if hw.JSON == "" {
+		return nil, fmt.Errorf("%w: no hardware with mac '%v'", ErrNotFound, mac)
+	}
+	return &Cacher{hw}, nil
+}
+
+// ByID retrieves from Cacher the piece of hardware with the specified ID.
+func (hg clientCacher) ByID(ctx context.Context, id string) (Hardware, error) {
+	in := &cacher.GetRequest{
+		ID: id,
+	}
+	hw, err := hg.client.ByID(ctx, in)
+	if err != nil {
+		return nil, wrapNotFound(err)
+	}
+	// Cacher responds with empty hardware when it has no hardware with the ID.
+	if hw.JSON == "" {
+		return nil, fmt.Errorf("%w: no hardware with id '%v'", ErrNotFound, id)
 	}
 	return &Cacher{hw}, nil
 }
@@ -0,0 +1,44 @@
+package hardware_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/packethost/cacher/protos/cacher"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/tinkerbell/hegel/datamodel"
+	"github.com/tinkerbell/hegel/hardware"
+	"google.golang.org/grpc"
+)
+
+// cacherClientMock responds to ByIP and ByMAC with the hardware in data, or empty hardware like Cacher does when it
+// has no matching hardware.
+type cacherClientMock struct {
+	cacher.CacherClient
+	data map[string]string
+}
+
+func (c cacherClientMock) ByIP(_ context.Context, in *cacher.GetRequest, _ ...grpc.CallOption) (*cacher.Hardware, error) {
+	return &cacher.Hardware{JSON: c.data[in.IP]}, nil
+}
+
+func (c cacherClientMock) ByMAC(_ context.Context, in *cacher.GetRequest, _ ...grpc.CallOption) (*cacher.Hardware, error) {
+	return &cacher.Hardware{JSON: c.data[in.MAC]}, nil
+}
+
+func TestCacherClientNotFound(t *testing.T) {
+	client, err := hardware.NewCacherClient(cacherClientMock{data: map[string]string{
+		"10.0.0.1": `{"id":"instance-1"}`,
+	}}, datamodel.Cacher)
+	require.NoError(t, err)
+
+	_, err = client.ByIP(context.Background(), "10.0.0.1")
+	require.NoError(t, err)
+
+	_, err = client.ByIP(context.Background(), "10.0.0.2")
+	assert.ErrorIs(t, err, hardware.ErrNotFound)
+
+	_, err = client.ByMAC(context.Background(), "00:00:00:00:00:01")
+	assert.ErrorIs(t, err, hardware.ErrNotFound)
+}
@@ -0,0 +1,196 @@
+package hardware
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+)
+
+var (
+	_ Client            = &ChainClient{}
+	_ PhoneHomeRecorder = &ChainClient{}
+)
+
+// ChainClient is a Client that queries an ordered list of Clients and returns the first hardware found. It lets a
+// single Hegel serve hardware from multiple data providers, for example, while migrating between them.
+type ChainClient struct {
+	clients []Client
+}
+
+// NewChainClient creates a ChainClient that queries clients in the order they're specified.
+func NewChainClient(clients ...Client) *ChainClient {
+	return &ChainClient{clients: clients}
+}
+
+// IsHealthy returns true if all chained clients are healthy.
+func (c *ChainClient) IsHealthy(ctx context.Context) bool {
+	for _, client := range c.clients {
+		if !client.IsHealthy(ctx) {
+			return false
+		}
+	}
+	return true
+}
+
+// ByIP retrieves the hardware with ip from the first chained client that has it.
+func (c *ChainClient) ByIP(ctx context.Context, ip string) (Hardware, error) {
+	return c.first(fmt.Sprintf("ip '%v'", ip), func(client Client) (Hardware, error) {
+		return client.ByIP(ctx, ip)
+	})
+}
+
+// ByMAC retrieves the hardware with mac from the first chained client that has it.
+func (c *ChainClient) ByMAC(ctx context.Context, mac string) (Hardware, error) {
+	return c.first(fmt.Sprintf("mac '%v'", mac), func(client Client) (Hardware, error) {
+		return client.ByMAC(ctx, mac)
+	})
+}
+
+// ByID retrieves the hardware with id from the first chained client that has it.
+func (c *ChainClient) ByID(ctx context.Context, id string) (Hardware, error) {
+	return c.first(fmt.Sprintf("id '%v'", id), func(client Client) (Hardware, error) {
+		return client.ByID(ctx, id)
+	})
+}
+
+// first calls lookup for each chained client in order returning the first hardware found. It only moves on to the
+// next client when a client reports ErrNotFound; any other error is returned immediately so an unavailable client
+// doesn't expose hardware it should shadow from later clients. desc describes the lookup for errors.
+func (c *ChainClient) first(desc string, lookup func(Client) (Hardware, error)) (Hardware, error) {
+	var misses []string
+	for i, client := range c.clients {
+		hw, err := lookup(client)
+		if err == nil {
+			return hw, nil
+		}
+
+		if !errors.Is(err, ErrNotFound) {
+			return nil, fmt.Errorf("client %d: %w", i, err)
+		}
+
+		misses = append(misses, fmt.Sprintf("client %d: %v", i, err))
+	}
+
+	return nil, fmt.Errorf("%w: no hardware with %v: [%v]", ErrNotFound, desc, strings.Join(misses, "; "))
+}
+
+// RecordPhoneHome records report with the first chained client that records phone homes and has the hardware with id.
+// Like lookups, it only moves on to the next client when a client reports ErrNotFound. If no client has the hardware
+// the report isn't recorded and no error is returned.
+func (c *ChainClient) RecordPhoneHome(ctx context.Context, id string, report PhoneHome) error {
+	for i, client := range c.clients {
+		recorder, ok := client.(PhoneHomeRecorder)
+		if !ok {
+			continue
+		}
+
+		err := recorder.RecordPhoneHome(ctx, id, report)
+		if err == nil {
+			return nil
+		}
+
+		if !errors.Is(err, ErrNotFound) {
+			return fmt.Errorf("client %d: %w", i, err)
+		}
+	}
+
+	return nil
+}
+
+// Watch watches id on all chained clients and merges their streams. Its expected a single client serves id so other
+// clients' streams typically never receive an update. If any client fails to watch id an error is returned. The
+// merged stream ends once all chained streams have ended, returning the error from the last stream to end.
+func (c *ChainClient) Watch(ctx context.Context, id string) (Watcher, error) {
+	// watchCtx lets us end the streams of clients that were successfully watched if a later client fails.
+	watchCtx, cancel := context.WithCancel(ctx)
+
+	var watchers []Watcher
+	for i, client := range c.clients {
+		w, err := client.Watch(watchCtx, id)
+		if err != nil {
+			cancel()
+			return nil, fmt.Errorf("client %d: %w", i, err)
+		}
+		watchers = append(watchers, w)
+	}
+
+	return newChainWatcher(ctx, cancel, watchers), nil
+}
+
+// chainWatcher merges the streams of multiple Watchers.
+type chainWatcher struct {
+	ctx     context.Context
+	cancel  context.CancelFunc
+	updates chan Hardware
+	done    chan struct{}
+
+	mu  sync.Mutex
+	err error
+}
+
+// newChainWatcher merges watchers. cancel is called once all watchers' streams have ended.
+func newChainWatcher(ctx context.Context, cancel context.CancelFunc, watchers []Watcher) *chainWatcher {
+	w := &chainWatcher{
+		ctx:     ctx,
+		cancel:  cancel,
+		updates: make(chan Hardware),
+		done:    make(chan struct{}),
+	}
+
+	var wg sync.WaitGroup
+	wg.Add(len(watchers))
+	for _, watcher := range watchers {
+		go func(watcher Watcher) {
+			defer wg.Done()
+			w.forward(watcher)
+		}(watcher)
+	}
+
+	go func() {
+		wg.Wait()
+		close(w.done)
+		w.cancel()
+	}()
+
+	return w
+}
+
+// forward sends updates from watcher to w until watcher's stream ends or w's context is done.
+func (w *chainWatcher) forward(watcher Watcher) {
+	for {
+		hw, err := watcher.Recv()
+		if err != nil {
+			w.mu.Lock()
+			w.err = err
+			w.mu.Unlock()
+			return
+		}
+
+		select {
+		case w.updates <- hw:
+		case <-w.ctx.Done():
+			return
+		}
+	}
+}
+
+// Recv blocks until any of the merged streams receives an update, all merged streams end or the Watcher's context is
+// done.
+func (w *chainWatcher) Recv() (Hardware, error) {
+	select {
+	case hw := <-w.updates:
+		return hw, nil
+	case <-w.done:
+		w.mu.Lock()
+		defer w.mu.Unlock()
+		if w.err == nil {
+			// Streams only end without an error when the context is done.
+			return nil, w.ctx.Err()
+		}
+		return nil, w.err
+	case <-w.ctx.Done():
+		return nil, w.ctx.Err()
+	}
+}
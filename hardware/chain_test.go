@@ -0,0 +1,241 @@
+package hardware_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/tinkerbell/hegel/datamodel"
+	"github.com/tinkerbell/hegel/hardware"
+	tinkv1alpha1 "github.com/tinkerbell/tink/pkg/apis/core/v1alpha1"
+)
+
+// staticClient is a hardware.Client serving a fixed set of hardware indexed by IP. If err is set its returned from all
+// lookups and watches.
+type staticClient struct {
+	healthy  bool
+	hardware map[string]hardware.Hardware
+	watcher  hardware.Watcher
+	err      error
+}
+
+func (c staticClient) IsHealthy(context.Context) bool {
+	return c.healthy
+}
+
+func (c staticClient) ByIP(_ context.Context, ip string) (hardware.Hardware, error) {
+	if c.err != nil {
+		return nil, c.err
+	}
+	hw, ok := c.hardware[ip]
+	if !ok {
+		return nil, fmt.Errorf("%w: no hardware with ip '%v'", hardware.ErrNotFound, ip)
+	}
+	return hw, nil
+}
+
+func (c staticClient) ByMAC(_ context.Context, mac string) (hardware.Hardware, error) {
+	if c.err != nil {
+		return nil, c.err
+	}
+	return nil, fmt.Errorf("%w: no hardware with mac '%v'", hardware.ErrNotFound, mac)
+}
+
+func (c staticClient) ByID(_ context.Context, id string) (hardware.Hardware, error) {
+	if c.err != nil {
+		return nil, c.err
+	}
+	for _, hw := range c.hardware {
+		if hwID, _ := hw.ID(); hwID == id {
+			return hw, nil
+		}
+	}
+	return nil, fmt.Errorf("%w: no hardware with id '%v'", hardware.ErrNotFound, id)
+}
+
+func (c staticClient) Watch(context.Context, string) (hardware.Watcher, error) {
+	if c.err != nil {
+		return nil, c.err
+	}
+	return c.watcher, nil
+}
+
+// sliceWatcher is a hardware.Watcher that streams a fixed set of hardware followed by io.EOF.
+type sliceWatcher struct {
+	hardware []hardware.Hardware
+}
+
+func (w *sliceWatcher) Recv() (hardware.Hardware, error) {
+	if len(w.hardware) == 0 {
+		return nil, io.EOF
+	}
+	hw := w.hardware[0]
+	w.hardware = w.hardware[1:]
+	return hw, nil
+}
+
+func newTestHardware(id string) hardware.Hardware {
+	return hardware.FromK8sTinkHardware(&tinkv1alpha1.Hardware{
+		Spec: tinkv1alpha1.HardwareSpec{
+			Metadata: &tinkv1alpha1.HardwareMetadata{
+				Facility: &tinkv1alpha1.MetadataFacility{},
+				Instance: &tinkv1alpha1.MetadataInstance{
+					ID:              id,
+					OperatingSystem: &tinkv1alpha1.MetadataInstanceOperatingSystem{},
+				},
+			},
+		},
+	})
+}
+
+func TestChainClientByIP(t *testing.T) {
+	first := staticClient{healthy: true, hardware: map[string]hardware.Hardware{
+		"10.0.0.1": newTestHardware("first"),
+	}}
+	second := staticClient{healthy: true, hardware: map[string]hardware.Hardware{
+		"10.0.0.1": newTestHardware("second-shadowed"),
+		"10.0.0.2": newTestHardware("second"),
+	}}
+
+	client := hardware.NewChainClient(first, second)
+
+	tests := map[string]string{
+		"10.0.0.1": "first",
+		"10.0.0.2": "second",
+	}
+
+	for ip, expect := range tests {
+		t.Run(ip, func(t *testing.T) {
+			hw, err := client.ByIP(context.Background(), ip)
+			require.NoError(t, err)
+
+			id, err := hw.ID()
+			require.NoError(t, err)
+			assert.Equal(t, expect, id)
+		})
+	}
+
+	_, err := client.ByIP(context.Background(), "10.0.0.3")
+	assert.ErrorIs(t, err, hardware.ErrNotFound)
+}
+
+func TestChainClientByIPError(t *testing.T) {
+	expect := errors.New("connection refused")
+	first := staticClient{err: expect}
+	second := staticClient{hardware: map[string]hardware.Hardware{
+		"10.0.0.1": newTestHardware("second"),
+	}}
+
+	_, err := hardware.NewChainClient(first, second).ByIP(context.Background(), "10.0.0.1")
+	assert.ErrorIs(t, err, expect)
+	assert.False(t, errors.Is(err, hardware.ErrNotFound))
+}
+
+func TestChainClientByID(t *testing.T) {
+	first := staticClient{healthy: true, hardware: map[string]hardware.Hardware{
+		"10.0.0.1": newTestHardware("first"),
+	}}
+	second := staticClient{healthy: true, hardware: map[string]hardware.Hardware{
+		"10.0.0.2": newTestHardware("second"),
+	}}
+
+	client := hardware.NewChainClient(first, second)
+
+	hw, err := client.ByID(context.Background(), "second")
+	require.NoError(t, err)
+
+	id, err := hw.ID()
+	require.NoError(t, err)
+	assert.Equal(t, "second", id)
+
+	_, err = client.ByID(context.Background(), "third")
+	assert.ErrorIs(t, err, hardware.ErrNotFound)
+}
+
+func TestChainClientIsHealthy(t *testing.T) {
+	healthy := staticClient{healthy: true}
+	unhealthy := staticClient{healthy: false}
+
+	assert.True(t, hardware.NewChainClient(healthy, healthy).IsHealthy(context.Background()))
+	assert.False(t, hardware.NewChainClient(healthy, unhealthy).IsHealthy(context.Background()))
+}
+
+func TestChainClientWatch(t *testing.T) {
+	first := staticClient{watcher: &sliceWatcher{}}
+	second := staticClient{watcher: &sliceWatcher{hardware: []hardware.Hardware{newTestHardware("second")}}}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	watcher, err := hardware.NewChainClient(first, second).Watch(ctx, "second")
+	require.NoError(t, err)
+
+	hw, err := watcher.Recv()
+	require.NoError(t, err)
+
+	id, err := hw.ID()
+	require.NoError(t, err)
+	assert.Equal(t, "second", id)
+
+	_, err = watcher.Recv()
+	assert.ErrorIs(t, err, io.EOF)
+}
+
+func TestChainClientWatchError(t *testing.T) {
+	expect := errors.New("connection refused")
+	first := staticClient{watcher: &sliceWatcher{}}
+	second := staticClient{err: expect}
+
+	_, err := hardware.NewChainClient(first, second).Watch(context.Background(), "instance-1")
+	assert.ErrorIs(t, err, expect)
+}
+
+// recordingClient is a staticClient that records phone homes for the IDs in ids.
+type recordingClient struct {
+	staticClient
+	ids     map[string]bool
+	reports map[string]hardware.PhoneHome
+}
+
+func (c recordingClient) RecordPhoneHome(_ context.Context, id string, report hardware.PhoneHome) error {
+	if !c.ids[id] {
+		return fmt.Errorf("%w: no hardware with id '%v'", hardware.ErrNotFound, id)
+	}
+	c.reports[id] = report
+	return nil
+}
+
+func TestChainClientRecordPhoneHome(t *testing.T) {
+	first := recordingClient{ids: map[string]bool{"first": true}, reports: make(map[string]hardware.PhoneHome)}
+	second := recordingClient{ids: map[string]bool{"second": true}, reports: make(map[string]hardware.PhoneHome)}
+
+	client := hardware.NewChainClient(staticClient{}, first, second)
+	report := hardware.PhoneHome{Time: time.Unix(0, 0), IP: "10.0.0.1"}
+
+	require.NoError(t, client.RecordPhoneHome(context.Background(), "second", report))
+	assert.Empty(t, first.reports)
+	assert.Equal(t, map[string]hardware.PhoneHome{"second": report}, second.reports)
+
+	// Hardware no client records phone homes for is ignored.
+	require.NoError(t, client.RecordPhoneHome(context.Background(), "unknown", report))
+}
+
+func TestNewClientFallbackModels(t *testing.T) {
+	_, err := hardware.NewClient(hardware.ClientConfig{
+		Model:          datamodel.Kubernetes,
+		FallbackModels: []datamodel.DataModel{datamodel.Cacher},
+		Facility:       "onprem",
+	})
+	assert.Error(t, err)
+
+	_, err = hardware.NewClient(hardware.ClientConfig{
+		Model:          datamodel.TinkServer,
+		FallbackModels: []datamodel.DataModel{datamodel.File},
+	})
+	assert.Error(t, err)
+}
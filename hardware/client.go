@@ -2,13 +2,30 @@ package hardware
 
 import (
 	"context"
+	"fmt"
+	"time"
 
 	cacher "github.com/packethost/cacher/client"
+	"github.com/packethost/pkg/log"
 	"github.com/pkg/errors"
 	"github.com/tinkerbell/hegel/datamodel"
 	tink "github.com/tinkerbell/tink/client"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
 )
 
+// ErrNotFound indicates the data provider has no hardware matching a lookup. Clients wrap it so callers can
+// distinguish missing hardware from a failure to communicate with the data provider using errors.Is().
+var ErrNotFound = errors.New("hardware not found")
+
+// wrapNotFound wraps err with ErrNotFound if its a gRPC NotFound error.
+func wrapNotFound(err error) error {
+	if status.Code(err) == codes.NotFound {
+		return fmt.Errorf("%w: %v", ErrNotFound, err)
+	}
+	return err
+}
+
 // Client defines the behaviors for interacting with hardware data providers.
 type Client interface {
 	// IsHealthy reports whether the client is connected and can retrieve hardware data from the data provider.
@@ -17,6 +34,12 @@ type Client interface {
 	// ByIP retrieves hardware data by its IP address.
 	ByIP(ctx context.Context, ip string) (Hardware, error)
 
+	// ByMAC retrieves hardware data by the MAC address of one of its network interfaces.
+	ByMAC(ctx context.Context, mac string) (Hardware, error)
+
+	// ByID retrieves hardware data by its ID, that is the ID returned by Hardware.ID().
+	ByID(ctx context.Context, id string) (Hardware, error)
+
 	// Watch creates a subscription to a hardware identified by id such that updates to the hardware data are
 	// pushed to the stream.
 	Watch(ctx context.Context, id string) (Watcher, error)
@@ -39,6 +62,12 @@ type ClientConfig struct {
 	// Required.
 	Model datamodel.DataModel
 
+	// FallbackModels defines additional client implementations that are queried, in order, when hardware can't be
+	// found using Model. datamodel.Cacher exports hardware in a different format so it can't be combined with other
+	// models.
+	// Optional.
+	FallbackModels []datamodel.DataModel
+
 	// Facility is used by the Cache client.
 	// Required for datamodel.Cacher.
 	Facility string
@@ -54,25 +83,99 @@ type ClientConfig struct {
 	// KubeNamespace is a namespace override to have Hegel use for reading resources.
 	// Optional
 	KubeNamespace string
+
+	// FilePath is a path to a file, or directory of files, containing Hardware resources used by the File client.
+	// Required for datamodel.File.
+	FilePath string
+
+	// Logger is used by clients that report errors in the background, such as the File client reloading its files.
+	Logger log.Logger
+
+	// CacheTTL is how long hardware is cached for. A value of 0 disables caching.
+	// Optional.
+	CacheTTL time.Duration
+
+	// CacheNegativeTTL is how long lookups for unknown hardware are cached for. It only applies when CacheTTL is
+	// greater than 0. A value of 0 disables negative caching.
+	// Optional.
+	CacheNegativeTTL time.Duration
 }
 
 func (v ClientConfig) validate() error {
-	if v.Model == datamodel.Cacher {
-		if v.Facility == "" {
-			return errors.New("cacher data model: factility is required")
+	models := v.models()
+
+	if len(models) > 1 {
+		seen := make(map[datamodel.DataModel]bool)
+		for _, model := range models {
+			if model == datamodel.Cacher {
+				return errors.New("cacher data model: cannot be combined with other data models")
+			}
+			if seen[model] {
+				return errors.Errorf("data model '%v' specified more than once", model)
+			}
+			seen[model] = true
+		}
+	}
+
+	for _, model := range models {
+		if model == datamodel.Cacher {
+			if v.Facility == "" {
+				return errors.New("cacher data model: factility is required")
+			}
+		}
+
+		if model == datamodel.File {
+			if v.FilePath == "" {
+				return errors.New("file data model: file path is required")
+			}
 		}
 	}
 
 	return nil
 }
 
+// models returns Model followed by FallbackModels.
+func (v ClientConfig) models() []datamodel.DataModel {
+	return append([]datamodel.DataModel{v.Model}, v.FallbackModels...)
+}
+
 // NewClient returns a new hardware Client, configured appropriately according to the mode (Cacher or Tink) Hegel is running in.
+// When FallbackModels are configured the Client is a ChainClient. When CacheTTL is configured the Client is wrapped
+// in a CachingClient.
 func NewClient(config ClientConfig) (Client, error) {
 	if err := config.validate(); err != nil {
 		return nil, err
 	}
 
-	switch config.Model {
+	var client Client
+	if len(config.FallbackModels) == 0 {
+		var err error
+		client, err = newModelClient(config, config.Model)
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		var clients []Client
+		for _, model := range config.models() {
+			client, err := newModelClient(config, model)
+			if err != nil {
+				return nil, err
+			}
+			clients = append(clients, client)
+		}
+		client = NewChainClient(clients...)
+	}
+
+	if config.CacheTTL > 0 {
+		client = NewCachingClient(client, config.CacheTTL, config.CacheNegativeTTL)
+	}
+
+	return client, nil
+}
+
+// newModelClient returns a new hardware Client for model.
+func newModelClient(config ClientConfig, model datamodel.DataModel) (Client, error) {
+	switch model {
 	case datamodel.Kubernetes:
 		config, err := NewKubernetesClientConfig(config.Kubeconfig, config.KubeAPI, config.KubeNamespace)
 		if err != nil {
@@ -87,6 +190,14 @@ func NewClient(config ClientConfig) (Client, error) {
 
 		return kubeclient, nil
 
+	case datamodel.File:
+		fileclient, err := NewFileClient(config.Logger, config.FilePath)
+		if err != nil {
+			return nil, errors.Wrap(err, "creating file hardware client")
+		}
+
+		return fileclient, nil
+
 	case datamodel.TinkServer:
 		tc, err := tink.TinkHardwareClient()
 		if err != nil {
@@ -0,0 +1,10 @@
+package hardware
+
+import "time"
+
+// SetNow overrides the clock used by c to determine whether cache entries have expired.
+func (c *CachingClient) SetNow(now func() time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.now = now
+}
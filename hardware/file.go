@@ -0,0 +1,375 @@
+package hardware
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+	"github.com/packethost/pkg/log"
+	"github.com/pkg/errors"
+	tinkv1alpha1 "github.com/tinkerbell/tink/pkg/apis/core/v1alpha1"
+	"k8s.io/apimachinery/pkg/util/yaml"
+)
+
+// fileReloadDelay is how long the FileClient waits for file system events to settle before reloading. It avoids
+// loading files that are part way through being written.
+const fileReloadDelay = 100 * time.Millisecond
+
+var _ Client = &FileClient{}
+
+// FileClient is a hardware client backed by Hardware resources stored as YAML or JSON on the local file system. The
+// path it reads may be a single file or a directory of files; files may contain multiple YAML documents. Changes to
+// the files are picked up automatically and pushed to watchers.
+type FileClient struct {
+	logger   log.Logger
+	path     string
+	watchers *watchRegistry
+
+	mu      sync.RWMutex
+	byIP    map[string]*K8sHardware
+	byMAC   map[string]*K8sHardware
+	byID    map[string]*K8sHardware
+	loadErr error
+	closed  bool
+
+	// reloadTimer debounces reloads. Its only accessed with mu held.
+	reloadTimer *time.Timer
+
+	fsWatcher *fsnotify.Watcher
+}
+
+// NewFileClient creates a new FileClient that reads hardware from path. It launches a goroutine that reloads the
+// hardware whenever path changes. Call Close() to stop watching path.
+func NewFileClient(logger log.Logger, path string) (*FileClient, error) {
+	client := &FileClient{
+		logger:   logger.With("client", "file", "path", path),
+		path:     path,
+		watchers: newWatchRegistry(),
+	}
+
+	index, err := loadHardwareFiles(path)
+	if err != nil {
+		return nil, err
+	}
+	client.byIP, client.byMAC, client.byID = index.byIP, index.byMAC, index.byID
+
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+
+	fsWatcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return nil, errors.Wrap(err, "creating file watcher")
+	}
+
+	// Editors and config management tools commonly replace files rather than writing them in place so we watch the
+	// parent directory of individual files.
+	watchPath := path
+	if !info.IsDir() {
+		watchPath = filepath.Dir(path)
+	}
+
+	if err := fsWatcher.Add(watchPath); err != nil {
+		fsWatcher.Close()
+		return nil, errors.Wrapf(err, "watching %v", watchPath)
+	}
+
+	client.fsWatcher = fsWatcher
+	go client.watchFiles()
+
+	return client, nil
+}
+
+// Close stops watching the hardware files. Subsequent calls to IsHealthy() return false.
+func (c *FileClient) Close() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.closed {
+		return
+	}
+
+	c.closed = true
+	if c.reloadTimer != nil {
+		c.reloadTimer.Stop()
+	}
+	c.fsWatcher.Close()
+}
+
+// IsHealthy returns true if the most recent load of the hardware files succeeded and Close() hasn't been called. An
+// unhealthy client continues to serve the hardware from the last successful load.
+func (c *FileClient) IsHealthy(context.Context) bool {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.loadErr == nil && !c.closed
+}
+
+// ByIP retrieves the hardware with an interface configured with ip.
+func (c *FileClient) ByIP(_ context.Context, ip string) (Hardware, error) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	hw, ok := c.byIP[ip]
+	if !ok {
+		return nil, fmt.Errorf("%w: no hardware with ip '%v'", ErrNotFound, ip)
+	}
+
+	return hw, nil
+}
+
+// ByMAC retrieves the hardware with an interface using mac.
+func (c *FileClient) ByMAC(_ context.Context, mac string) (Hardware, error) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	hw, ok := c.byMAC[strings.ToLower(mac)]
+	if !ok {
+		return nil, fmt.Errorf("%w: no hardware with mac '%v'", ErrNotFound, mac)
+	}
+
+	return hw, nil
+}
+
+// ByID retrieves the hardware with the instance ID id.
+func (c *FileClient) ByID(_ context.Context, id string) (Hardware, error) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	hw, ok := c.byID[id]
+	if !ok {
+		return nil, fmt.Errorf("%w: no hardware with id '%v'", ErrNotFound, id)
+	}
+
+	return hw, nil
+}
+
+// Watch returns a Watcher that receives the hardware identified by id each time it changes. If the hardware is removed
+// from the files the Watcher's stream ends.
+func (c *FileClient) Watch(ctx context.Context, id string) (Watcher, error) {
+	return c.watchers.subscribe(ctx, id), nil
+}
+
+func (c *FileClient) watchFiles() {
+	for {
+		select {
+		case event, ok := <-c.fsWatcher.Events:
+			if !ok {
+				return
+			}
+
+			if event.Op == fsnotify.Chmod {
+				continue
+			}
+
+			// Kubernetes ConfigMap and Secret mounts swap a symlinked directory so the hardware files themselves never
+			// see an event. Reloading unchanged files is harmless as watchers are only notified of changes.
+			c.scheduleReload()
+
+		case err, ok := <-c.fsWatcher.Errors:
+			if !ok {
+				return
+			}
+			c.logger.Error(err, "watching hardware files")
+		}
+	}
+}
+
+// scheduleReload reloads the hardware files after fileReloadDelay, postponing any pending reload.
+func (c *FileClient) scheduleReload() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.closed {
+		return
+	}
+
+	if c.reloadTimer == nil {
+		c.reloadTimer = time.AfterFunc(fileReloadDelay, c.reload)
+	} else {
+		c.reloadTimer.Reset(fileReloadDelay)
+	}
+}
+
+// reload re-reads the hardware files and notifies watchers of any hardware that changed. It does nothing once the
+// client is closed.
+func (c *FileClient) reload() {
+	c.mu.RLock()
+	closed := c.closed
+	c.mu.RUnlock()
+	if closed {
+		return
+	}
+
+	index, err := loadHardwareFiles(c.path)
+
+	c.mu.Lock()
+	if c.closed {
+		c.mu.Unlock()
+		return
+	}
+	c.loadErr = err
+	if err != nil {
+		c.mu.Unlock()
+		c.logger.Error(err, "reloading hardware files, serving the previously loaded hardware")
+		return
+	}
+	previous := c.byID
+	c.byIP, c.byMAC, c.byID = index.byIP, index.byMAC, index.byID
+	c.mu.Unlock()
+
+	for _, id := range c.watchers.ids() {
+		current, ok := index.byID[id]
+		if !ok {
+			if _, existed := previous[id]; existed {
+				c.watchers.close(id)
+			}
+			continue
+		}
+
+		if old, ok := previous[id]; ok && reflect.DeepEqual(old.Hardware.Spec, current.Hardware.Spec) {
+			continue
+		}
+
+		c.watchers.publish(id, current)
+	}
+}
+
+// fileIndex indexes hardware loaded from files.
+type fileIndex struct {
+	byIP  map[string]*K8sHardware
+	byMAC map[string]*K8sHardware
+	byID  map[string]*K8sHardware
+}
+
+// loadHardwareFiles reads all hardware from path and indexes it by IP, MAC and ID. MACs are indexed in lower case.
+func loadHardwareFiles(path string) (fileIndex, error) {
+	files, err := listHardwareFiles(path)
+	if err != nil {
+		return fileIndex{}, err
+	}
+
+	index := fileIndex{
+		byIP:  make(map[string]*K8sHardware),
+		byMAC: make(map[string]*K8sHardware),
+		byID:  make(map[string]*K8sHardware),
+	}
+
+	for _, file := range files {
+		hardware, err := readHardwareFile(file)
+		if err != nil {
+			return fileIndex{}, errors.Wrapf(err, "reading %v", file)
+		}
+
+		for i := range hardware {
+			hw := FromK8sTinkHardware(&hardware[i])
+
+			id, _ := hw.ID()
+			if id != "" {
+				if _, ok := index.byID[id]; ok {
+					return fileIndex{}, fmt.Errorf("%v: multiple hardware with id '%v'", file, id)
+				}
+				index.byID[id] = hw
+			}
+
+			for _, iface := range hardware[i].Spec.Interfaces {
+				if iface.DHCP == nil {
+					continue
+				}
+
+				if mac := strings.ToLower(iface.DHCP.MAC); mac != "" {
+					if _, ok := index.byMAC[mac]; ok {
+						return fileIndex{}, fmt.Errorf("%v: multiple hardware with mac '%v'", file, mac)
+					}
+					index.byMAC[mac] = hw
+				}
+
+				if iface.DHCP.IP != nil && iface.DHCP.IP.Address != "" {
+					ip := iface.DHCP.IP.Address
+					if _, ok := index.byIP[ip]; ok {
+						return fileIndex{}, fmt.Errorf("%v: multiple hardware with ip '%v'", file, ip)
+					}
+					index.byIP[ip] = hw
+				}
+			}
+		}
+	}
+
+	return index, nil
+}
+
+// listHardwareFiles returns path if its a file, or the hardware files directly contained in path if its a directory.
+func listHardwareFiles(path string) ([]string, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+
+	if !info.IsDir() {
+		return []string{path}, nil
+	}
+
+	entries, err := os.ReadDir(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var files []string
+	for _, entry := range entries {
+		if entry.IsDir() || !isHardwareFile(entry.Name()) {
+			continue
+		}
+		files = append(files, filepath.Join(path, entry.Name()))
+	}
+	sort.Strings(files)
+
+	return files, nil
+}
+
+func isHardwareFile(name string) bool {
+	switch strings.ToLower(filepath.Ext(name)) {
+	case ".yaml", ".yml", ".json":
+		return true
+	default:
+		return false
+	}
+}
+
+// readHardwareFile decodes all YAML documents or JSON objects in file as Hardware.
+func readHardwareFile(file string) ([]tinkv1alpha1.Hardware, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+
+	var hardware []tinkv1alpha1.Hardware
+	decoder := yaml.NewYAMLOrJSONDecoder(bytes.NewReader(data), 4096)
+	for {
+		var hw tinkv1alpha1.Hardware
+		if err := decoder.Decode(&hw); err != nil {
+			if errors.Is(err, io.EOF) {
+				break
+			}
+			return nil, err
+		}
+
+		// Skip empty documents.
+		if reflect.DeepEqual(hw, tinkv1alpha1.Hardware{}) {
+			continue
+		}
+
+		defaultHardwareMetadata(&hw)
+		hardware = append(hardware, hw)
+	}
+
+	return hardware, nil
+}
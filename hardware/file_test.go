@@ -0,0 +1,277 @@
+package hardware_test
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/packethost/pkg/log"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/tinkerbell/hegel/hardware"
+)
+
+const fileHardwareTemplate = `
+apiVersion: tinkerbell.org/v1alpha1
+kind: Hardware
+metadata:
+  name: machine1
+spec:
+  interfaces:
+  - dhcp:
+      mac: "00:00:00:00:00:01"
+      ip:
+        address: 10.0.0.1
+        netmask: 255.255.255.0
+        gateway: 10.0.0.254
+  metadata:
+    instance:
+      id: instance-1
+      hostname: %v
+---
+apiVersion: tinkerbell.org/v1alpha1
+kind: Hardware
+metadata:
+  name: machine2
+spec:
+  interfaces:
+  - dhcp:
+      mac: "00:00:00:00:00:02"
+      ip:
+        address: 10.0.0.2
+  metadata:
+    instance:
+      id: instance-2
+`
+
+// writeHardwareFile atomically replaces the hardware file at path so the client never observes a partial write.
+func writeHardwareFile(t *testing.T, path, hostname string) {
+	t.Helper()
+
+	tmp := filepath.Join(filepath.Dir(path), ".tmp")
+	require.NoError(t, os.WriteFile(tmp, []byte(fmt.Sprintf(fileHardwareTemplate, hostname)), 0o600))
+	require.NoError(t, os.Rename(tmp, path))
+}
+
+func TestFileClientByIP(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "hardware.yaml")
+	writeHardwareFile(t, path, "machine1")
+
+	client, err := hardware.NewFileClient(log.Test(t, "file"), path)
+	require.NoError(t, err)
+	defer client.Close()
+
+	assert.True(t, client.IsHealthy(context.Background()))
+
+	hw, err := client.ByIP(context.Background(), "10.0.0.2")
+	require.NoError(t, err)
+
+	id, err := hw.ID()
+	require.NoError(t, err)
+	assert.Equal(t, "instance-2", id)
+
+	_, err = client.ByIP(context.Background(), "10.0.0.3")
+	assert.ErrorIs(t, err, hardware.ErrNotFound)
+}
+
+func TestFileClientByMAC(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "hardware.yaml")
+	writeHardwareFile(t, path, "machine1")
+
+	client, err := hardware.NewFileClient(log.Test(t, "file"), path)
+	require.NoError(t, err)
+	defer client.Close()
+
+	hw, err := client.ByMAC(context.Background(), "00:00:00:00:00:02")
+	require.NoError(t, err)
+
+	id, err := hw.ID()
+	require.NoError(t, err)
+	assert.Equal(t, "instance-2", id)
+
+	_, err = client.ByMAC(context.Background(), "00:00:00:00:00:03")
+	assert.Error(t, err)
+}
+
+func TestFileClientByID(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "hardware.yaml")
+	writeHardwareFile(t, path, "machine1")
+
+	client, err := hardware.NewFileClient(log.Test(t, "file"), path)
+	require.NoError(t, err)
+	defer client.Close()
+
+	hw, err := client.ByID(context.Background(), "instance-2")
+	require.NoError(t, err)
+
+	id, err := hw.ID()
+	require.NoError(t, err)
+	assert.Equal(t, "instance-2", id)
+
+	_, err = client.ByID(context.Background(), "instance-3")
+	assert.ErrorIs(t, err, hardware.ErrNotFound)
+}
+
+func TestFileClientDirectory(t *testing.T) {
+	dir := t.TempDir()
+	writeHardwareFile(t, filepath.Join(dir, "hardware.yaml"), "machine1")
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "README.md"), []byte("not hardware"), 0o600))
+
+	client, err := hardware.NewFileClient(log.Test(t, "file"), dir)
+	require.NoError(t, err)
+	defer client.Close()
+
+	hw, err := client.ByIP(context.Background(), "10.0.0.1")
+	require.NoError(t, err)
+
+	id, err := hw.ID()
+	require.NoError(t, err)
+	assert.Equal(t, "instance-1", id)
+}
+
+func TestFileClientInterfaceWithoutIP(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "hardware.yaml")
+	hw := `
+apiVersion: tinkerbell.org/v1alpha1
+kind: Hardware
+metadata:
+  name: machine1
+spec:
+  interfaces:
+  - dhcp:
+      mac: "00:00:00:00:00:01"
+  - dhcp:
+      mac: "00:00:00:00:00:02"
+      ip:
+        address: 10.0.0.2
+  metadata:
+    instance:
+      id: instance-1
+`
+	require.NoError(t, os.WriteFile(path, []byte(hw), 0o600))
+
+	client, err := hardware.NewFileClient(log.Test(t, "file"), path)
+	require.NoError(t, err)
+	defer client.Close()
+
+	exported, err := client.ByIP(context.Background(), "10.0.0.2")
+	require.NoError(t, err)
+
+	id, err := exported.ID()
+	require.NoError(t, err)
+	assert.Equal(t, "instance-1", id)
+
+	exported, err = client.ByMAC(context.Background(), "00:00:00:00:00:01")
+	require.NoError(t, err)
+
+	id, err = exported.ID()
+	require.NoError(t, err)
+	assert.Equal(t, "instance-1", id)
+}
+
+func TestFileClientDuplicateIP(t *testing.T) {
+	dir := t.TempDir()
+	writeHardwareFile(t, filepath.Join(dir, "a.yaml"), "machine1")
+	writeHardwareFile(t, filepath.Join(dir, "b.yaml"), "machine1")
+
+	_, err := hardware.NewFileClient(log.Test(t, "file"), dir)
+	assert.Error(t, err)
+}
+
+func TestFileClientWatch(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "hardware.yaml")
+	writeHardwareFile(t, path, "machine1")
+
+	client, err := hardware.NewFileClient(log.Test(t, "file"), path)
+	require.NoError(t, err)
+	defer client.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	watcher, err := client.Watch(ctx, "instance-1")
+	require.NoError(t, err)
+
+	writeHardwareFile(t, path, "renamed")
+
+	hw, err := watcher.Recv()
+	require.NoError(t, err)
+
+	exported, err := hw.Export()
+	require.NoError(t, err)
+	assert.Contains(t, string(exported), `"hostname":"renamed"`)
+
+	hw, err = client.ByIP(context.Background(), "10.0.0.1")
+	require.NoError(t, err)
+
+	exported, err = hw.Export()
+	require.NoError(t, err)
+	assert.Contains(t, string(exported), `"hostname":"renamed"`)
+}
+
+func TestFileClientClose(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "hardware.yaml")
+	writeHardwareFile(t, path, "machine1")
+
+	client, err := hardware.NewFileClient(log.Test(t, "file"), path)
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 500*time.Millisecond)
+	defer cancel()
+
+	watcher, err := client.Watch(ctx, "instance-1")
+	require.NoError(t, err)
+
+	// Trigger a reload then close the client before the reload delay elapses.
+	writeHardwareFile(t, path, "renamed")
+	client.Close()
+
+	assert.False(t, client.IsHealthy(context.Background()))
+
+	_, err = watcher.Recv()
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+
+	hw, err := client.ByIP(context.Background(), "10.0.0.1")
+	require.NoError(t, err)
+
+	exported, err := hw.Export()
+	require.NoError(t, err)
+	assert.Contains(t, string(exported), `"hostname":"machine1"`)
+}
+
+func TestFileClientConfigMapMount(t *testing.T) {
+	// Kubernetes mounts ConfigMaps as symlinks into a timestamped directory that's swapped atomically.
+	dir := t.TempDir()
+	mount := func(version, hostname string) {
+		data := filepath.Join(dir, version)
+		require.NoError(t, os.Mkdir(data, 0o700))
+		require.NoError(t, os.WriteFile(filepath.Join(data, "hardware.yaml"), []byte(fmt.Sprintf(fileHardwareTemplate, hostname)), 0o600))
+		require.NoError(t, os.Symlink(version, filepath.Join(dir, "..data_tmp")))
+		require.NoError(t, os.Rename(filepath.Join(dir, "..data_tmp"), filepath.Join(dir, "..data")))
+	}
+	mount("..v1", "machine1")
+	path := filepath.Join(dir, "hardware.yaml")
+	require.NoError(t, os.Symlink(filepath.Join("..data", "hardware.yaml"), path))
+
+	client, err := hardware.NewFileClient(log.Test(t, "file"), path)
+	require.NoError(t, err)
+	defer client.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	watcher, err := client.Watch(ctx, "instance-1")
+	require.NoError(t, err)
+
+	mount("..v2", "renamed")
+
+	hw, err := watcher.Recv()
+	require.NoError(t, err)
+
+	exported, err := hw.Export()
+	require.NoError(t, err)
+	assert.Contains(t, string(exported), `"hostname":"renamed"`)
+}
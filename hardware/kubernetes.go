@@ -3,19 +3,42 @@ package hardware
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"reflect"
+	"strings"
 	"sync"
+	"time"
 
-	"github.com/pkg/errors"
 	tinkv1alpha1 "github.com/tinkerbell/tink/pkg/apis/core/v1alpha1"
 	tink "github.com/tinkerbell/tink/pkg/controllers"
 	"k8s.io/client-go/rest"
+	toolscache "k8s.io/client-go/tools/cache"
 	"k8s.io/client-go/tools/clientcmd"
 	clientcmdapi "k8s.io/client-go/tools/clientcmd/api"
 	crclient "sigs.k8s.io/controller-runtime/pkg/client"
 )
 
-var _ Client = &KubernetesClient{}
+// HardwareMACAddrIndex is a field index on Hardware resources that indexes the MAC addresses of DHCP interfaces. MAC
+// addresses are indexed in lower case.
+const HardwareMACAddrIndex = "hardware.spec.interfaces.dhcp.mac"
+
+// HardwareInstanceIDIndex is a field index on Hardware resources that indexes their instance ID.
+const HardwareInstanceIDIndex = "hardware.spec.metadata.instance.id"
+
+var (
+	_ Client            = &KubernetesClient{}
+	_ PhoneHomeRecorder = &KubernetesClient{}
+)
+
+const (
+	// PhoneHomeTimeAnnotation is the Hardware annotation recording when the machine last phoned home in RFC 3339
+	// format.
+	PhoneHomeTimeAnnotation = "hegel.tinkerbell.org/phone-home-time"
+
+	// PhoneHomeAnnotation is the Hardware annotation recording the JSON encoded PhoneHome report the machine last sent.
+	PhoneHomeAnnotation = "hegel.tinkerbell.org/phone-home"
+)
 
 // KubernetesClient is a hardware client backed by a KubernetesClient cluster that contains hardware resources.
 type KubernetesClient struct {
@@ -23,6 +46,7 @@ type KubernetesClient struct {
 	close            func()
 	closeM           *sync.RWMutex
 	waitForCacheSync func(context.Context) bool
+	watchers         *watchRegistry
 }
 
 // NewKubernetesClientOrDie creates a new KubernetesClient client. It panics upon error.
@@ -36,6 +60,7 @@ func NewKubernetesClientOrDie(config KubernetesClientConfig) *KubernetesClient {
 
 // NewKubernetesClient creates a new KubernetesClient client instance. It launches a goroutine to perform synchronization
 // between the cluster and internal caches. Consumers can wait for the initial sync using WaitForCachesync().
+// Hardware informer events are delivered to watchers created with Watch().
 // See k8s.io/client-go/tools/clientcmd for constructing *rest.Config objects.
 func NewKubernetesClient(config KubernetesClientConfig) (*KubernetesClient, error) {
 	opts := tink.GetServerOptions()
@@ -49,6 +74,35 @@ func NewKubernetesClient(config KubernetesClientConfig) (*KubernetesClient, erro
 		return nil, err
 	}
 
+	err = manager.GetFieldIndexer().IndexField(
+		context.Background(),
+		&tinkv1alpha1.Hardware{},
+		HardwareMACAddrIndex,
+		HardwareMACAddrIndexFunc,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("registering mac address index: %w", err)
+	}
+
+	err = manager.GetFieldIndexer().IndexField(
+		context.Background(),
+		&tinkv1alpha1.Hardware{},
+		HardwareInstanceIDIndex,
+		HardwareInstanceIDIndexFunc,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("registering instance id index: %w", err)
+	}
+
+	client := NewKubernetesClientWithClient(manager.GetClient())
+
+	// Retrieving the informer before the manager starts registers it with the cache so it's started with the manager.
+	informer, err := manager.GetCache().GetInformer(context.Background(), &tinkv1alpha1.Hardware{})
+	if err != nil {
+		return nil, fmt.Errorf("retrieving hardware informer: %w", err)
+	}
+	informer.AddEventHandler(client.EventHandler())
+
 	managerCtx, cancel := context.WithCancel(context.Background())
 	go func() {
 		if err := manager.Start(managerCtx); err != nil {
@@ -56,7 +110,6 @@ func NewKubernetesClient(config KubernetesClientConfig) (*KubernetesClient, erro
 		}
 	}()
 
-	client := NewKubernetesClientWithClient(manager.GetClient())
 	client.close = cancel
 	client.waitForCacheSync = manager.GetCache().WaitForCacheSync
 
@@ -68,14 +121,22 @@ type ListerClient interface {
 	List(ctx context.Context, list crclient.ObjectList, opts ...crclient.ListOption) error
 }
 
+// PatcherClient patches Kubernetes resources using a sigs.k8s.io/controller-runtime client. The client given to
+// NewKubernetesClientWithClient must implement it, and be allowed to patch Hardware, to record phone homes.
+type PatcherClient interface {
+	Patch(ctx context.Context, obj crclient.Object, patch crclient.Patch, opts ...crclient.PatchOption) error
+}
+
 // NewKubernetesClientWithClient creates a new KubernetesClient instance that uses client to find resources. The
-// Close() and WaitForCacheSync() methods of the returned client are noops.
+// Close() and WaitForCacheSync() methods of the returned client are noops. Watchers only receive updates if the
+// handler returned from EventHandler() is registered with a Hardware informer.
 func NewKubernetesClientWithClient(client ListerClient) *KubernetesClient {
 	return &KubernetesClient{
 		client:           client,
 		close:            func() {},
 		closeM:           &sync.RWMutex{},
 		waitForCacheSync: func(context.Context) bool { return true },
+		watchers:         newWatchRegistry(),
 	}
 }
 
@@ -104,28 +165,183 @@ func (k *KubernetesClient) IsHealthy(context.Context) bool {
 
 // ByIP retrieves a hardware resource associated with ip.
 func (k *KubernetesClient) ByIP(ctx context.Context, ip string) (Hardware, error) {
+	return k.byIndex(ctx, tink.HardwareIPAddrIndex, "ip", ip)
+}
+
+// ByMAC retrieves a hardware resource with an interface using mac.
+func (k *KubernetesClient) ByMAC(ctx context.Context, mac string) (Hardware, error) {
+	return k.byIndex(ctx, HardwareMACAddrIndex, "mac", strings.ToLower(mac))
+}
+
+// ByID retrieves the hardware resource with the instance ID id.
+func (k *KubernetesClient) ByID(ctx context.Context, id string) (Hardware, error) {
+	return k.byIndex(ctx, HardwareInstanceIDIndex, "id", id)
+}
+
+// byIndex retrieves the hardware resource with value for the field index. name is a human readable name for the index
+// used in errors.
+func (k *KubernetesClient) byIndex(ctx context.Context, index, name, value string) (Hardware, error) {
+	hw, err := k.getByIndex(ctx, index, name, value)
+	if err != nil {
+		return nil, err
+	}
+	return FromK8sTinkHardware(hw), nil
+}
+
+// getByIndex retrieves the Hardware resource with value for the field index.
+func (k *KubernetesClient) getByIndex(ctx context.Context, index, name, value string) (*tinkv1alpha1.Hardware, error) {
 	var hw tinkv1alpha1.HardwareList
 	err := k.client.List(ctx, &hw, crclient.MatchingFields{
-		tink.HardwareIPAddrIndex: ip,
+		index: value,
 	})
 	if err != nil {
 		return nil, err
 	}
 
 	if len(hw.Items) == 0 {
-		return nil, fmt.Errorf("no hardware with ip '%v'", ip)
+		return nil, fmt.Errorf("%w: no hardware with %v '%v'", ErrNotFound, name, value)
 	}
 
 	if len(hw.Items) > 1 {
-		return nil, fmt.Errorf("multiple hardware with ip '%v'", ip)
+		return nil, fmt.Errorf("multiple hardware with %v '%v'", name, value)
 	}
 
-	return FromK8sTinkHardware(&hw.Items[0]), nil
+	return &hw.Items[0], nil
 }
 
-// Watch is unimplemented.
-func (k *KubernetesClient) Watch(context.Context, string) (Watcher, error) {
-	return nil, errors.New("kubernetes client: watch is unimplemented")
+// RecordPhoneHome records report on the Hardware with the instance ID id using the PhoneHomeTimeAnnotation and
+// PhoneHomeAnnotation annotations. Annotations are used rather than the status because the Hardware status schema is
+// owned by Tink and only has a state; recording a report there would need a CRD change and write access to the
+// status subresource. The Hardware is merge patched so Hegel's service account needs the patch verb on
+// hardware.tinkerbell.org resources in addition to get, list and watch.
+func (k *KubernetesClient) RecordPhoneHome(ctx context.Context, id string, report PhoneHome) error {
+	patcher, ok := k.client.(PatcherClient)
+	if !ok {
+		return errors.New("kubernetes client can't patch hardware")
+	}
+
+	hw, err := k.getByIndex(ctx, HardwareInstanceIDIndex, "id", id)
+	if err != nil {
+		return err
+	}
+
+	encoded, err := json.Marshal(report)
+	if err != nil {
+		return err
+	}
+
+	patched := hw.DeepCopy()
+	if patched.Annotations == nil {
+		patched.Annotations = make(map[string]string)
+	}
+	patched.Annotations[PhoneHomeTimeAnnotation] = report.Time.UTC().Format(time.RFC3339)
+	patched.Annotations[PhoneHomeAnnotation] = string(encoded)
+
+	return patcher.Patch(ctx, patched, crclient.MergeFrom(hw))
+}
+
+// HardwareMACAddrIndexFunc is a controller-runtime index function for HardwareMACAddrIndex.
+func HardwareMACAddrIndexFunc(obj crclient.Object) []string {
+	hw, ok := obj.(*tinkv1alpha1.Hardware)
+	if !ok {
+		return nil
+	}
+
+	var macs []string
+	for _, iface := range hw.Spec.Interfaces {
+		if iface.DHCP != nil && iface.DHCP.MAC != "" {
+			macs = append(macs, strings.ToLower(iface.DHCP.MAC))
+		}
+	}
+	return macs
+}
+
+// HardwareInstanceIDIndexFunc is a controller-runtime index function for HardwareInstanceIDIndex.
+func HardwareInstanceIDIndexFunc(obj crclient.Object) []string {
+	hw, ok := obj.(*tinkv1alpha1.Hardware)
+	if !ok {
+		return nil
+	}
+
+	if id := instanceID(hw); id != "" {
+		return []string{id}
+	}
+	return nil
+}
+
+// Watch returns a Watcher that receives the hardware with the instance ID id each time its spec changes. If the
+// hardware is deleted the Watcher's stream ends.
+func (k *KubernetesClient) Watch(ctx context.Context, id string) (Watcher, error) {
+	return k.watchers.subscribe(ctx, id), nil
+}
+
+// EventHandler returns a Hardware informer event handler that pushes changes to watchers.
+func (k *KubernetesClient) EventHandler() toolscache.ResourceEventHandler {
+	return toolscache.ResourceEventHandlerFuncs{
+		AddFunc: func(obj interface{}) {
+			if hw, ok := obj.(*tinkv1alpha1.Hardware); ok {
+				k.publish(hw)
+			}
+		},
+		UpdateFunc: func(oldObj, newObj interface{}) {
+			old, ok := oldObj.(*tinkv1alpha1.Hardware)
+			if !ok {
+				return
+			}
+
+			hw, ok := newObj.(*tinkv1alpha1.Hardware)
+			if !ok {
+				return
+			}
+
+			// Status and object metadata changes don't affect the exported hardware.
+			if reflect.DeepEqual(old.Spec, hw.Spec) {
+				return
+			}
+
+			// Watchers of the previous ID no longer have hardware to watch.
+			if oldID := instanceID(old); oldID != "" && oldID != instanceID(hw) {
+				k.watchers.close(oldID)
+			}
+
+			k.publish(hw)
+		},
+		DeleteFunc: func(obj interface{}) {
+			if tombstone, ok := obj.(toolscache.DeletedFinalStateUnknown); ok {
+				obj = tombstone.Obj
+			}
+
+			hw, ok := obj.(*tinkv1alpha1.Hardware)
+			if !ok {
+				return
+			}
+
+			if id := instanceID(hw); id != "" {
+				k.watchers.close(id)
+			}
+		},
+	}
+}
+
+func (k *KubernetesClient) publish(hw *tinkv1alpha1.Hardware) {
+	id := instanceID(hw)
+	if id == "" {
+		return
+	}
+
+	// Objects received from informers are shared with the cache so we mustn't modify them.
+	hw = hw.DeepCopy()
+	defaultHardwareMetadata(hw)
+
+	k.watchers.publish(id, FromK8sTinkHardware(hw))
+}
+
+// instanceID returns the instance ID of hw or an empty string if it has no instance metadata.
+func instanceID(hw *tinkv1alpha1.Hardware) string {
+	if hw.Spec.Metadata == nil || hw.Spec.Metadata.Instance == nil {
+		return ""
+	}
+	return hw.Spec.Metadata.Instance.ID
 }
 
 // KuberneteSClientConfig used by the NewKubernetesClient function family.
@@ -170,7 +386,8 @@ func FromK8sTinkHardware(tinkHardware *tinkv1alpha1.Hardware) *K8sHardware {
 	hw := &K8sHardware{
 		Hardware: tinkHardware,
 		Metadata: K8sHardwareMetadata{
-			Userdata: tinkHardware.Spec.UserData,
+			Userdata:    tinkHardware.Spec.UserData,
+			BondingMode: tinkHardware.Spec.Metadata.BondingMode,
 			Instance: K8sHardwareMetadataInstance{
 				ID:        tinkHardware.Spec.Metadata.Instance.ID,
 				Hostname:  tinkHardware.Spec.Metadata.Instance.Hostname,
@@ -194,6 +411,8 @@ func FromK8sTinkHardware(tinkHardware *tinkv1alpha1.Hardware) *K8sHardware {
 			K8sHardwareMetadataInstanceNetworkAddress{
 				Address:       ip.Address,
 				AddressFamily: ip.Family,
+				Netmask:       ip.Netmask,
+				Gateway:       ip.Gateway,
 				Public:        ip.Public,
 			},
 		)
@@ -227,6 +446,28 @@ func FromK8sTinkHardware(tinkHardware *tinkv1alpha1.Hardware) *K8sHardware {
 	return hw
 }
 
+// defaultHardwareMetadata populates the optional metadata and DHCP IP structures of hw that FromK8sTinkHardware
+// requires.
+func defaultHardwareMetadata(hw *tinkv1alpha1.Hardware) {
+	if hw.Spec.Metadata == nil {
+		hw.Spec.Metadata = &tinkv1alpha1.HardwareMetadata{}
+	}
+	if hw.Spec.Metadata.Facility == nil {
+		hw.Spec.Metadata.Facility = &tinkv1alpha1.MetadataFacility{}
+	}
+	if hw.Spec.Metadata.Instance == nil {
+		hw.Spec.Metadata.Instance = &tinkv1alpha1.MetadataInstance{}
+	}
+	if hw.Spec.Metadata.Instance.OperatingSystem == nil {
+		hw.Spec.Metadata.Instance.OperatingSystem = &tinkv1alpha1.MetadataInstanceOperatingSystem{}
+	}
+	for _, iface := range hw.Spec.Interfaces {
+		if iface.DHCP != nil && iface.DHCP.IP == nil {
+			iface.DHCP.IP = &tinkv1alpha1.IP{}
+		}
+	}
+}
+
 // K8sHardware satisfies the Export() requirements of the EC2 Metadata filter handling.
 type K8sHardware struct {
 	Hardware *tinkv1alpha1.Hardware `json:"-"`
@@ -249,6 +490,8 @@ type K8sHardwareMetadata struct {
 	//+optional
 	Interfaces []K8sNetworkInterface `json:"interfaces,omitempty"`
 	Gateway    string                `json:"gateway,omitempty"`
+	//+optional
+	BondingMode int64 `json:"bonding_mode,omitempty"`
 }
 
 type K8sNetworkInterface struct {
@@ -285,6 +528,8 @@ type K8sHardwareMetadataInstanceNetwork struct {
 type K8sHardwareMetadataInstanceNetworkAddress struct {
 	AddressFamily int64  `json:"address_family,omitempty"`
 	Address       string `json:"address,omitempty"`
+	Netmask       string `json:"netmask,omitempty"`
+	Gateway       string `json:"gateway,omitempty"`
 	Public        bool   `json:"public,omitempty"`
 }
 
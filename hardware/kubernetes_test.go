@@ -3,7 +3,9 @@ package hardware_test
 import (
 	"context"
 	"encoding/json"
+	"io"
 	"testing"
+	"time"
 
 	"github.com/pkg/errors"
 	"github.com/stretchr/testify/assert"
@@ -65,6 +67,95 @@ func TestKubernetesClientLists(t *testing.T) {
 	// todo(chrisdoherty4) Validate the returned hardware Export() has correctly serialized data.
 }
 
+func TestKubernetesClientByMAC(t *testing.T) {
+	listerClient := &ListerClientMock{}
+	listerClient.
+		On("List", mock.Anything, mock.Anything, mock.Anything).
+		Run(func(args mock.Arguments) {
+			hw := args.Get(1).(*tinkv1alpha1.HardwareList)
+			hw.Items = append(hw.Items, tinkv1alpha1.Hardware{
+				ObjectMeta: v1.ObjectMeta{Name: "hello-world"},
+				Spec: tinkv1alpha1.HardwareSpec{
+					Metadata: &tinkv1alpha1.HardwareMetadata{
+						Facility: &tinkv1alpha1.MetadataFacility{},
+						Instance: &tinkv1alpha1.MetadataInstance{
+							OperatingSystem: &tinkv1alpha1.MetadataInstanceOperatingSystem{},
+						},
+					},
+				},
+			})
+		}).
+		Return((error)(nil))
+
+	client := hardware.NewKubernetesClientWithClient(listerClient)
+
+	_, err := client.ByMAC(context.Background(), "00:0A:0B:0C:0D:0E")
+	require.NoError(t, err)
+
+	require.Equal(t, len(listerClient.Calls), 1)
+	opts := listerClient.Calls[0].Arguments.Get(2).([]crclient.ListOption)
+	require.Len(t, opts, 1)
+
+	matchingFields, ok := opts[0].(crclient.MatchingFields)
+	require.True(t, ok)
+
+	require.Contains(t, matchingFields, hardware.HardwareMACAddrIndex)
+	assert.Equal(t, "00:0a:0b:0c:0d:0e", matchingFields[hardware.HardwareMACAddrIndex])
+}
+
+func TestHardwareMACAddrIndexFunc(t *testing.T) {
+	hw := &tinkv1alpha1.Hardware{
+		Spec: tinkv1alpha1.HardwareSpec{
+			Interfaces: []tinkv1alpha1.Interface{
+				{DHCP: &tinkv1alpha1.DHCP{MAC: "00:0A:0B:0C:0D:0E"}},
+				{},
+				{DHCP: &tinkv1alpha1.DHCP{MAC: "00:00:00:00:00:01"}},
+			},
+		},
+	}
+
+	macs := hardware.HardwareMACAddrIndexFunc(hw)
+	assert.Equal(t, []string{"00:0a:0b:0c:0d:0e", "00:00:00:00:00:01"}, macs)
+}
+
+func TestKubernetesClientByID(t *testing.T) {
+	listerClient := &ListerClientMock{}
+	listerClient.
+		On("List", mock.Anything, mock.Anything, mock.Anything).
+		Run(func(args mock.Arguments) {
+			hw := args.Get(1).(*tinkv1alpha1.HardwareList)
+			hw.Items = append(hw.Items, tinkv1alpha1.Hardware{
+				ObjectMeta: v1.ObjectMeta{Name: "hello-world"},
+				Spec: tinkv1alpha1.HardwareSpec{
+					Metadata: &tinkv1alpha1.HardwareMetadata{
+						Facility: &tinkv1alpha1.MetadataFacility{},
+						Instance: &tinkv1alpha1.MetadataInstance{
+							ID:              "instance-1",
+							OperatingSystem: &tinkv1alpha1.MetadataInstanceOperatingSystem{},
+						},
+					},
+				},
+			})
+		}).
+		Return((error)(nil))
+
+	client := hardware.NewKubernetesClientWithClient(listerClient)
+
+	hw, err := client.ByID(context.Background(), "instance-1")
+	require.NoError(t, err)
+
+	id, err := hw.ID()
+	require.NoError(t, err)
+	assert.Equal(t, "instance-1", id)
+
+	opts := listerClient.Calls[0].Arguments.Get(2).([]crclient.ListOption)
+	require.Len(t, opts, 1)
+
+	matchingFields, ok := opts[0].(crclient.MatchingFields)
+	require.True(t, ok)
+	assert.Equal(t, "instance-1", matchingFields[hardware.HardwareInstanceIDIndex])
+}
+
 func TestKubernetesClientListsWithError(t *testing.T) {
 	expect := errors.New("foo-bar")
 	listerClient := &ListerClientMock{}
@@ -105,7 +196,7 @@ func TestKubernetesClientListWithNoResults(t *testing.T) {
 	client := hardware.NewKubernetesClientWithClient(listerClient)
 
 	_, err := client.ByIP(context.Background(), "10.10.10.10")
-	assert.Error(t, err)
+	assert.ErrorIs(t, err, hardware.ErrNotFound)
 }
 
 func TestKubernetesClientHealthyAndClose(t *testing.T) {
@@ -159,6 +250,144 @@ func TestK8sHardwareExport(t *testing.T) {
 	assert.Equal(t, expect, actual)
 }
 
+func TestFromK8sTinkHardwareNetwork(t *testing.T) {
+	hw := hardware.FromK8sTinkHardware(&tinkv1alpha1.Hardware{
+		Spec: tinkv1alpha1.HardwareSpec{
+			Metadata: &tinkv1alpha1.HardwareMetadata{
+				BondingMode: 4,
+				Facility:    &tinkv1alpha1.MetadataFacility{},
+				Instance: &tinkv1alpha1.MetadataInstance{
+					OperatingSystem: &tinkv1alpha1.MetadataInstanceOperatingSystem{},
+					Ips: []*tinkv1alpha1.MetadataInstanceIP{
+						{Address: "10.0.0.5", Netmask: "255.255.255.0", Gateway: "10.0.0.1", Family: 4},
+					},
+				},
+			},
+		},
+	})
+
+	assert.Equal(t, int64(4), hw.Metadata.BondingMode)
+	assert.Equal(t, []hardware.K8sHardwareMetadataInstanceNetworkAddress{
+		{AddressFamily: 4, Address: "10.0.0.5", Netmask: "255.255.255.0", Gateway: "10.0.0.1"},
+	}, hw.Metadata.Instance.Network.Addresses)
+}
+
+func TestKubernetesClientWatch(t *testing.T) {
+	client := hardware.NewKubernetesClientWithClient(&ListerClientMock{})
+	handler := client.EventHandler()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	watcher, err := client.Watch(ctx, "instance-id")
+	require.NoError(t, err)
+
+	newHardware := func(instanceID, hostname string) *tinkv1alpha1.Hardware {
+		return &tinkv1alpha1.Hardware{
+			Spec: tinkv1alpha1.HardwareSpec{
+				Metadata: &tinkv1alpha1.HardwareMetadata{
+					Instance: &tinkv1alpha1.MetadataInstance{
+						ID:       instanceID,
+						Hostname: hostname,
+					},
+				},
+			},
+		}
+	}
+
+	// Updates to other hardware and updates that don't change the spec should be ignored.
+	handler.OnUpdate(newHardware("other-id", "foo"), newHardware("other-id", "bar"))
+	handler.OnUpdate(newHardware("instance-id", "foo"), newHardware("instance-id", "foo"))
+	handler.OnUpdate(newHardware("instance-id", "foo"), newHardware("instance-id", "bar"))
+
+	hw, err := watcher.Recv()
+	require.NoError(t, err)
+
+	exported, err := hw.Export()
+	require.NoError(t, err)
+	assert.Contains(t, string(exported), `"hostname":"bar"`)
+
+	// Changing the instance ID ends the stream of watchers on the previous ID.
+	renamedWatcher, err := client.Watch(ctx, "renamed-id")
+	require.NoError(t, err)
+
+	handler.OnUpdate(newHardware("instance-id", "bar"), newHardware("renamed-id", "bar"))
+
+	_, err = watcher.Recv()
+	assert.ErrorIs(t, err, io.EOF)
+
+	hw, err = renamedWatcher.Recv()
+	require.NoError(t, err)
+
+	id, err := hw.ID()
+	require.NoError(t, err)
+	assert.Equal(t, "renamed-id", id)
+
+	handler.OnDelete(newHardware("renamed-id", "bar"))
+
+	_, err = renamedWatcher.Recv()
+	assert.ErrorIs(t, err, io.EOF)
+}
+
+func TestHardwareInstanceIDIndexFunc(t *testing.T) {
+	hw := &tinkv1alpha1.Hardware{
+		Spec: tinkv1alpha1.HardwareSpec{
+			Metadata: &tinkv1alpha1.HardwareMetadata{
+				Instance: &tinkv1alpha1.MetadataInstance{ID: "instance-1"},
+			},
+		},
+	}
+
+	assert.Equal(t, []string{"instance-1"}, hardware.HardwareInstanceIDIndexFunc(hw))
+	assert.Empty(t, hardware.HardwareInstanceIDIndexFunc(&tinkv1alpha1.Hardware{}))
+}
+
+func TestKubernetesClientRecordPhoneHome(t *testing.T) {
+	patcherClient := &PatcherClientMock{}
+	patcherClient.
+		On("List", mock.Anything, mock.Anything, mock.Anything).
+		Run(func(args mock.Arguments) {
+			hw := args.Get(1).(*tinkv1alpha1.HardwareList)
+			opts := args.Get(2).([]crclient.ListOption)
+			matchingFields := opts[0].(crclient.MatchingFields)
+			for _, id := range []string{"first", "second"} {
+				if matchingFields[hardware.HardwareInstanceIDIndex] != id {
+					continue
+				}
+				hw.Items = append(hw.Items, tinkv1alpha1.Hardware{
+					ObjectMeta: v1.ObjectMeta{Name: id + "-name"},
+					Spec: tinkv1alpha1.HardwareSpec{
+						Metadata: &tinkv1alpha1.HardwareMetadata{
+							Instance: &tinkv1alpha1.MetadataInstance{ID: id},
+						},
+					},
+				})
+			}
+		}).
+		Return((error)(nil))
+	patcherClient.On("Patch", mock.Anything, mock.Anything, mock.Anything, mock.Anything).Return((error)(nil))
+
+	client := hardware.NewKubernetesClientWithClient(patcherClient)
+
+	report := hardware.PhoneHome{
+		Time:    time.Date(2022, 6, 1, 12, 0, 0, 0, time.UTC),
+		IP:      "10.0.0.1",
+		Payload: map[string]string{"hostname": "second"},
+	}
+	require.NoError(t, client.RecordPhoneHome(context.Background(), "second", report))
+
+	patcherClient.AssertNumberOfCalls(t, "Patch", 1)
+	patched := patcherClient.Calls[1].Arguments.Get(1).(*tinkv1alpha1.Hardware)
+	assert.Equal(t, "second-name", patched.Name)
+	assert.Equal(t, map[string]string{
+		hardware.PhoneHomeTimeAnnotation: "2022-06-01T12:00:00Z",
+		hardware.PhoneHomeAnnotation:     `{"time":"2022-06-01T12:00:00Z","ip":"10.0.0.1","payload":{"hostname":"second"}}`,
+	}, patched.Annotations)
+
+	err := client.RecordPhoneHome(context.Background(), "unknown", report)
+	assert.ErrorIs(t, err, hardware.ErrNotFound)
+}
+
 type ListerClientMock struct {
 	mock.Mock
 }
@@ -166,3 +395,11 @@ type ListerClientMock struct {
 func (c *ListerClientMock) List(ctx context.Context, list crclient.ObjectList, opts ...crclient.ListOption) error {
 	return c.Called(ctx, list, opts).Error(0)
 }
+
+type PatcherClientMock struct {
+	ListerClientMock
+}
+
+func (c *PatcherClientMock) Patch(ctx context.Context, obj crclient.Object, patch crclient.Patch, opts ...crclient.PatchOption) error {
+	return c.Called(ctx, obj, patch, opts).Error(0)
+}
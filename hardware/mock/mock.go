@@ -48,12 +48,38 @@ func (hg HardwareClient) ByIP(_ context.Context, ip string) (hardware.Hardware,
 	}
 }
 
+// ByMAC mocks the retrieval of a piece of hardware from tink/cacher by mac. Like ByIP, it only matches the constant
+// `UserMAC` and ignores the MACs inside `Data`.
+func (hg HardwareClient) ByMAC(ctx context.Context, mac string) (hardware.Hardware, error) {
+	if mac != UserMAC {
+		return nil, errors.Errorf("received non-mock mac address: %v", mac)
+	}
+
+	return hg.ByIP(ctx, UserIP)
+}
+
+// ByID mocks the retrieval of a piece of hardware from tink/cacher by id. Unlike ByIP and ByMAC, it matches the ID
+// of the hardware in `Data`.
+func (hg HardwareClient) ByID(ctx context.Context, id string) (hardware.Hardware, error) {
+	hw, err := hg.ByIP(ctx, UserIP)
+	if err != nil {
+		return nil, err
+	}
+
+	if hwID, err := hw.ID(); err != nil || hwID != id {
+		return nil, errors.Errorf("received non-mock id: %v", id)
+	}
+
+	return hw, nil
+}
+
 func (hg HardwareClient) Watch(context.Context, string) (hardware.Watcher, error) {
 	return nil, nil
 }
 
 const (
 	UserIP          = "192.168.1.5" // value is completely arbitrary, as long as it's an IP to be parsed by getIPFromRequest (could even be 0.0.0.0)
+	UserMAC         = "b4:96:91:5f:af:c0"
 	CacherDataModel = `
 	{
 		"allow_pxe": true,
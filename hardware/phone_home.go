@@ -0,0 +1,26 @@
+package hardware
+
+import (
+	"context"
+	"time"
+)
+
+// PhoneHome is a machine's report that it finished booting, such as the one sent by cloud-init's phone_home module.
+type PhoneHome struct {
+	// Time is when the report was received.
+	Time time.Time `json:"time"`
+
+	// IP is the address the report was received from.
+	IP string `json:"ip"`
+
+	// Payload contains the fields posted by the machine.
+	Payload map[string]string `json:"payload,omitempty"`
+}
+
+// PhoneHomeRecorder is implemented by Clients that can persist phone home reports with the hardware in the data
+// provider. Clients that don't implement it only have reports recorded in memory by Hegel.
+type PhoneHomeRecorder interface {
+	// RecordPhoneHome records report for the hardware with the instance ID id. It returns an error wrapping
+	// ErrNotFound if the data provider has no such hardware.
+	RecordPhoneHome(ctx context.Context, id string, report PhoneHome) error
+}
@@ -2,10 +2,14 @@ package hardware
 
 import (
 	"context"
+	"database/sql"
 	"encoding/json"
+	"fmt"
+	"strings"
 
 	tinkpkg "github.com/tinkerbell/tink/pkg"
 	"github.com/tinkerbell/tink/protos/hardware"
+	"google.golang.org/grpc/status"
 )
 
 type Tinkerbell struct {
@@ -33,11 +37,53 @@ func (hg clientTinkerbell) ByIP(ctx context.Context, ip string) (Hardware, error
 	}
 	hw, err := hg.client.ByIP(ctx, in)
 	if err != nil {
-		return nil, err
+		return nil, wrapTinkNotFound(err)
+	}
+	if hw.GetId() == "" {
+		return nil, fmt.Errorf("%w: no hardware with ip '%v'", ErrNotFound, ip)
+	}
+	return &Tinkerbell{hw}, nil
+}
+
+// ByMAC retrieves from Tink the piece of hardware with the specified MAC.
+func (hg clientTinkerbell) ByMAC(ctx context.Context, mac string) (Hardware, error) {
+	in := &hardware.GetRequest{
+		Mac: mac,
+	}
+	hw, err := hg.client.ByMAC(ctx, in)
+	if err != nil {
+		return nil, wrapTinkNotFound(err)
+	}
+	if hw.GetId() == "" {
+		return nil, fmt.Errorf("%w: no hardware with mac '%v'", ErrNotFound, mac)
+	}
+	return &Tinkerbell{hw}, nil
+}
+
+// ByID retrieves from Tink the piece of hardware with the specified ID.
+func (hg clientTinkerbell) ByID(ctx context.Context, id string) (Hardware, error) {
+	in := &hardware.GetRequest{
+		Id: id,
+	}
+	hw, err := hg.client.ByID(ctx, in)
+	if err != nil {
+		return nil, wrapTinkNotFound(err)
+	}
+	if hw.GetId() == "" {
+		return nil, fmt.Errorf("%w: no hardware with id '%v'", ErrNotFound, id)
 	}
 	return &Tinkerbell{hw}, nil
 }
 
+// wrapTinkNotFound wraps err with ErrNotFound if it indicates Tink has no matching hardware. Tink reports missing
+// hardware using the error from its database query rather than a gRPC NotFound status.
+func wrapTinkNotFound(err error) error {
+	if strings.Contains(status.Convert(err).Message(), sql.ErrNoRows.Error()) {
+		return fmt.Errorf("%w: %v", ErrNotFound, err)
+	}
+	return wrapNotFound(err)
+}
+
 // Watch returns a Tink watch client on the hardware with the specified ID.
 func (hg clientTinkerbell) Watch(ctx context.Context, id string) (Watcher, error) {
 	in := &hardware.GetRequest{
@@ -0,0 +1,136 @@
+package hardware
+
+import (
+	"context"
+	"io"
+	"sync"
+)
+
+// watchRegistry fans hardware updates out to Watchers subscribed to a hardware ID. It serves clients that learn about
+// changes from a single source, such as a file system watcher or an informer, rather than a per-subscriber stream.
+type watchRegistry struct {
+	mu       sync.Mutex
+	watchers map[string]map[*chanWatcher]struct{}
+}
+
+func newWatchRegistry() *watchRegistry {
+	return &watchRegistry{
+		watchers: make(map[string]map[*chanWatcher]struct{}),
+	}
+}
+
+// subscribe registers a Watcher for the hardware identified by id. The Watcher is unregistered when ctx is done.
+func (r *watchRegistry) subscribe(ctx context.Context, id string) Watcher {
+	w := &chanWatcher{
+		ctx:     ctx,
+		updates: make(chan Hardware, 1),
+		closed:  make(chan struct{}),
+	}
+
+	r.mu.Lock()
+	if r.watchers[id] == nil {
+		r.watchers[id] = make(map[*chanWatcher]struct{})
+	}
+	r.watchers[id][w] = struct{}{}
+	r.mu.Unlock()
+
+	go func() {
+		select {
+		case <-ctx.Done():
+		case <-w.closed:
+		}
+		r.unsubscribe(id, w)
+	}()
+
+	return w
+}
+
+func (r *watchRegistry) unsubscribe(id string, w *chanWatcher) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	delete(r.watchers[id], w)
+	if len(r.watchers[id]) == 0 {
+		delete(r.watchers, id)
+	}
+}
+
+// publish delivers hw to all Watchers subscribed to id. It never blocks; Watchers that are slow to call Recv() only
+// observe the most recent update.
+func (r *watchRegistry) publish(id string, hw Hardware) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for w := range r.watchers[id] {
+		w.send(hw)
+	}
+}
+
+// close ends the stream of all Watchers subscribed to id. Subsequent calls to their Recv() return io.EOF.
+func (r *watchRegistry) close(id string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for w := range r.watchers[id] {
+		w.close()
+	}
+	delete(r.watchers, id)
+}
+
+// ids returns the hardware IDs that have at least 1 subscribed Watcher.
+func (r *watchRegistry) ids() []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	ids := make([]string, 0, len(r.watchers))
+	for id := range r.watchers {
+		ids = append(ids, id)
+	}
+	return ids
+}
+
+// chanWatcher is a Watcher fed by a watchRegistry.
+type chanWatcher struct {
+	ctx       context.Context
+	updates   chan Hardware
+	closed    chan struct{}
+	closeOnce sync.Once
+}
+
+func (w *chanWatcher) send(hw Hardware) {
+	for {
+		select {
+		case w.updates <- hw:
+			return
+		default:
+		}
+
+		// Discard the pending update in favor of hw.
+		select {
+		case <-w.updates:
+		default:
+		}
+	}
+}
+
+func (w *chanWatcher) close() {
+	w.closeOnce.Do(func() { close(w.closed) })
+}
+
+// Recv blocks until an update is available, the stream is closed or the Watcher's context is done.
+func (w *chanWatcher) Recv() (Hardware, error) {
+	select {
+	case hw := <-w.updates:
+		return hw, nil
+	case <-w.closed:
+		// Deliver any update that raced with closing the stream before reporting the end of the stream.
+		select {
+		case hw := <-w.updates:
+			return hw, nil
+		default:
+			return nil, io.EOF
+		}
+	case <-w.ctx.Done():
+		return nil, w.ctx.Err()
+	}
+}
@@ -0,0 +1,284 @@
+package http
+
+import (
+	"context"
+	"crypto/subtle"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/packethost/pkg/log"
+	"github.com/pkg/errors"
+	"github.com/tinkerbell/hegel/hardware"
+	"github.com/tinkerbell/hegel/metrics"
+)
+
+// adminMetadataPrefix is the path prefix the admin API serves metadata paths as seen by a machine under.
+const adminMetadataPrefix = "/admin/metadata"
+
+// AdminConfig configures the admin API. The admin API serves the metadata of any machine so requests must be
+// authenticated with a bearer token or a client certificate signed by ClientCAPath.
+type AdminConfig struct {
+	// Port is the port the admin API listens on. 0 disables the admin API.
+	Port int
+
+	// Token is the bearer token requests may authenticate with. It requires TLS so the token isn't sent in the clear.
+	// Empty disables bearer token authentication.
+	Token string
+
+	// TLSCertPath and TLSKeyPath are the paths of the PEM encoded certificate and key the admin API serves TLS with.
+	TLSCertPath string
+	TLSKeyPath  string
+
+	// ClientCAPath is the path of a PEM encoded bundle of CA certificates. Requests with a client certificate signed by
+	// one of them are authenticated. It requires TLS. Empty disables client certificate authentication.
+	ClientCAPath string
+}
+
+// Validate returns an error if c leaves the admin API unauthenticated or is otherwise misconfigured.
+func (c AdminConfig) Validate() error {
+	if c.Token == "" && c.ClientCAPath == "" {
+		return errors.New("a token or client CA is required")
+	}
+
+	if (c.TLSCertPath == "") != (c.TLSKeyPath == "") {
+		return errors.New("a TLS certificate and key must be specified together")
+	}
+
+	if c.Token != "" && c.TLSCertPath == "" {
+		return errors.New("a token requires a TLS certificate and key")
+	}
+
+	if c.ClientCAPath != "" && c.TLSCertPath == "" {
+		return errors.New("a client CA requires a TLS certificate and key")
+	}
+
+	return nil
+}
+
+// newAdminServer creates the admin API server. metadata serves the metadata paths rendered by the admin API and
+// phoneHomes holds the phone home reports it lists, see AdminHandler.
+func newAdminServer(logger log.Logger, client hardware.Client, metadata http.Handler, phoneHomes *PhoneHomeStore, config AdminConfig) (*http.Server, error) {
+	if err := config.Validate(); err != nil {
+		return nil, err
+	}
+
+	server := &http.Server{
+		Addr:    fmt.Sprintf(":%d", config.Port),
+		Handler: AdminHandler(logger, client, metadata, phoneHomes, config.Token),
+	}
+
+	if config.ClientCAPath != "" {
+		pem, err := os.ReadFile(config.ClientCAPath)
+		if err != nil {
+			return nil, err
+		}
+
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, errors.Errorf("no certificates in %v", config.ClientCAPath)
+		}
+
+		// Requests without a client certificate may still authenticate with the token.
+		clientAuth := tls.RequireAndVerifyClientCert
+		if config.Token != "" {
+			clientAuth = tls.VerifyClientCertIfGiven
+		}
+
+		server.TLSConfig = &tls.Config{
+			ClientCAs:  pool,
+			ClientAuth: clientAuth,
+			MinVersion: tls.VersionTLS12,
+		}
+	}
+
+	return server, nil
+}
+
+// AdminHandler serves the admin API used to debug the metadata served to machines. Requests must carry token as a
+// bearer token, unless token is empty, or a verified client certificate.
+//
+// GET /admin/hardware returns the exported hardware of the machine identified by exactly one of the ip, mac or id
+// query parameters. The optional filter query parameter is a jq filter run against the exported hardware.
+//
+// GET /admin/metadata/<path> serves <path> from metadata as seen by the machine identified by the ip, mac or id query
+// parameters, for example /admin/metadata/2009-04-04/user-data?mac=00:00:00:00:00:01. Any other query parameters are
+// passed on.
+//
+// GET /admin/phone-home returns the phone home reports recorded in phoneHomes, see PhoneHomeStore.AdminHandler.
+func AdminHandler(logger log.Logger, client hardware.Client, metadata http.Handler, phoneHomes *PhoneHomeStore, token string) http.Handler {
+	logger = logger.With("api", "admin")
+
+	mux := http.NewServeMux()
+	mux.Handle("/admin/hardware", adminHardwareHandler(logger, client))
+	mux.Handle(adminMetadataPrefix+"/", adminMetadataHandler(logger, client, metadata))
+	mux.Handle("/admin/phone-home", phoneHomes.AdminHandler(logger))
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !adminAuthenticated(r, token) {
+			metrics.Errors.WithLabelValues("admin", "auth").Inc()
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+
+		w.Header().Set("Cache-Control", "no-store")
+		mux.ServeHTTP(w, r)
+	})
+}
+
+// adminAuthenticated returns true if r carries token as a bearer token or a client certificate verified by the
+// server.
+func adminAuthenticated(r *http.Request, token string) bool {
+	if r.TLS != nil && len(r.TLS.VerifiedChains) > 0 {
+		return true
+	}
+
+	if token == "" {
+		return false
+	}
+
+	bearer := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+	return subtle.ConstantTimeCompare([]byte(bearer), []byte(token)) == 1
+}
+
+func adminHardwareHandler(logger log.Logger, client hardware.Client) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "GET" {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+
+		hw, status, err := adminLookupHardware(r, client)
+		if err != nil {
+			logger.With("error", err).Info("failed to get hardware")
+			if err := writeJSONError(w, status, err); err != nil {
+				logger.With("error", err).Info("failed to write response")
+			}
+			return
+		}
+
+		ehw, err := hw.Export()
+		if err != nil {
+			logger.With("error", err).Info("failed to export hardware")
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+
+		if filter := r.URL.Query().Get("filter"); filter != "" {
+			code, err := compileFilter(jqFilter(filter))
+			if err != nil {
+				if err := writeJSONError(w, http.StatusBadRequest, errors.Wrap(err, "compile filter")); err != nil {
+					logger.With("error", err).Info("failed to write response")
+				}
+				return
+			}
+
+			ehw, err = filterMetadata(ehw, code, filterArgs{})
+			if err != nil {
+				if err := writeJSONError(w, http.StatusUnprocessableEntity, err); err != nil {
+					logger.With("error", err).Info("failed to write response")
+				}
+				return
+			}
+		} else {
+			w.Header().Set("Content-Type", "application/json")
+		}
+
+		if _, err := w.Write(ehw); err != nil {
+			logger.With("error", err).Info("failed to write response")
+		}
+	})
+}
+
+func adminMetadataHandler(logger log.Logger, client hardware.Client, metadata http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// Only reads are passed on so the admin API can't phone home or otherwise act on behalf of machines.
+		if r.Method != "GET" {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+
+		hw, status, err := adminLookupHardware(r, client)
+		if err != nil {
+			logger.With("error", err).Info("failed to get hardware")
+			if err := writeJSONError(w, status, err); err != nil {
+				logger.With("error", err).Info("failed to write response")
+			}
+			return
+		}
+
+		query := r.URL.Query()
+		query.Del("ip")
+		query.Del("mac")
+		query.Del("id")
+
+		req := r.Clone(withImpersonatedHardware(r.Context(), hw))
+		req.URL.Path = strings.TrimPrefix(r.URL.Path, adminMetadataPrefix)
+		req.URL.RawPath = ""
+		req.URL.RawQuery = query.Encode()
+		req.RequestURI = req.URL.RequestURI()
+
+		// Headers identifying the client would otherwise be trusted by the metadata routers.
+		req.Header.Del("Authorization")
+		req.Header.Del("X-Forwarded-For")
+		req.Header.Del("X-Real-IP")
+		if ip := r.URL.Query().Get("ip"); ip != "" {
+			req.RemoteAddr = net.JoinHostPort(ip, "0")
+		}
+
+		metadata.ServeHTTP(w, req)
+	})
+}
+
+// adminLookupHardware retrieves the hardware identified by the ip, mac or id query parameter of r. If it fails the
+// status code to respond with is returned alongside the error.
+func adminLookupHardware(r *http.Request, client hardware.Client) (hardware.Hardware, int, error) {
+	query := r.URL.Query()
+
+	var selectors []string
+	for _, key := range []string{"ip", "mac", "id"} {
+		if query.Get(key) != "" {
+			selectors = append(selectors, key)
+		}
+	}
+	if len(selectors) != 1 {
+		return nil, http.StatusBadRequest, errors.New("exactly one of the ip, mac or id query parameters is required")
+	}
+
+	var hw hardware.Hardware
+	var err error
+	switch key := selectors[0]; key {
+	case "ip":
+		hw, err = client.ByIP(r.Context(), query.Get(key))
+	case "mac":
+		hw, err = client.ByMAC(r.Context(), query.Get(key))
+	case "id":
+		hw, err = client.ByID(r.Context(), query.Get(key))
+	}
+	if err != nil {
+		metrics.Errors.WithLabelValues("metadata", "lookup").Inc()
+		return nil, http.StatusNotFound, err
+	}
+
+	return hw, http.StatusOK, nil
+}
+
+// impersonatedHardwareKey is the context key of the hardware an admin request is served as.
+type impersonatedHardwareKey struct{}
+
+// withImpersonatedHardware returns a context for requests served as if they were made by the machine of hw. Only the
+// admin API creates them; the metadata listener never does.
+func withImpersonatedHardware(ctx context.Context, hw hardware.Hardware) context.Context {
+	return context.WithValue(ctx, impersonatedHardwareKey{}, hw)
+}
+
+// impersonatedHardware returns the hardware a request is served as, see withImpersonatedHardware.
+func impersonatedHardware(ctx context.Context) (hardware.Hardware, bool) {
+	hw, ok := ctx.Value(impersonatedHardwareKey{}).(hardware.Hardware)
+	return hw, ok
+}
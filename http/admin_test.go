@@ -0,0 +1,205 @@
+package http
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/packethost/pkg/log"
+	"github.com/stretchr/testify/require"
+	"github.com/tinkerbell/hegel/datamodel"
+	"github.com/tinkerbell/hegel/grpc"
+	"github.com/tinkerbell/hegel/hardware/mock"
+)
+
+func TestAdminAPI(t *testing.T) {
+	logger, err := log.Init(t.Name())
+	require.NoError(t, err)
+
+	gin.SetMode(gin.TestMode)
+	client := mock.HardwareClient{Model: datamodel.TinkServer, Data: mock.TinkerbellKantEC2}
+
+	// Tokens are required so admin requests must be served without them.
+	config := ServerConfig{MetadataAPI: true, EC2TokenMode: EC2TokenRequired, IdentitySigner: newTestIdentitySigner(t)}
+	ec2Tokens := NewEC2TokenStore(config.EC2TokenMode)
+	phoneHomes := NewPhoneHomeStore()
+	mux := newRouter(logger, client, grpc.NewServer(logger, client), ec2Tokens, phoneHomes, config)
+	require.NoError(t, registerCustomEndpoints(logger, client, mux, datamodel.TinkServer, `{"/hostname": ".metadata.instance.hostname"}`))
+
+	req, err := http.NewRequest("POST", "/phone-home", strings.NewReader(`{"boot": "done"}`))
+	require.NoError(t, err)
+	req.Header.Set("Content-Type", "application/json")
+	req.RemoteAddr = mock.UserIP
+	resp := httptest.NewRecorder()
+	phoneHomes.PhoneHomeHandler(logger, client).ServeHTTP(resp, req)
+	require.Equal(t, http.StatusOK, resp.Code)
+
+	handler := AdminHandler(logger, client, ec2Tokens.RequireToken(mux), phoneHomes, "secret")
+
+	for name, test := range adminTests {
+		t.Run(name, func(t *testing.T) {
+			method := test.method
+			if method == "" {
+				method = "GET"
+			}
+
+			req, err := http.NewRequest(method, test.url, nil)
+			require.NoError(t, err)
+			req.RemoteAddr = "10.10.10.10:4242"
+			if test.token != "" {
+				req.Header.Set("Authorization", "Bearer "+test.token)
+			}
+			if test.clientCert {
+				req.TLS = &tls.ConnectionState{VerifiedChains: [][]*x509.Certificate{{{}}}}
+			}
+			resp := httptest.NewRecorder()
+
+			handler.ServeHTTP(resp, req)
+
+			require.Equal(t, test.status, resp.Code, resp.Body.String())
+			if test.response != "" {
+				require.Equal(t, test.response, resp.Body.String())
+			}
+		})
+	}
+}
+
+func TestAdminConfigValidate(t *testing.T) {
+	tests := map[string]struct {
+		config AdminConfig
+		error  bool
+	}{
+		"token":                 {config: AdminConfig{Port: 1, Token: "secret", TLSCertPath: "cert.pem", TLSKeyPath: "key.pem"}},
+		"client ca":             {config: AdminConfig{Port: 1, TLSCertPath: "cert.pem", TLSKeyPath: "key.pem", ClientCAPath: "ca.pem"}},
+		"unauthenticated":       {config: AdminConfig{Port: 1}, error: true},
+		"token without tls":     {config: AdminConfig{Port: 1, Token: "secret"}, error: true},
+		"client ca without tls": {config: AdminConfig{Port: 1, ClientCAPath: "ca.pem"}, error: true},
+		"cert without key":      {config: AdminConfig{Port: 1, Token: "secret", TLSCertPath: "cert.pem"}, error: true},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			err := test.config.Validate()
+			if test.error {
+				require.Error(t, err)
+			} else {
+				require.NoError(t, err)
+			}
+		})
+	}
+}
+
+// test cases for TestAdminAPI.
+var adminTests = map[string]struct {
+	method     string
+	url        string
+	token      string
+	clientCert bool
+	status     int
+	response   string
+}{
+	"no credentials": {
+		url:    "/admin/hardware?ip=192.168.1.5",
+		status: http.StatusUnauthorized,
+	},
+	"wrong token": {
+		url:    "/admin/hardware?ip=192.168.1.5",
+		token:  "wrong",
+		status: http.StatusUnauthorized,
+	},
+	"client certificate": {
+		url:        "/admin/hardware?ip=192.168.1.5&filter=.metadata.instance.hostname",
+		clientCert: true,
+		status:     http.StatusOK,
+		response:   "tink-provisioner",
+	},
+	"hardware by ip": {
+		url:      "/admin/hardware?ip=192.168.1.5&filter=.metadata.instance.hostname",
+		token:    "secret",
+		status:   http.StatusOK,
+		response: "tink-provisioner",
+	},
+	"hardware by mac": {
+		url:      "/admin/hardware?mac=b4:96:91:5f:af:c0&filter=.id",
+		token:    "secret",
+		status:   http.StatusOK,
+		response: "0eba0bf8-3772-4b4a-ab9f-6ebe93b90a94",
+	},
+	"hardware by id": {
+		url:      "/admin/hardware?id=0eba0bf8-3772-4b4a-ab9f-6ebe93b90a94&filter=.metadata.instance.plan",
+		token:    "secret",
+		status:   http.StatusOK,
+		response: "c3.small.x86",
+	},
+	"unknown hardware": {
+		url:    "/admin/hardware?ip=192.168.1.6",
+		token:  "secret",
+		status: http.StatusNotFound,
+	},
+	"no selector": {
+		url:    "/admin/hardware",
+		token:  "secret",
+		status: http.StatusBadRequest,
+	},
+	"multiple selectors": {
+		url:    "/admin/hardware?ip=192.168.1.5&mac=b4:96:91:5f:af:c0",
+		token:  "secret",
+		status: http.StatusBadRequest,
+	},
+	"invalid filter": {
+		url:    "/admin/hardware?ip=192.168.1.5&filter=invalid",
+		token:  "secret",
+		status: http.StatusBadRequest,
+	},
+	"ec2 path": {
+		url:      "/admin/metadata/2009-04-04/meta-data/hostname?id=0eba0bf8-3772-4b4a-ab9f-6ebe93b90a94",
+		token:    "secret",
+		status:   http.StatusOK,
+		response: "tink-provisioner",
+	},
+	"custom endpoint": {
+		url:      "/admin/metadata/hostname?mac=b4:96:91:5f:af:c0",
+		token:    "secret",
+		status:   http.StatusOK,
+		response: "tink-provisioner",
+	},
+	"phone home": {
+		method: "POST",
+		url:    "/admin/metadata/phone-home?ip=192.168.1.5",
+		token:  "secret",
+		status: http.StatusMethodNotAllowed,
+	},
+	"instance identity document": {
+		url:    "/admin/metadata/2009-04-04/dynamic/instance-identity/document?ip=192.168.1.5",
+		token:  "secret",
+		status: http.StatusOK,
+	},
+	"instance identity signature": {
+		url:    "/admin/metadata/2009-04-04/dynamic/instance-identity/signature?ip=192.168.1.5",
+		token:  "secret",
+		status: http.StatusForbidden,
+	},
+	"instance identity pkcs7": {
+		url:    "/admin/metadata/2009-04-04/dynamic/instance-identity/pkcs7?ip=192.168.1.5",
+		token:  "secret",
+		status: http.StatusForbidden,
+	},
+	"phone home reports": {
+		url:    "/admin/phone-home",
+		token:  "secret",
+		status: http.StatusOK,
+	},
+	"phone home report": {
+		url:    "/admin/phone-home?id=0eba0bf8-3772-4b4a-ab9f-6ebe93b90a94",
+		token:  "secret",
+		status: http.StatusOK,
+	},
+	"phone home reports without credentials": {
+		url:    "/admin/phone-home",
+		status: http.StatusUnauthorized,
+	},
+}
@@ -1,7 +1,6 @@
 package http
 
 import (
-	"context"
 	"encoding/json"
 	"fmt"
 	"net/http"
@@ -20,10 +19,19 @@ type metadataJSON struct {
 	Gateway    string                         `json:"gateway,omitempty"`
 }
 
-func v0HegelMetadataHandler(logger log.Logger, client hardware.Client, rg *gin.RouterGroup) {
+func v0HegelMetadataHandler(logger log.Logger, client hardware.Client, vendorData *VendorData, rg *gin.RouterGroup) {
+	rg.GET("/events", eventsHandler(logger, client))
+
+	// Responses other than the event stream carry an ETag so agents polling them can make conditional requests.
+	rg = rg.Group("", conditionalGET(logger))
+
 	userdata := rg.Group("/user-data")
 	userdata.GET("", userdataHandler(logger, client))
 
+	rg.GET("/vendor-data", vendorDataHandler(logger, client, vendorData))
+
+	rg.GET("/network-config", networkConfigHandler(logger, client))
+
 	metadata := rg.Group("/meta-data")
 	metadata.GET("", metadataHandler(logger, client))
 
@@ -47,17 +55,29 @@ func v0HegelMetadataHandler(logger log.Logger, client hardware.Client, rg *gin.R
 	metadata.GET("/:mac/ipv6/:index/netmask", ipv6NetmaskHandler(logger, client))
 }
 
-func getHardware(ctx context.Context, client hardware.Client, ip string) (hardware.K8sHardware, error) {
-	hw, err := client.ByIP(ctx, ip)
+func getHardware(c *gin.Context, client hardware.Client) (hardware.K8sHardware, error) {
+	_, ehw, err := exportHardware(c, client)
 	if err != nil {
 		return hardware.K8sHardware{}, err
 	}
+	return reverseHardware(ehw)
+}
+
+// exportHardware looks up and exports the hardware of the client making the request.
+func exportHardware(c *gin.Context, client hardware.Client) (hardware.Hardware, []byte, error) {
+	hw, err := lookupHardware(c.Request, client, c.ClientIP())
+	if err != nil {
+		return nil, nil, err
+	}
 
 	ehw, err := hw.Export()
 	if err != nil {
-		return hardware.K8sHardware{}, err
+		return nil, nil, err
 	}
+	return hw, ehw, nil
+}
 
+func reverseHardware(ehw []byte) (hardware.K8sHardware, error) {
 	var reversed hardware.K8sHardware
 	if err := json.Unmarshal(ehw, &reversed); err != nil {
 		return hardware.K8sHardware{}, err
@@ -67,7 +87,13 @@ func getHardware(ctx context.Context, client hardware.Client, ip string) (hardwa
 
 func userdataHandler(logger log.Logger, client hardware.Client) gin.HandlerFunc {
 	fn := func(c *gin.Context) {
-		hardwareData, err := getHardware(c, client, c.ClientIP())
+		hw, ehw, err := exportHardware(c, client)
+		if err != nil {
+			logger.With("error", err).Info("failed to get hardware in v0 metadata handler")
+			c.JSON(http.StatusNotFound, nil)
+			return
+		}
+		hardwareData, err := reverseHardware(ehw)
 		if err != nil {
 			logger.With("error", err).Info("failed to get hardware in v0 metadata handler")
 			c.JSON(http.StatusNotFound, nil)
@@ -77,8 +103,31 @@ func userdataHandler(logger log.Logger, client hardware.Client) gin.HandlerFunc
 		if data == nil {
 			c.String(http.StatusOK, "")
 		} else {
-			c.String(http.StatusOK, *data)
+			c.String(http.StatusOK, string(renderUserdata(logger, hw, ehw, []byte(*data))))
+		}
+	}
+	return gin.HandlerFunc(fn)
+}
+
+func networkConfigHandler(logger log.Logger, client hardware.Client) gin.HandlerFunc {
+	fn := func(c *gin.Context) {
+		hw, err := lookupHardware(c.Request, client, c.ClientIP())
+		if err != nil {
+			logger.With("error", err).Info("failed to get hardware in v0 metadata handler")
+			c.JSON(http.StatusNotFound, nil)
+			return
+		}
+		resp, err := renderNetworkConfig(hw)
+		if err != nil {
+			logger.With("error", err).Info("failed to render network config in v0 metadata handler")
+			c.JSON(http.StatusInternalServerError, nil)
+			return
+		}
+		if resp == nil {
+			c.JSON(http.StatusNotFound, nil)
+			return
 		}
+		c.Data(http.StatusOK, "application/yaml", resp)
 	}
 	return gin.HandlerFunc(fn)
 }
@@ -92,7 +141,7 @@ func metadataHandler(logger log.Logger, client hardware.Client) gin.HandlerFunc
 			}
 		}
 		if acceptJSON {
-			hardwareData, err := getHardware(c, client, c.ClientIP())
+			hardwareData, err := getHardware(c, client)
 			if err != nil {
 				logger.With("error", err).Info("failed to get hardware in v0 metadata handler")
 				c.JSON(http.StatusNotFound, nil)
@@ -115,7 +164,7 @@ func metadataHandler(logger log.Logger, client hardware.Client) gin.HandlerFunc
 
 func diskHandler(logger log.Logger, client hardware.Client) gin.HandlerFunc {
 	fn := func(c *gin.Context) {
-		hardwareData, err := getHardware(c, client, c.ClientIP())
+		hardwareData, err := getHardware(c, client)
 		if err != nil {
 			logger.With("error", err).Info("failed to get hardware in v0 metadata handler")
 			c.JSON(http.StatusNotFound, nil)
@@ -131,7 +180,7 @@ func diskHandler(logger log.Logger, client hardware.Client) gin.HandlerFunc {
 
 func diskIndexHandler(logger log.Logger, client hardware.Client) gin.HandlerFunc {
 	fn := func(c *gin.Context) {
-		hardwareData, err := getHardware(c, client, c.ClientIP())
+		hardwareData, err := getHardware(c, client)
 		if err != nil {
 			logger.With("error", err).Info("failed to get hardware in v0 metadata handler")
 			c.JSON(http.StatusNotFound, nil)
@@ -156,7 +205,7 @@ func diskIndexHandler(logger log.Logger, client hardware.Client) gin.HandlerFunc
 
 func sshHandler(logger log.Logger, client hardware.Client) gin.HandlerFunc {
 	fn := func(c *gin.Context) {
-		hardwareData, err := getHardware(c, client, c.ClientIP())
+		hardwareData, err := getHardware(c, client)
 		if err != nil {
 			logger.With("error", err).Info("failed to get hardware in v0 metadata handler")
 			c.JSON(http.StatusNotFound, nil)
@@ -172,7 +221,7 @@ func sshHandler(logger log.Logger, client hardware.Client) gin.HandlerFunc {
 
 func sshIndexHandler(logger log.Logger, client hardware.Client) gin.HandlerFunc {
 	fn := func(c *gin.Context) {
-		hardwareData, err := getHardware(c, client, c.ClientIP())
+		hardwareData, err := getHardware(c, client)
 		if err != nil {
 			logger.With("error", err).Info("failed to get hardware in v0 metadata handler")
 			c.JSON(http.StatusNotFound, nil)
@@ -197,7 +246,7 @@ func sshIndexHandler(logger log.Logger, client hardware.Client) gin.HandlerFunc
 
 func hostnameHandler(logger log.Logger, client hardware.Client) gin.HandlerFunc {
 	fn := func(c *gin.Context) {
-		hardwareData, err := getHardware(c, client, c.ClientIP())
+		hardwareData, err := getHardware(c, client)
 		if err != nil {
 			logger.With("error", err).Info("failed to get hardware in v0 metadata handler")
 			c.JSON(http.StatusNotFound, nil)
@@ -211,7 +260,7 @@ func hostnameHandler(logger log.Logger, client hardware.Client) gin.HandlerFunc
 
 func gatewayHandler(logger log.Logger, client hardware.Client) gin.HandlerFunc {
 	fn := func(c *gin.Context) {
-		hardwareData, err := getHardware(c, client, c.ClientIP())
+		hardwareData, err := getHardware(c, client)
 		if err != nil {
 			logger.With("error", err).Info("failed to get hardware in v0 metadata handler")
 			c.JSON(http.StatusNotFound, nil)
@@ -225,7 +274,7 @@ func gatewayHandler(logger log.Logger, client hardware.Client) gin.HandlerFunc {
 
 func macHandler(logger log.Logger, client hardware.Client) gin.HandlerFunc {
 	fn := func(c *gin.Context) {
-		hardwareData, err := getHardware(c, client, c.ClientIP())
+		hardwareData, err := getHardware(c, client)
 		if err != nil {
 			logger.With("error", err).Info("failed to get hardware in v0 metadata handler")
 			c.JSON(http.StatusNotFound, nil)
@@ -421,7 +470,7 @@ func ipv6NetmaskHandler(logger log.Logger, client hardware.Client) gin.HandlerFu
 }
 
 func getValidNetworkInterfaces(logger log.Logger, client hardware.Client, c *gin.Context) []hardware.K8sNetworkInterface {
-	hardwareData, err := getHardware(c, client, c.ClientIP())
+	hardwareData, err := getHardware(c, client)
 	if err != nil {
 		logger.With("error", err).Info("failed to get hardware in v0 metadata handler")
 		c.JSON(http.StatusNotFound, nil)
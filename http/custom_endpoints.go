@@ -0,0 +1,450 @@
+package http
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+	"github.com/packethost/pkg/log"
+	"github.com/pkg/errors"
+	"github.com/tinkerbell/hegel/datamodel"
+	"github.com/tinkerbell/hegel/hardware"
+	"github.com/tinkerbell/hegel/metrics"
+	"go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
+	"sigs.k8s.io/yaml"
+)
+
+// customEndpointsReloadDelay is how long CustomEndpoints waits for file system events to settle before reloading. It
+// avoids loading files that are part way through being written.
+const customEndpointsReloadDelay = 100 * time.Millisecond
+
+// FilterEngine is the language a custom endpoint's filter is written in.
+type FilterEngine string
+
+const (
+	// FilterEngineJQ filters are jq programs run against the exported hardware. It's the default.
+	FilterEngineJQ FilterEngine = "jq"
+
+	// FilterEngineTemplate filters are Go text/templates rendered against the exported hardware.
+	FilterEngineTemplate FilterEngine = "template"
+)
+
+// OutputFormat is how a custom endpoint's filter results are written.
+type OutputFormat string
+
+const (
+	// OutputFormatRaw writes strings as is and other values as JSON, one result per line. It's the default.
+	OutputFormatRaw OutputFormat = "raw"
+
+	// OutputFormatJSON writes the result as JSON. Multiple results are written as an array.
+	OutputFormatJSON OutputFormat = "json"
+
+	// OutputFormatYAML writes the result as YAML. Multiple results are written as a sequence.
+	OutputFormatYAML OutputFormat = "yaml"
+)
+
+// CustomEndpointsConfig is the custom endpoints config file.
+type CustomEndpointsConfig struct {
+	// Endpoints maps URL paths to the endpoint served at them. Paths follow http.ServeMux patterns.
+	Endpoints map[string]CustomEndpoint `json:"endpoints"`
+}
+
+// CustomEndpoint serves the result of a filter run against the requesting machine's exported hardware.
+type CustomEndpoint struct {
+	// Filter is run against the exported hardware. An empty filter serves the exported hardware.
+	Filter string `json:"filter"`
+
+	// ModelFilters maps data models, as passed to --data-model, to filters used in place of Filter when Hegel runs
+	// with that data model. The cacher data model may be referred to as "cacher".
+	ModelFilters map[string]string `json:"model_filters,omitempty"`
+
+	// Engine is the language of the filters. Defaults to FilterEngineJQ.
+	Engine FilterEngine `json:"engine,omitempty"`
+
+	// Format is how results are written. Only FilterEngineJQ filters support formats other than OutputFormatRaw.
+	Format OutputFormat `json:"format,omitempty"`
+
+	// ContentType is the Content-Type of responses. Defaults to that of the format; raw responses have their content
+	// type detected.
+	ContentType string `json:"content_type,omitempty"`
+}
+
+// LoadCustomEndpointsConfig reads a YAML or JSON custom endpoints config file and validates it.
+func LoadCustomEndpointsConfig(path string) (*CustomEndpointsConfig, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var config CustomEndpointsConfig
+	if err := yaml.UnmarshalStrict(data, &config); err != nil {
+		return nil, errors.Wrapf(err, "parse %v", path)
+	}
+
+	if err := config.Validate(); err != nil {
+		return nil, errors.Wrapf(err, "invalid %v", path)
+	}
+	return &config, nil
+}
+
+// Validate returns an error if any endpoint is misconfigured or has a filter that doesn't parse.
+func (c *CustomEndpointsConfig) Validate() error {
+	for path, endpoint := range c.Endpoints {
+		if !strings.HasPrefix(path, "/") {
+			return errors.Errorf("endpoint %v: path must start with '/'", path)
+		}
+		if err := endpoint.Validate(); err != nil {
+			return errors.Wrapf(err, "endpoint %v", path)
+		}
+	}
+	return nil
+}
+
+// Validate returns an error if e is misconfigured or has a filter that doesn't parse.
+func (e CustomEndpoint) Validate() error {
+	switch e.Format {
+	case "", OutputFormatRaw, OutputFormatJSON, OutputFormatYAML:
+	default:
+		return errors.Errorf("unknown format: %v", e.Format)
+	}
+
+	filters := []string{e.Filter}
+	for _, filter := range e.ModelFilters {
+		filters = append(filters, filter)
+	}
+
+	switch e.Engine {
+	case "", FilterEngineJQ:
+		for _, filter := range filters {
+			if _, err := compileFilter(jqFilter(filter)); err != nil {
+				return errors.Wrapf(err, "compile filter %q", filter)
+			}
+		}
+	case FilterEngineTemplate:
+		if e.Format != "" && e.Format != OutputFormatRaw {
+			return errors.Errorf("format %v isn't supported by the %v engine", e.Format, e.Engine)
+		}
+		for _, filter := range filters {
+			if _, err := parseTemplate("", filter); err != nil {
+				return errors.Wrapf(err, "filter %q", filter)
+			}
+		}
+	default:
+		return errors.Errorf("unknown engine: %v", e.Engine)
+	}
+
+	return nil
+}
+
+// filterFor returns the filter for the data model.
+func (e CustomEndpoint) filterFor(model datamodel.DataModel) string {
+	if filter, ok := e.ModelFilters[string(model)]; ok {
+		return filter
+	}
+	if filter, ok := e.ModelFilters["cacher"]; ok && model == datamodel.Cacher {
+		return filter
+	}
+	return e.Filter
+}
+
+// compile compiles the endpoint's filter for model into a function that renders the exported hardware.
+func (e CustomEndpoint) compile(model datamodel.DataModel) (func(ehw []byte) ([]byte, error), error) {
+	filter := e.filterFor(model)
+
+	if e.Engine == FilterEngineTemplate {
+		tmpl, err := parseTemplate("endpoint", filter)
+		if err != nil {
+			return nil, err
+		}
+		return func(ehw []byte) ([]byte, error) { return executeTemplate(tmpl, ehw) }, nil
+	}
+
+	code, err := compileFilter(jqFilter(filter))
+	if err != nil {
+		return nil, errors.Wrapf(err, "compile filter %q", filter)
+	}
+
+	if e.Format == "" || e.Format == OutputFormatRaw {
+		return func(ehw []byte) ([]byte, error) { return filterMetadata(ehw, code, filterArgs{}) }, nil
+	}
+
+	return func(ehw []byte) ([]byte, error) {
+		values, err := evalFilter(ehw, code, filterArgs{})
+		if err != nil {
+			return nil, err
+		}
+
+		var value interface{} = values
+		switch len(values) {
+		case 0:
+			value = nil
+		case 1:
+			value = values[0]
+		}
+
+		resp, err := json.Marshal(value)
+		if err != nil {
+			return nil, errors.Wrap(err, "marshal result")
+		}
+		if e.Format == OutputFormatYAML {
+			return yaml.JSONToYAML(resp)
+		}
+		return resp, nil
+	}, nil
+}
+
+// servesUserdata returns true if the endpoint serves the raw userdata for model, which is rendered as the built-in
+// userdata endpoints render it.
+func (e CustomEndpoint) servesUserdata(model datamodel.DataModel) bool {
+	return e.Engine != FilterEngineTemplate && (e.Format == "" || e.Format == OutputFormatRaw) &&
+		e.filterFor(model) == userdataFilter
+}
+
+// contentType returns the Content-Type of the endpoint's responses or an empty string if it should be detected.
+func (e CustomEndpoint) contentType() string {
+	if e.ContentType != "" {
+		return e.ContentType
+	}
+
+	switch e.Format {
+	case OutputFormatJSON:
+		return "application/json"
+	case OutputFormatYAML:
+		return "application/yaml"
+	default:
+		return ""
+	}
+}
+
+// jqFilter returns filter, or the identity if filter is empty.
+func jqFilter(filter string) string {
+	if strings.TrimSpace(filter) == "" {
+		return "."
+	}
+	return filter
+}
+
+// CustomEndpointHandler serves endpoint for the requesting machine. Responses carry an ETag and If-None-Match requests
+// are honored. An error is returned if the endpoint's filter for model doesn't compile.
+func CustomEndpointHandler(logger log.Logger, client hardware.Client, endpoint CustomEndpoint, model datamodel.DataModel) (http.Handler, error) {
+	render, err := endpoint.compile(model)
+	if err != nil {
+		return nil, err
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "GET" {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+
+		userIP := getIPFromRequest(r)
+		if userIP == "" {
+			logger.Info("Could not retrieve IP address")
+			return
+		}
+
+		metrics.MetadataRequests.Inc()
+		logger := logger.With("userIP", userIP, "path", r.URL.Path)
+
+		hw, err := lookupHardware(r, client, userIP)
+		if err != nil {
+			metrics.Errors.WithLabelValues("metadata", "lookup").Inc()
+			logger.With("error", err).Info("failed to get hardware by ip")
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+
+		ehw, err := hw.Export()
+		if err != nil {
+			logger.With("error", err).Info("failed to export hardware")
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+
+		resp, err := render(ehw)
+		if err != nil {
+			logger.With("error", err).Info("failed to render custom endpoint")
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		if endpoint.servesUserdata(model) {
+			resp = renderUserdata(logger, hw, ehw, resp)
+		}
+
+		if contentType := endpoint.contentType(); contentType != "" {
+			w.Header().Set("Content-Type", contentType)
+		}
+		if err := writeMetadata(w, r, resp); err != nil {
+			logger.With("error", err).Info("failed to write response")
+		}
+	}), nil
+}
+
+// CustomEndpoints serves the endpoints of a custom endpoints config file. Changes to the file are picked up
+// automatically; if the changed file is invalid the previous endpoints continue to be served. Paths of built-in
+// endpoints take precedence when CustomEndpoints is registered as a http.ServeMux's "/" fallback.
+type CustomEndpoints struct {
+	logger log.Logger
+	client hardware.Client
+	model  datamodel.DataModel
+	path   string
+
+	mu     sync.RWMutex
+	mux    *http.ServeMux
+	closed bool
+
+	// reloadTimer debounces reloads. Its only accessed with mu held.
+	reloadTimer *time.Timer
+
+	fsWatcher *fsnotify.Watcher
+}
+
+// NewCustomEndpoints loads the custom endpoints config file at path. It launches a goroutine that reloads the config
+// whenever the file changes. Call Close() to stop watching the file.
+func NewCustomEndpoints(logger log.Logger, client hardware.Client, model datamodel.DataModel, path string) (*CustomEndpoints, error) {
+	endpoints := &CustomEndpoints{
+		logger: logger.With("customEndpoints", path),
+		client: client,
+		model:  model,
+		path:   path,
+	}
+
+	mux, err := endpoints.load()
+	if err != nil {
+		return nil, err
+	}
+	endpoints.mux = mux
+
+	fsWatcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return nil, errors.Wrap(err, "creating file watcher")
+	}
+
+	// Editors, config management tools and Kubernetes ConfigMap mounts commonly replace files rather than writing them
+	// in place so we watch the parent directory.
+	if err := fsWatcher.Add(filepath.Dir(path)); err != nil {
+		fsWatcher.Close()
+		return nil, errors.Wrapf(err, "watching %v", filepath.Dir(path))
+	}
+
+	endpoints.fsWatcher = fsWatcher
+	go endpoints.watchFile()
+
+	return endpoints, nil
+}
+
+// ServeHTTP serves the custom endpoint matching r.
+func (e *CustomEndpoints) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	e.mu.RLock()
+	mux := e.mux
+	e.mu.RUnlock()
+
+	mux.ServeHTTP(w, r)
+}
+
+// Close stops watching the config file. The endpoints loaded last continue to be served.
+func (e *CustomEndpoints) Close() {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if e.closed {
+		return
+	}
+
+	e.closed = true
+	if e.reloadTimer != nil {
+		e.reloadTimer.Stop()
+	}
+	e.fsWatcher.Close()
+}
+
+// load reads the config file and builds a http.ServeMux serving its endpoints.
+func (e *CustomEndpoints) load() (*http.ServeMux, error) {
+	config, err := LoadCustomEndpointsConfig(e.path)
+	if err != nil {
+		return nil, err
+	}
+
+	paths := make([]string, 0, len(config.Endpoints))
+	for path := range config.Endpoints {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+
+	mux := http.NewServeMux()
+	for _, path := range paths {
+		handler, err := CustomEndpointHandler(e.logger, e.client, config.Endpoints[path], e.model)
+		if err != nil {
+			return nil, errors.Wrapf(err, "endpoint %v", path)
+		}
+		mux.Handle(path, otelhttp.WithRouteTag(path, handler))
+	}
+
+	e.logger.With("endpoints", fmt.Sprint(paths)).Info("loaded custom endpoints")
+	return mux, nil
+}
+
+func (e *CustomEndpoints) watchFile() {
+	for {
+		select {
+		case event, ok := <-e.fsWatcher.Events:
+			if !ok {
+				return
+			}
+
+			if event.Op == fsnotify.Chmod {
+				continue
+			}
+
+			// Kubernetes ConfigMap mounts swap a symlinked directory so changes to other files in the directory may
+			// change the config file too.
+			e.scheduleReload()
+
+		case err, ok := <-e.fsWatcher.Errors:
+			if !ok {
+				return
+			}
+			e.logger.With("error", err).Info("custom endpoints file watcher error")
+		}
+	}
+}
+
+// scheduleReload reloads the config file after customEndpointsReloadDelay, postponing any pending reload.
+func (e *CustomEndpoints) scheduleReload() {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if e.closed {
+		return
+	}
+
+	if e.reloadTimer == nil {
+		e.reloadTimer = time.AfterFunc(customEndpointsReloadDelay, e.reload)
+	} else {
+		e.reloadTimer.Reset(customEndpointsReloadDelay)
+	}
+}
+
+// reload re-reads the config file. It does nothing once CustomEndpoints is closed.
+func (e *CustomEndpoints) reload() {
+	mux, err := e.load()
+	if err != nil {
+		metrics.Errors.WithLabelValues("custom-endpoints", "reload").Inc()
+		e.logger.Error(errors.Wrap(err, "reload custom endpoints"))
+		return
+	}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if !e.closed {
+		e.mux = mux
+	}
+}
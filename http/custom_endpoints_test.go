@@ -0,0 +1,177 @@
+package http
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/packethost/pkg/log"
+	"github.com/stretchr/testify/require"
+	"github.com/tinkerbell/hegel/datamodel"
+	"github.com/tinkerbell/hegel/hardware/mock"
+)
+
+func TestCustomEndpoint(t *testing.T) {
+	logger, err := log.Init(t.Name())
+	require.NoError(t, err)
+
+	for name, test := range customEndpointTests {
+		t.Run(name, func(t *testing.T) {
+			require.NoError(t, test.endpoint.Validate())
+
+			client := mock.HardwareClient{Model: datamodel.TinkServer, Data: mock.TinkerbellKantEC2}
+			handler, err := CustomEndpointHandler(logger, client, test.endpoint, datamodel.TinkServer)
+			require.NoError(t, err)
+
+			req, err := http.NewRequest("GET", "/custom", nil)
+			require.NoError(t, err)
+			req.RemoteAddr = mock.UserIP
+			resp := httptest.NewRecorder()
+
+			handler.ServeHTTP(resp, req)
+
+			require.Equal(t, http.StatusOK, resp.Code)
+			require.Equal(t, test.response, resp.Body.String())
+			if test.contentType != "" {
+				require.Equal(t, test.contentType, resp.Header().Get("Content-Type"))
+			}
+		})
+	}
+}
+
+func TestLoadCustomEndpointsConfig(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "endpoints.yaml")
+	err := os.WriteFile(path, []byte(`endpoints:
+  /metadata:
+    filter: .metadata.instance
+    format: json
+  /hostname:
+    filter: .metadata.instance.hostname
+    model_filters:
+      cacher: .hostname
+  /motd:
+    engine: template
+    content_type: text/plain
+    filter: "Welcome to {{ .metadata.instance.hostname }}"
+`), 0o600)
+	require.NoError(t, err)
+
+	config, err := LoadCustomEndpointsConfig(path)
+	require.NoError(t, err)
+	require.Equal(t, &CustomEndpointsConfig{
+		Endpoints: map[string]CustomEndpoint{
+			"/metadata": {Filter: ".metadata.instance", Format: OutputFormatJSON},
+			"/hostname": {Filter: ".metadata.instance.hostname", ModelFilters: map[string]string{"cacher": ".hostname"}},
+			"/motd":     {Filter: "Welcome to {{ .metadata.instance.hostname }}", Engine: FilterEngineTemplate, ContentType: "text/plain"},
+		},
+	}, config)
+	require.Equal(t, ".hostname", config.Endpoints["/hostname"].filterFor(datamodel.Cacher))
+
+	for name, invalid := range map[string]string{
+		"unknown field":     "endpoints:\n  /a:\n    filters: .\n",
+		"relative path":     "endpoints:\n  a:\n    filter: .\n",
+		"unknown format":    "endpoints:\n  /a:\n    format: toml\n",
+		"unknown engine":    "endpoints:\n  /a:\n    engine: lua\n",
+		"invalid jq":        "endpoints:\n  /a:\n    filter: .[\n",
+		"invalid template":  "endpoints:\n  /a:\n    engine: template\n    filter: '{{ .a'\n",
+		"template and json": "endpoints:\n  /a:\n    engine: template\n    format: json\n",
+	} {
+		t.Run(name, func(t *testing.T) {
+			require.NoError(t, os.WriteFile(path, []byte(invalid), 0o600))
+			_, err := LoadCustomEndpointsConfig(path)
+			require.Error(t, err)
+		})
+	}
+}
+
+func TestCustomEndpointsReload(t *testing.T) {
+	logger, err := log.Init(t.Name())
+	require.NoError(t, err)
+
+	path := filepath.Join(t.TempDir(), "endpoints.yaml")
+	require.NoError(t, os.WriteFile(path, []byte("endpoints:\n  /hostname:\n    filter: .metadata.instance.hostname\n"), 0o600))
+
+	client := mock.HardwareClient{Model: datamodel.TinkServer, Data: mock.TinkerbellKantEC2}
+	endpoints, err := NewCustomEndpoints(logger, client, datamodel.TinkServer, path)
+	require.NoError(t, err)
+	defer endpoints.Close()
+
+	get := func(url string) *httptest.ResponseRecorder {
+		req, err := http.NewRequest("GET", url, nil)
+		require.NoError(t, err)
+		req.RemoteAddr = mock.UserIP
+		resp := httptest.NewRecorder()
+		endpoints.ServeHTTP(resp, req)
+		return resp
+	}
+
+	resp := get("/hostname")
+	require.Equal(t, http.StatusOK, resp.Code)
+	require.Equal(t, "tink-provisioner", resp.Body.String())
+	require.Equal(t, http.StatusNotFound, get("/plan").Code)
+
+	require.NoError(t, os.WriteFile(path, []byte("endpoints:\n  /plan:\n    filter: .metadata.instance.plan\n"), 0o600))
+	require.Eventually(t, func() bool { return get("/plan").Code == http.StatusOK }, 10*time.Second, 50*time.Millisecond)
+	require.Equal(t, "c3.small.x86", get("/plan").Body.String())
+	require.Equal(t, http.StatusNotFound, get("/hostname").Code)
+
+	// Invalid configs are ignored.
+	require.NoError(t, os.WriteFile(path, []byte("endpoints:\n  /plan:\n    filter: .[\n"), 0o600))
+	time.Sleep(5 * customEndpointsReloadDelay)
+	require.Equal(t, "c3.small.x86", get("/plan").Body.String())
+}
+
+// test cases for TestCustomEndpoint.
+var customEndpointTests = map[string]struct {
+	endpoint    CustomEndpoint
+	response    string
+	contentType string
+}{
+	"raw": {
+		endpoint: CustomEndpoint{Filter: ".metadata.instance.hostname"},
+		response: "tink-provisioner",
+	},
+	"raw multiple": {
+		endpoint: CustomEndpoint{Filter: ".metadata.instance.hostname, .metadata.instance.plan", Format: OutputFormatRaw},
+		response: "tink-provisioner\nc3.small.x86",
+	},
+	"json": {
+		endpoint:    CustomEndpoint{Filter: ".metadata.instance | {hostname, plan}", Format: OutputFormatJSON},
+		response:    `{"hostname":"tink-provisioner","plan":"c3.small.x86"}`,
+		contentType: "application/json",
+	},
+	"json multiple": {
+		endpoint:    CustomEndpoint{Filter: ".metadata.instance.hostname, .metadata.instance.plan", Format: OutputFormatJSON},
+		response:    `["tink-provisioner","c3.small.x86"]`,
+		contentType: "application/json",
+	},
+	"yaml": {
+		endpoint:    CustomEndpoint{Filter: ".metadata.instance | {hostname, plan}", Format: OutputFormatYAML},
+		response:    "hostname: tink-provisioner\nplan: c3.small.x86\n",
+		contentType: "application/yaml",
+	},
+	"content type": {
+		endpoint:    CustomEndpoint{Filter: ".metadata.instance.hostname", ContentType: "text/plain"},
+		response:    "tink-provisioner",
+		contentType: "text/plain",
+	},
+	"model filter": {
+		endpoint: CustomEndpoint{Filter: ".metadata.instance.plan", ModelFilters: map[string]string{"1": ".metadata.instance.hostname"}},
+		response: "tink-provisioner",
+	},
+	"other model filter": {
+		endpoint: CustomEndpoint{Filter: ".metadata.instance.plan", ModelFilters: map[string]string{"kubernetes": ".metadata.instance.hostname"}},
+		response: "c3.small.x86",
+	},
+	"template": {
+		endpoint: CustomEndpoint{Filter: "hostname: {{ .metadata.instance.hostname }}", Engine: FilterEngineTemplate},
+		response: "hostname: tink-provisioner",
+	},
+	"template with marker": {
+		endpoint: CustomEndpoint{Filter: templateMarker + "\nhostname: {{ .metadata.instance.hostname }}", Engine: FilterEngineTemplate},
+		response: "hostname: tink-provisioner",
+	},
+}
@@ -0,0 +1,86 @@
+package http
+
+import (
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// ec2MacsPath is the EC2 metadata directory with an entry per network interface named by the interface's MAC.
+const ec2MacsPath = "/meta-data/network/interfaces/macs"
+
+// ec2MacItems renders the metadata items of the interface with index device under ec2MacsPath/<mac>.
+var ec2MacItems = map[string]func(hw *exportedHardware, device int, iface exportedInterface) []string{
+	"device-number": func(_ *exportedHardware, device int, _ exportedInterface) []string {
+		return []string{strconv.Itoa(device)}
+	},
+	"ipv6s": func(hw *exportedHardware, device int, iface exportedInterface) []string {
+		return hw.interfaceAddresses(device, iface, 6)
+	},
+	"local-ipv4s": func(hw *exportedHardware, device int, iface exportedInterface) []string {
+		return hw.interfaceAddresses(device, iface, 4)
+	},
+	"mac": func(_ *exportedHardware, _ int, iface exportedInterface) []string {
+		return []string{iface.MAC}
+	},
+	"subnet-ipv4-cidr-block": func(_ *exportedHardware, _ int, iface exportedInterface) []string {
+		if cidr := ipv4CIDR(iface.Address, iface.Netmask); cidr != "" {
+			return []string{cidr}
+		}
+		return nil
+	},
+}
+
+// ec2MacsQuery returns the part of an EC2 query below ec2MacsPath and true if url is a query below it.
+func ec2MacsQuery(url string) (string, bool) {
+	query := strings.TrimRight(strings.TrimPrefix(url, "/2009-04-04"), "/") // remove base pattern and trailing slash
+	if query != ec2MacsPath && !strings.HasPrefix(query, ec2MacsPath+"/") {
+		return "", false
+	}
+	return strings.TrimPrefix(strings.TrimPrefix(query, ec2MacsPath), "/"), true
+}
+
+// renderEC2Macs renders query, relative to ec2MacsPath, from the hardware's interfaces. As with other metadata items
+// missing from the hardware, items of MACs the hardware doesn't have are empty.
+func renderEC2Macs(hw *exportedHardware, query string) ([]byte, error) {
+	interfaces := hw.interfaces()
+
+	if query == "" {
+		macs := make([]string, 0, len(interfaces))
+		for _, iface := range interfaces {
+			macs = append(macs, iface.MAC)
+		}
+		return []byte(strings.Join(macs, "\n")), nil
+	}
+
+	segments := strings.SplitN(query, "/", 2)
+	mac, err := net.ParseMAC(segments[0])
+	if err != nil {
+		return nil, errors.Errorf("invalid metadata item: %v", query)
+	}
+
+	if len(segments) == 1 {
+		items := make([]string, 0, len(ec2MacItems))
+		for item := range ec2MacItems {
+			items = append(items, item)
+		}
+		sort.Strings(items)
+		return []byte(strings.Join(items, "\n")), nil
+	}
+
+	item, ok := ec2MacItems[segments[1]]
+	if !ok {
+		return nil, errors.Errorf("invalid metadata item: %v", query)
+	}
+
+	for device, iface := range interfaces {
+		if iface.MAC == mac.String() {
+			return []byte(strings.Join(item(hw, device, iface), "\n")), nil
+		}
+	}
+
+	return []byte{}, nil
+}
@@ -0,0 +1,191 @@
+package http
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/packethost/pkg/log"
+	"github.com/pkg/errors"
+)
+
+// EC2TokenMode defines whether metadata requests must carry an EC2 IMDSv2 session token.
+type EC2TokenMode string
+
+const (
+	// EC2TokenOptional serves requests without a token but rejects requests carrying an invalid token.
+	EC2TokenOptional EC2TokenMode = "optional"
+
+	// EC2TokenRequired rejects requests without a valid token. It applies to every metadata format, not only EC2's, so
+	// clients of the other formats must request a token as well.
+	EC2TokenRequired EC2TokenMode = "required"
+)
+
+const (
+	ec2TokenHeader    = "X-aws-ec2-metadata-token"
+	ec2TokenTTLHeader = "X-aws-ec2-metadata-token-ttl-seconds"
+
+	// ec2TokenMaxTTL is the maximum token lifetime a client can request, matching EC2.
+	ec2TokenMaxTTL = 6 * time.Hour
+
+	// ec2MaxTokensPerIP bounds the live tokens of a single IP so a client requesting tokens in a loop can't grow the
+	// store without bound. Issuing a token beyond the limit evicts the IP's token that expires first.
+	ec2MaxTokensPerIP = 32
+)
+
+// ec2TokenExemptPaths are served without a token in EC2TokenRequired mode: the token endpoint itself and Hegel's own
+// monitoring endpoints, which don't expose hardware data.
+var ec2TokenExemptPaths = map[string]bool{
+	"/latest/api/token":    true,
+	"/metrics":             true,
+	"/_packet/healthcheck": true,
+	"/_packet/version":     true,
+}
+
+// ValidateEC2TokenMode returns an error if mode isn't a known EC2TokenMode.
+func ValidateEC2TokenMode(mode EC2TokenMode) error {
+	switch mode {
+	case EC2TokenOptional, EC2TokenRequired:
+		return nil
+	default:
+		return errors.Errorf("unknown ec2 token mode: %v", mode)
+	}
+}
+
+// EC2TokenStore issues and validates EC2 IMDSv2 session tokens. Tokens are bound to the IP they were issued to so a
+// token leaked from one machine can't be used to retrieve metadata from another.
+type EC2TokenStore struct {
+	mode EC2TokenMode
+	now  func() time.Time
+
+	mu     sync.Mutex
+	tokens map[string]ec2Token
+}
+
+type ec2Token struct {
+	ip      string
+	expires time.Time
+}
+
+// NewEC2TokenStore creates an EC2TokenStore that enforces tokens according to mode.
+func NewEC2TokenStore(mode EC2TokenMode) *EC2TokenStore {
+	return &EC2TokenStore{
+		mode:   mode,
+		now:    time.Now,
+		tokens: make(map[string]ec2Token),
+	}
+}
+
+// TokenHandler serves PUT /latest/api/token issuing a token for the requesting IP that lives for the number of seconds
+// specified by the X-aws-ec2-metadata-token-ttl-seconds header.
+func (s *EC2TokenStore) TokenHandler(logger log.Logger) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPut {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+
+		userIP := getIPFromRequest(r)
+		if userIP == "" {
+			logger.Info("Could not retrieve IP address")
+			return
+		}
+
+		secs, err := strconv.Atoi(r.Header.Get(ec2TokenTTLHeader))
+		if err != nil || secs < 1 || time.Duration(secs)*time.Second > ec2TokenMaxTTL {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+
+		token, err := s.issue(userIP, time.Duration(secs)*time.Second)
+		if err != nil {
+			logger.With("userIP", userIP).Error(err, "failed to issue ec2 token")
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set(ec2TokenTTLHeader, strconv.Itoa(secs))
+		w.Header().Set("Content-Type", "text/plain")
+		if _, err := w.Write([]byte(token)); err != nil {
+			logger.With("error", err).Info("failed to write response")
+		}
+	})
+}
+
+// RequireToken wraps next rejecting requests with an invalid X-aws-ec2-metadata-token. Requests without a token are
+// only rejected when the store's mode is EC2TokenRequired. It's meant to wrap every metadata tree, not only EC2's, so
+// required mode can't be bypassed by requesting the same data in another format; ec2TokenExemptPaths are let through.
+func (s *EC2TokenStore) RequireToken(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if ec2TokenExemptPaths[r.URL.Path] {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		// Admin requests are already authenticated and have no way of getting a token bound to the machine's IP.
+		if _, ok := impersonatedHardware(r.Context()); ok {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		token := r.Header.Get(ec2TokenHeader)
+		if token == "" && s.mode != EC2TokenRequired {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		if !s.valid(token, getIPFromRequest(r)) {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// issue creates a token bound to ip that expires after ttl.
+func (s *EC2TokenStore) issue(ip string, ttl time.Duration) (string, error) {
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	token := base64.RawURLEncoding.EncodeToString(buf)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := s.now()
+
+	// Drop expired tokens so the store doesn't grow with clients that never reuse their tokens.
+	var live int
+	var oldest string
+	for t, entry := range s.tokens {
+		switch {
+		case !now.Before(entry.expires):
+			delete(s.tokens, t)
+		case entry.ip == ip:
+			live++
+			if oldest == "" || entry.expires.Before(s.tokens[oldest].expires) {
+				oldest = t
+			}
+		}
+	}
+	if live >= ec2MaxTokensPerIP {
+		delete(s.tokens, oldest)
+	}
+
+	s.tokens[token] = ec2Token{ip: ip, expires: now.Add(ttl)}
+	return token, nil
+}
+
+// valid returns true if token was issued to ip and hasn't expired.
+func (s *EC2TokenStore) valid(token, ip string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	entry, ok := s.tokens[token]
+	return ok && entry.ip == ip && s.now().Before(entry.expires)
+}
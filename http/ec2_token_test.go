@@ -0,0 +1,176 @@
+package http
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/packethost/pkg/log"
+	"github.com/stretchr/testify/require"
+	"github.com/tinkerbell/hegel/datamodel"
+	"github.com/tinkerbell/hegel/hardware/mock"
+)
+
+func TestEC2Token(t *testing.T) {
+	logger, err := log.Init(t.Name())
+	require.NoError(t, err)
+
+	tests := map[string]struct {
+		mode    EC2TokenMode
+		token   func(issued string) string
+		peer    string
+		elapsed time.Duration
+		status  int
+	}{
+		"optional without token": {
+			mode:   EC2TokenOptional,
+			token:  func(string) string { return "" },
+			status: 200,
+		},
+		"optional with token": {
+			mode:   EC2TokenOptional,
+			token:  func(issued string) string { return issued },
+			status: 200,
+		},
+		"optional with invalid token": {
+			mode:   EC2TokenOptional,
+			token:  func(string) string { return "invalid" },
+			status: 401,
+		},
+		"required without token": {
+			mode:   EC2TokenRequired,
+			token:  func(string) string { return "" },
+			status: 401,
+		},
+		"required with token": {
+			mode:   EC2TokenRequired,
+			token:  func(issued string) string { return issued },
+			status: 200,
+		},
+		"required with token from another ip": {
+			mode:   EC2TokenRequired,
+			token:  func(issued string) string { return issued },
+			peer:   "192.168.1.6",
+			status: 401,
+		},
+		"required with expired token": {
+			mode:    EC2TokenRequired,
+			token:   func(issued string) string { return issued },
+			elapsed: time.Minute,
+			status:  401,
+		},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			now := time.Now()
+			store := NewEC2TokenStore(test.mode)
+			store.now = func() time.Time { return now }
+
+			req, err := http.NewRequest(http.MethodPut, "/latest/api/token", nil)
+			require.NoError(t, err)
+			req.RemoteAddr = mock.UserIP
+			req.Header.Set(ec2TokenTTLHeader, "60")
+			resp := httptest.NewRecorder()
+
+			store.TokenHandler(logger).ServeHTTP(resp, req)
+			require.Equal(t, 200, resp.Code)
+			require.Equal(t, "60", resp.Header().Get(ec2TokenTTLHeader))
+			issued := resp.Body.String()
+
+			now = now.Add(test.elapsed)
+
+			client := mock.HardwareClient{Model: datamodel.TinkServer, Data: mock.TinkerbellKantEC2}
+			handler := store.RequireToken(EC2MetadataHandler(logger, client, nil))
+
+			req, err = http.NewRequest(http.MethodGet, "/2009-04-04/meta-data/instance-id", nil)
+			require.NoError(t, err)
+			req.RemoteAddr = mock.UserIP
+			if test.peer != "" {
+				req.RemoteAddr = test.peer
+			}
+			if token := test.token(issued); token != "" {
+				req.Header.Set(ec2TokenHeader, token)
+			}
+			resp = httptest.NewRecorder()
+
+			handler.ServeHTTP(resp, req)
+			require.Equal(t, test.status, resp.Code)
+		})
+	}
+}
+
+func TestEC2TokenTTL(t *testing.T) {
+	logger, err := log.Init(t.Name())
+	require.NoError(t, err)
+
+	store := NewEC2TokenStore(EC2TokenRequired)
+
+	for _, ttl := range []string{"", "0", "21601", "abc"} {
+		t.Run(ttl, func(t *testing.T) {
+			req, err := http.NewRequest(http.MethodPut, "/latest/api/token", nil)
+			require.NoError(t, err)
+			req.RemoteAddr = mock.UserIP
+			req.Header.Set(ec2TokenTTLHeader, ttl)
+			resp := httptest.NewRecorder()
+
+			store.TokenHandler(logger).ServeHTTP(resp, req)
+			require.Equal(t, 400, resp.Code)
+		})
+	}
+}
+
+func TestEC2TokenRequiredPaths(t *testing.T) {
+	store := NewEC2TokenStore(EC2TokenRequired)
+	handler := store.RequireToken(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+
+	tests := map[string]int{
+		"/2009-04-04/meta-data/instance-id": 401,
+		"/openstack/latest/meta_data.json":  401,
+		"/computeMetadata/v1/instance/id":   401,
+		"/metadata":                         401,
+		"/latest/api/token":                 200,
+		"/metrics":                          200,
+		"/_packet/healthcheck":              200,
+		"/_packet/version":                  200,
+	}
+
+	for path, status := range tests {
+		t.Run(path, func(t *testing.T) {
+			req, err := http.NewRequest(http.MethodGet, path, nil)
+			require.NoError(t, err)
+			req.RemoteAddr = mock.UserIP
+			resp := httptest.NewRecorder()
+
+			handler.ServeHTTP(resp, req)
+			require.Equal(t, status, resp.Code)
+		})
+	}
+}
+
+func TestEC2TokensPerIP(t *testing.T) {
+	now := time.Now()
+	store := NewEC2TokenStore(EC2TokenRequired)
+	store.now = func() time.Time { return now }
+
+	first, err := store.issue(mock.UserIP, time.Minute)
+	require.NoError(t, err)
+	for i := 1; i < ec2MaxTokensPerIP; i++ {
+		_, err := store.issue(mock.UserIP, time.Hour)
+		require.NoError(t, err)
+	}
+	other, err := store.issue("192.168.1.6", time.Minute)
+	require.NoError(t, err)
+	require.True(t, store.valid(first, mock.UserIP))
+
+	// Issuing past the limit evicts the token that expires first but leaves other IPs' tokens alone.
+	last, err := store.issue(mock.UserIP, time.Hour)
+	require.NoError(t, err)
+	require.False(t, store.valid(first, mock.UserIP))
+	require.True(t, store.valid(last, mock.UserIP))
+	require.True(t, store.valid(other, "192.168.1.6"))
+	require.Len(t, store.tokens, ec2MaxTokensPerIP+1)
+}
@@ -0,0 +1,111 @@
+package http
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/packethost/pkg/log"
+)
+
+// contentHash returns a short, stable hash identifying content.
+func contentHash(content []byte) string {
+	sum := sha256.Sum256(content)
+	return hex.EncodeToString(sum[:8])
+}
+
+// metadataETag returns the strong ETag of a metadata response.
+func metadataETag(resp []byte) string {
+	return `"` + contentHash(resp) + `"`
+}
+
+// etagMatches returns true if the If-None-Match header value ifNoneMatch matches etag. Weak validators compare equal
+// to their strong counterparts as required for If-None-Match.
+func etagMatches(ifNoneMatch, etag string) bool {
+	for _, candidate := range strings.Split(ifNoneMatch, ",") {
+		candidate = strings.TrimPrefix(strings.TrimSpace(candidate), "W/")
+		if candidate == "*" || candidate == etag {
+			return true
+		}
+	}
+	return false
+}
+
+// writeMetadata writes resp with an ETag identifying its content. If r is conditional on the client's copy being
+// stale, that is its If-None-Match matches the ETag, only http.StatusNotModified is written.
+func writeMetadata(w http.ResponseWriter, r *http.Request, resp []byte) error {
+	etag := metadataETag(resp)
+	w.Header().Set("ETag", etag)
+
+	if ifNoneMatch := r.Header.Get("If-None-Match"); ifNoneMatch != "" && etagMatches(ifNoneMatch, etag) {
+		w.WriteHeader(http.StatusNotModified)
+		return nil
+	}
+
+	w.WriteHeader(http.StatusOK)
+	_, err := w.Write(resp)
+	return err
+}
+
+// conditionalGET is gin middleware that buffers successful responses so they're written with an ETag and conditional
+// requests are honored, see writeMetadata. It mustn't be used on streaming endpoints.
+func conditionalGET(logger log.Logger) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		w := c.Writer
+		buf := &bufferedResponseWriter{ResponseWriter: w, status: http.StatusOK}
+		c.Writer = buf
+		c.Next()
+		c.Writer = w
+
+		if buf.status != http.StatusOK {
+			w.WriteHeader(buf.status)
+			if _, err := w.Write(buf.body.Bytes()); err != nil {
+				logger.With("error", err).Info("failed to write response")
+			}
+			return
+		}
+
+		if err := writeMetadata(w, c.Request, buf.body.Bytes()); err != nil {
+			logger.With("error", err).Info("failed to write response")
+		}
+	}
+}
+
+// bufferedResponseWriter is a gin.ResponseWriter that holds the status and body written to it. Headers are written to
+// the underlying writer directly.
+type bufferedResponseWriter struct {
+	gin.ResponseWriter
+	status int
+	body   bytes.Buffer
+}
+
+func (w *bufferedResponseWriter) WriteHeader(code int) {
+	if code > 0 {
+		w.status = code
+	}
+}
+
+func (w *bufferedResponseWriter) WriteHeaderNow() {}
+
+func (w *bufferedResponseWriter) Write(data []byte) (int, error) {
+	return w.body.Write(data)
+}
+
+func (w *bufferedResponseWriter) WriteString(s string) (int, error) {
+	return w.body.WriteString(s)
+}
+
+func (w *bufferedResponseWriter) Status() int {
+	return w.status
+}
+
+func (w *bufferedResponseWriter) Size() int {
+	return w.body.Len()
+}
+
+func (w *bufferedResponseWriter) Written() bool {
+	return false
+}
@@ -0,0 +1,89 @@
+package http
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/packethost/pkg/log"
+	"github.com/stretchr/testify/require"
+	"github.com/tinkerbell/hegel/datamodel"
+	"github.com/tinkerbell/hegel/hardware/mock"
+)
+
+func TestETag(t *testing.T) {
+	logger, err := log.Init(t.Name())
+	require.NoError(t, err)
+
+	client := mock.HardwareClient{Model: datamodel.TinkServer, Data: mock.TinkerbellKantEC2}
+
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	v0 := router.Group("/v0", conditionalGET(logger))
+	v0.GET("/hostname", func(c *gin.Context) { c.String(http.StatusOK, "tink-provisioner") })
+	v0.GET("/missing", func(c *gin.Context) { c.JSON(http.StatusNotFound, nil) })
+
+	customHandler, err := GetMetadataHandler(logger, client, ".metadata.instance.hostname", datamodel.TinkServer)
+	require.NoError(t, err)
+
+	tests := map[string]struct {
+		handler http.Handler
+		url     string
+	}{
+		"custom endpoint": {
+			handler: customHandler,
+			url:     "/hostname",
+		},
+		"ec2": {
+			handler: EC2MetadataHandler(logger, client, nil),
+			url:     "/2009-04-04/meta-data/hostname",
+		},
+		"v0": {
+			handler: router,
+			url:     "/v0/hostname",
+		},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			get := func(ifNoneMatch string) *httptest.ResponseRecorder {
+				req, err := http.NewRequest("GET", test.url, nil)
+				require.NoError(t, err)
+				req.RemoteAddr = mock.UserIP
+				if ifNoneMatch != "" {
+					req.Header.Set("If-None-Match", ifNoneMatch)
+				}
+				resp := httptest.NewRecorder()
+				test.handler.ServeHTTP(resp, req)
+				return resp
+			}
+
+			resp := get("")
+			require.Equal(t, http.StatusOK, resp.Code)
+			require.Equal(t, "tink-provisioner", resp.Body.String())
+			etag := resp.Header().Get("ETag")
+			require.Equal(t, metadataETag([]byte("tink-provisioner")), etag)
+
+			for _, ifNoneMatch := range []string{etag, `"stale", W/` + etag, "*"} {
+				resp = get(ifNoneMatch)
+				require.Equal(t, http.StatusNotModified, resp.Code, ifNoneMatch)
+				require.Empty(t, resp.Body.String(), ifNoneMatch)
+				require.Equal(t, etag, resp.Header().Get("ETag"), ifNoneMatch)
+			}
+
+			resp = get(`"stale"`)
+			require.Equal(t, http.StatusOK, resp.Code)
+			require.Equal(t, "tink-provisioner", resp.Body.String())
+		})
+	}
+
+	// Unsuccessful responses are written as is.
+	req, err := http.NewRequest("GET", "/v0/missing", nil)
+	require.NoError(t, err)
+	resp := httptest.NewRecorder()
+	router.ServeHTTP(resp, req)
+	require.Equal(t, http.StatusNotFound, resp.Code)
+	require.Equal(t, "null", resp.Body.String())
+	require.Empty(t, resp.Header().Get("ETag"))
+}
@@ -0,0 +1,191 @@
+package http
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/packethost/pkg/log"
+	"github.com/pkg/errors"
+	"github.com/tinkerbell/hegel/hardware"
+	"github.com/tinkerbell/hegel/metrics"
+)
+
+// eventsKeepAlive is how often a comment is sent on idle event streams so proxies and clients don't time them out.
+var eventsKeepAlive = 30 * time.Second
+
+// EventsHandler streams the requesting machine's metadata as Server-Sent Events. The current metadata is sent when
+// the stream opens followed by the metadata of every update, so agents can follow changes with an HTTP client, such
+// as curl -N, rather than a gRPC subscription. Each event's ID identifies its content; clients reconnecting with a
+// Last-Event-ID of the current metadata only receive later updates.
+func EventsHandler(logger log.Logger, client hardware.Client) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "GET" {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+
+		userIP := getIPFromRequest(r)
+		if userIP == "" {
+			logger.Info("Could not retrieve IP address")
+			return
+		}
+
+		streamEvents(logger, w, r, client, userIP)
+	})
+}
+
+func eventsHandler(logger log.Logger, client hardware.Client) gin.HandlerFunc {
+	fn := func(c *gin.Context) {
+		streamEvents(logger, c.Writer, c.Request, client, c.ClientIP())
+	}
+	return gin.HandlerFunc(fn)
+}
+
+// streamEvents writes the metadata event stream of the machine with ip to w until the request is done or the watch
+// ends.
+func streamEvents(logger log.Logger, w http.ResponseWriter, r *http.Request, client hardware.Client, ip string) {
+	metrics.MetadataRequests.Inc()
+	logger = logger.With("op", "events", "userIP", ip)
+
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		logger.Info("response writer doesn't support flushing")
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	hw, err := lookupHardware(r, client, ip)
+	if err != nil {
+		metrics.Errors.WithLabelValues("metadata", "lookup").Inc()
+		logger.With("error", err).Info("failed to get hardware by ip")
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	id, err := hw.ID()
+	if err != nil {
+		logger.With("error", err).Info("failed to get hardware id")
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	ehw, err := hw.Export()
+	if err != nil {
+		logger.With("error", err).Info("failed to export hardware")
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	ctx, cancel := context.WithCancel(r.Context())
+	defer cancel()
+
+	watcher, err := client.Watch(ctx, id)
+	if err != nil {
+		logger.With("error", err).Info("failed to watch hardware")
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	if watcher == nil {
+		logger.Info("hardware client doesn't support watching")
+		w.WriteHeader(http.StatusNotImplemented)
+		return
+	}
+
+	metrics.TotalSubscriptions.Inc()
+	metrics.Subscriptions.WithLabelValues("active").Inc()
+	defer metrics.Subscriptions.WithLabelValues("active").Dec()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+
+	last := r.Header.Get("Last-Event-ID")
+	send := func(ehw []byte) error {
+		eventID := contentHash(ehw)
+		if eventID == last {
+			return nil
+		}
+		last = eventID
+
+		if err := writeEvent(w, eventID, "metadata", ehw); err != nil {
+			return err
+		}
+		flusher.Flush()
+		return nil
+	}
+
+	if err := send(ehw); err != nil {
+		logger.With("error", err).Info("failed to write event")
+		return
+	}
+
+	updates := make(chan []byte)
+	errs := make(chan error, 1)
+	go func() {
+		for {
+			hw, err := watcher.Recv()
+			if err != nil {
+				errs <- errors.Wrap(err, "receive hardware update")
+				return
+			}
+
+			ehw, err := hw.Export()
+			if err != nil {
+				errs <- errors.Wrap(err, "export hardware")
+				return
+			}
+
+			select {
+			case updates <- ehw:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	keepAlive := time.NewTicker(eventsKeepAlive)
+	defer keepAlive.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case err := <-errs:
+			if ctx.Err() == nil && !errors.Is(err, io.EOF) {
+				metrics.Errors.WithLabelValues("subscribe", "active").Inc()
+				logger.With("error", err).Info("event stream ended")
+			}
+			return
+		case ehw := <-updates:
+			if err := send(ehw); err != nil {
+				logger.With("error", err).Info("failed to write event")
+				return
+			}
+		case <-keepAlive.C:
+			if _, err := io.WriteString(w, ": keep-alive\n\n"); err != nil {
+				logger.With("error", err).Info("failed to write keep-alive")
+				return
+			}
+			flusher.Flush()
+		}
+	}
+}
+
+// writeEvent writes a Server-Sent Event. Every line of data is sent as a data field so clients rejoin them with
+// newlines.
+func writeEvent(w io.Writer, id, event string, data []byte) error {
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "id: %v\nevent: %v\n", id, event)
+	for _, line := range bytes.Split(data, []byte("\n")) {
+		fmt.Fprintf(&buf, "data: %s\n", line)
+	}
+	buf.WriteByte('\n')
+
+	_, err := w.Write(buf.Bytes())
+	return err
+}
@@ -0,0 +1,76 @@
+package http
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/packethost/pkg/log"
+	"github.com/stretchr/testify/require"
+	"github.com/tinkerbell/hegel/datamodel"
+	"github.com/tinkerbell/hegel/hardware"
+	"github.com/tinkerbell/hegel/hardware/mock"
+)
+
+// flushRecorder is a httptest.ResponseRecorder that signals flushes.
+type flushRecorder struct {
+	*httptest.ResponseRecorder
+	flushed chan struct{}
+}
+
+func (r flushRecorder) Flush() {
+	r.ResponseRecorder.Flush()
+	r.flushed <- struct{}{}
+}
+
+func TestEventsEndpoint(t *testing.T) {
+	logger, err := log.Init(t.Name())
+	require.NoError(t, err)
+
+	client := watchingClient{
+		HardwareClient: mock.HardwareClient{Model: datamodel.TinkServer, Data: mock.TinkerbellKantEC2},
+		updates:        make(chan hardware.Hardware, 2),
+	}
+	handler := EventsHandler(logger, client)
+
+	current := &hardware.Tinkerbell{}
+	require.NoError(t, json.Unmarshal([]byte(mock.TinkerbellKantEC2), current))
+	ecurrent, err := current.Export()
+	require.NoError(t, err)
+
+	renamed := &hardware.Tinkerbell{}
+	require.NoError(t, json.Unmarshal([]byte(strings.ReplaceAll(mock.TinkerbellKantEC2, "tink-provisioner", "renamed")), renamed))
+	erenamed, err := renamed.Export()
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, "GET", "/events", nil)
+	require.NoError(t, err)
+	req.RemoteAddr = mock.UserIP
+	resp := flushRecorder{ResponseRecorder: httptest.NewRecorder(), flushed: make(chan struct{})}
+
+	done := make(chan struct{})
+	go func() {
+		handler.ServeHTTP(resp, req)
+		close(done)
+	}()
+
+	// The current metadata is sent first, updates that don't change it are skipped.
+	<-resp.flushed
+	client.updates <- current
+	client.updates <- renamed
+	<-resp.flushed
+	cancel()
+	<-done
+
+	require.Equal(t, http.StatusOK, resp.Code)
+	require.Equal(t, "text/event-stream", resp.Header().Get("Content-Type"))
+	require.Equal(t, fmt.Sprintf("id: %v\nevent: metadata\ndata: %s\n\nid: %v\nevent: metadata\ndata: %s\n\n",
+		contentHash(ecurrent), ecurrent, contentHash(erenamed), erenamed), resp.Body.String())
+}
@@ -0,0 +1,285 @@
+package http
+
+import (
+	"encoding/json"
+	"math/bits"
+	"net"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// exportedHardware is the part of the hardware exported by the different data models that Hegel reads to render the
+// metadata formats it serves. Fields that only exist in some data models are left empty for the others.
+type exportedHardware struct {
+	ID           string                `json:"id"`
+	FacilityCode string                `json:"facility_code"`
+	Hostname     string                `json:"hostname"`
+	PlanSlug     string                `json:"plan_slug"`
+	BondingMode  *int                  `json:"bonding_mode"`
+	Metadata     exportedMetadata      `json:"metadata"`
+	Network      exportedNetwork       `json:"network"`
+	NetworkPorts []exportedNetworkPort `json:"network_ports"`
+
+	// Instance is the instance of the Cacher data model, the other data models keep it in Metadata.
+	Instance struct {
+		Userdata    string            `json:"userdata"`
+		SSHKeys     []string          `json:"ssh_keys"`
+		IPAddresses []exportedAddress `json:"ip_addresses"`
+	} `json:"instance"`
+}
+
+type exportedMetadata struct {
+	Userdata    string           `json:"userdata"`
+	Gateway     string           `json:"gateway"`
+	BondingMode *int             `json:"bonding_mode"`
+	Instance    exportedInstance `json:"instance"`
+
+	// Facility is only an object with a facility_code and plan_slug in some data models so it's decoded when it's
+	// read.
+	Facility json.RawMessage `json:"facility"`
+
+	Interfaces []struct {
+		MAC     string `json:"mac"`
+		Address string `json:"address"`
+		Netmask string `json:"netmask"`
+	} `json:"interfaces"`
+}
+
+type exportedInstance struct {
+	ID        string   `json:"id"`
+	Hostname  string   `json:"hostname"`
+	Plan      string   `json:"plan"`
+	Facility  string   `json:"facility"`
+	Factility string   `json:"factility"`
+	Tags      []string `json:"tags"`
+	SSHKeys   []string `json:"ssh_keys"`
+
+	Network struct {
+		Addresses []exportedAddress `json:"addresses"`
+		Bonding   struct {
+			Mode *int `json:"mode"`
+		} `json:"bonding"`
+	} `json:"network"`
+}
+
+// exportedAddress is an IP address assigned to the instance.
+type exportedAddress struct {
+	Address       string `json:"address"`
+	AddressFamily int    `json:"address_family"`
+	Netmask       string `json:"netmask"`
+	Gateway       string `json:"gateway"`
+	CIDR          *int   `json:"cidr"`
+	Public        bool   `json:"public"`
+	Enabled       *bool  `json:"enabled"`
+}
+
+type exportedNetwork struct {
+	Interfaces []struct {
+		DHCP *struct {
+			MAC string `json:"mac"`
+			IP  struct {
+				Address string `json:"address"`
+				Netmask string `json:"netmask"`
+				Gateway string `json:"gateway"`
+			} `json:"ip"`
+		} `json:"dhcp"`
+	} `json:"interfaces"`
+}
+
+type exportedNetworkPort struct {
+	Type string `json:"type"`
+	Data struct {
+		MAC string `json:"mac"`
+	} `json:"data"`
+}
+
+// exportedInterface is a network interface of the hardware.
+type exportedInterface struct {
+	MAC     string
+	Address string
+	Netmask string
+	Gateway string
+}
+
+// parseExportedHardware decodes hardware exported by any of the data models.
+func parseExportedHardware(ehw []byte) (*exportedHardware, error) {
+	hw := &exportedHardware{}
+	if err := json.Unmarshal(ehw, hw); err != nil {
+		return nil, errors.Wrap(err, "unmarshal exported hardware")
+	}
+	return hw, nil
+}
+
+// userdata returns the userdata of the hardware.
+func (hw *exportedHardware) userdata() string {
+	if hw.Metadata.Userdata != "" {
+		return hw.Metadata.Userdata
+	}
+	return hw.Instance.Userdata
+}
+
+// hostname returns the hostname of the instance.
+func (hw *exportedHardware) hostname() string {
+	if hw.Metadata.Instance.Hostname != "" {
+		return hw.Metadata.Instance.Hostname
+	}
+	return hw.Hostname
+}
+
+// sshKeys returns the SSH keys authorized on the instance.
+func (hw *exportedHardware) sshKeys() []string {
+	return append(append([]string(nil), hw.Metadata.Instance.SSHKeys...), hw.Instance.SSHKeys...)
+}
+
+// facility returns the facility the hardware lives in.
+func (hw *exportedHardware) facility() string {
+	for _, facility := range []string{hw.Metadata.Instance.Facility, hw.Metadata.Instance.Factility} {
+		if facility != "" {
+			return facility
+		}
+	}
+
+	if facility := hw.metadataFacility(); facility.FacilityCode != "" {
+		return facility.FacilityCode
+	}
+
+	return hw.FacilityCode
+}
+
+// plan returns the slug of the hardware's plan.
+func (hw *exportedHardware) plan() string {
+	if hw.Metadata.Instance.Plan != "" {
+		return hw.Metadata.Instance.Plan
+	}
+	if facility := hw.metadataFacility(); facility.PlanSlug != "" {
+		return facility.PlanSlug
+	}
+	return hw.PlanSlug
+}
+
+type exportedFacility struct {
+	FacilityCode string `json:"facility_code"`
+	PlanSlug     string `json:"plan_slug"`
+}
+
+// metadataFacility decodes the metadata facility, it's empty if the metadata facility isn't an object.
+func (hw *exportedHardware) metadataFacility() exportedFacility {
+	var facility exportedFacility
+	if err := json.Unmarshal(hw.Metadata.Facility, &facility); err != nil {
+		return exportedFacility{}
+	}
+	return facility
+}
+
+// interfaces returns the network interfaces of the hardware. MACs are lower-cased and interfaces without a MAC are
+// dropped.
+func (hw *exportedHardware) interfaces() []exportedInterface {
+	var interfaces []exportedInterface
+	add := func(iface exportedInterface) {
+		if iface.MAC == "" {
+			return
+		}
+		iface.MAC = strings.ToLower(iface.MAC)
+		interfaces = append(interfaces, iface)
+	}
+
+	for _, iface := range hw.Metadata.Interfaces {
+		add(exportedInterface{MAC: iface.MAC, Address: iface.Address, Netmask: iface.Netmask, Gateway: hw.Metadata.Gateway})
+	}
+	for _, iface := range hw.Network.Interfaces {
+		if iface.DHCP != nil {
+			add(exportedInterface{MAC: iface.DHCP.MAC, Address: iface.DHCP.IP.Address, Netmask: iface.DHCP.IP.Netmask, Gateway: iface.DHCP.IP.Gateway})
+		}
+	}
+	for _, port := range hw.NetworkPorts {
+		if port.Type == "data" {
+			add(exportedInterface{MAC: port.Data.MAC})
+		}
+	}
+
+	return interfaces
+}
+
+// bondingMode returns the bonding mode of the hardware's interfaces, 0 if they aren't bonded.
+func (hw *exportedHardware) bondingMode() int {
+	for _, mode := range []*int{hw.Metadata.BondingMode, hw.Metadata.Instance.Network.Bonding.Mode, hw.BondingMode} {
+		if mode != nil {
+			return *mode
+		}
+	}
+	return 0
+}
+
+// interfaceAddresses returns the addresses of iface, the interface with index device, for an address family, 4 or 6.
+// As the instance addresses aren't associated with an interface they're attributed to the first interface; only
+// private IPv4 addresses are included.
+func (hw *exportedHardware) interfaceAddresses(device int, iface exportedInterface, family int) []string {
+	var addresses []string
+	add := func(address string) {
+		for _, a := range addresses {
+			if a == address {
+				return
+			}
+		}
+		addresses = append(addresses, address)
+	}
+
+	if iface.Address != "" && strings.Contains(iface.Address, ":") == (family == 6) {
+		add(iface.Address)
+	}
+
+	if device == 0 {
+		for _, address := range hw.Metadata.Instance.Network.Addresses {
+			if address.AddressFamily == family && (family == 6 || !address.Public) {
+				add(address.Address)
+			}
+		}
+	}
+
+	return addresses
+}
+
+// ipv4CIDR returns the CIDR block of an IPv4 address and netmask, or an empty string if either isn't valid IPv4.
+func ipv4CIDR(address, netmask string) string {
+	ip := net.ParseIP(address).To4()
+	mask := net.ParseIP(netmask).To4()
+	if ip == nil || mask == nil {
+		return ""
+	}
+
+	block := net.IPNet{IP: ip.Mask(net.IPMask(mask)), Mask: net.IPMask(mask)}
+	return block.String()
+}
+
+// prefixLength converts an IPv4 or IPv6 netmask to a prefix length by counting its set bits.
+func prefixLength(netmask string) (int, bool) {
+	mask := net.ParseIP(netmask)
+	if mask == nil {
+		return 0, false
+	}
+	if v4 := mask.To4(); v4 != nil && !strings.Contains(netmask, ":") {
+		mask = v4
+	}
+
+	var length int
+	for _, b := range mask {
+		length += bits.OnesCount8(b)
+	}
+	return length, true
+}
+
+// addressCIDR returns address in CIDR notation, address/prefix-length. Without a valid netmask IPv4 addresses are
+// treated as a /32 and IPv6 addresses as a /64.
+func addressCIDR(address, netmask string) string {
+	length, ok := prefixLength(netmask)
+	switch {
+	case ok:
+	case strings.Contains(address, ":"):
+		length = 64
+	default:
+		length = 32
+	}
+	return address + "/" + strconv.Itoa(length)
+}
@@ -0,0 +1,101 @@
+package http
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+
+	"github.com/itchyny/gojq"
+	"github.com/pkg/errors"
+)
+
+// filterVariables are the variables available to all filters. Their values are bound when a filter is run, see
+// filterArgs.
+var filterVariables = []string{"$vendor_data"}
+
+// filterArgs are the values bound to filterVariables when a filter is run.
+type filterArgs struct {
+	// VendorData is bound to $vendor_data. It's the vendor data selected for the hardware.
+	VendorData string
+}
+
+func (a filterArgs) values() []interface{} {
+	return []interface{}{a.VendorData}
+}
+
+// compileFilter compiles the jq filter so it can be run repeatedly without being parsed again.
+func compileFilter(filter string) (*gojq.Code, error) {
+	query, err := gojq.Parse(filter)
+	if err != nil {
+		return nil, err
+	}
+	return gojq.Compile(query, gojq.WithVariables(filterVariables))
+}
+
+// mustCompileFilters compiles a map of filters built into Hegel. It panics if a filter doesn't compile so broken
+// filters are caught when the package is initialized.
+func mustCompileFilters(filters map[string]string) map[string]*gojq.Code {
+	codes := make(map[string]*gojq.Code, len(filters))
+	for key, filter := range filters {
+		code, err := compileFilter(filter)
+		if err != nil {
+			panic(fmt.Sprintf("compile filter %q: %v", filter, err))
+		}
+		codes[key] = code
+	}
+	return codes
+}
+
+// filterMetadata runs the compiled filter against hw. String results are written as is and other results as JSON,
+// one result per line.
+func filterMetadata(hw []byte, code *gojq.Code, args filterArgs) ([]byte, error) {
+	values, err := evalFilter(hw, code, args)
+	if err != nil {
+		return nil, err
+	}
+
+	var result bytes.Buffer
+	for _, v := range values {
+		switch vv := v.(type) {
+		case string:
+			result.WriteString(vv)
+		default:
+			marshalled, err := json.Marshal(vv)
+			if err != nil {
+				return nil, errors.Wrap(err, "error marshalling jq result")
+			}
+			result.Write(marshalled)
+		}
+		result.WriteRune('\n')
+	}
+
+	return bytes.TrimSuffix(result.Bytes(), []byte("\n")), nil
+}
+
+// evalFilter runs the compiled filter against hw and returns its non-null results.
+func evalFilter(hw []byte, code *gojq.Code, args filterArgs) ([]interface{}, error) {
+	input := make(map[string]interface{})
+	if err := json.Unmarshal(hw, &input); err != nil {
+		return nil, err
+	}
+
+	var values []interface{}
+	iter := code.Run(input, args.values()...)
+	for {
+		v, ok := iter.Next()
+		if !ok {
+			break
+		}
+
+		if v == nil {
+			continue
+		}
+
+		if err, ok := v.(error); ok {
+			return nil, errors.Wrap(err, "error while filtering with gojq")
+		}
+		values = append(values, v)
+	}
+
+	return values, nil
+}
@@ -0,0 +1,348 @@
+package http
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/packethost/pkg/log"
+	"github.com/pkg/errors"
+	"github.com/tinkerbell/hegel/hardware"
+	"github.com/tinkerbell/hegel/metrics"
+)
+
+const (
+	// gceMetadataFlavor is the value of the Metadata-Flavor header clients must send and that is sent on responses.
+	gceMetadataFlavor = "Google"
+
+	// gceMaxWaitTimeout bounds how long a wait_for_change request can block.
+	gceMaxWaitTimeout = 5 * time.Minute
+)
+
+// errGCENotFound indicates a GCE metadata path doesn't exist for the hardware.
+var errGCENotFound = errors.New("metadata item not found")
+
+// gceMetadata is the GCE metadata tree served under /computeMetadata/v1. Keys use the hyphenated names of
+// non-recursive queries; recursive queries convert them to camel case.
+type gceMetadata struct {
+	Instance gceInstance `json:"instance"`
+}
+
+type gceInstance struct {
+	ID                string                `json:"id,omitempty"`
+	Hostname          string                `json:"hostname,omitempty"`
+	Name              string                `json:"name,omitempty"`
+	Zone              string                `json:"zone,omitempty"`
+	MachineType       string                `json:"machine-type,omitempty"`
+	Tags              []string              `json:"tags"`
+	Attributes        map[string]string     `json:"attributes"`
+	NetworkInterfaces []gceNetworkInterface `json:"network-interfaces"`
+}
+
+type gceNetworkInterface struct {
+	MAC        string `json:"mac"`
+	IP         string `json:"ip,omitempty"`
+	Subnetmask string `json:"subnetmask,omitempty"`
+	Gateway    string `json:"gateway,omitempty"`
+}
+
+// newGCEMetadata builds the GCE metadata tree of hw.
+func newGCEMetadata(hw *exportedHardware) gceMetadata {
+	instance := gceInstance{
+		ID:                hw.Metadata.Instance.ID,
+		Hostname:          hw.Metadata.Instance.Hostname,
+		Name:              hw.Metadata.Instance.Hostname,
+		Zone:              hw.facility(),
+		MachineType:       hw.Metadata.Instance.Plan,
+		Tags:              append([]string{}, hw.Metadata.Instance.Tags...),
+		Attributes:        map[string]string{},
+		NetworkInterfaces: []gceNetworkInterface{},
+	}
+
+	if hw.Metadata.Userdata != "" {
+		instance.Attributes["user-data"] = hw.Metadata.Userdata
+	}
+	if keys := strings.Join(hw.Metadata.Instance.SSHKeys, "\n"); keys != "" {
+		instance.Attributes["ssh-keys"] = keys
+	}
+
+	for _, iface := range hw.interfaces() {
+		instance.NetworkInterfaces = append(instance.NetworkInterfaces, gceNetworkInterface{
+			MAC:        iface.MAC,
+			IP:         iface.Address,
+			Subnetmask: iface.Netmask,
+			Gateway:    iface.Gateway,
+		})
+	}
+
+	return gceMetadata{Instance: instance}
+}
+
+// GCEMetadataHandler serves the GCE metadata server format under /computeMetadata/v1. Requests must carry the
+// Metadata-Flavor: Google header; clients tricked into making requests on behalf of others, for example through
+// server side request forgery, rarely control request headers. Directories support ?recursive=true to retrieve the
+// whole subtree as JSON and all paths support ?wait_for_change=true, optionally with last_etag and timeout_sec, to
+// block until the value changes.
+func GCEMetadataHandler(logger log.Logger, client hardware.Client) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "GET" {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+
+		if r.Header.Get("Metadata-Flavor") != gceMetadataFlavor {
+			w.WriteHeader(http.StatusForbidden)
+			if _, err := w.Write([]byte("Missing Metadata-Flavor:Google header.")); err != nil {
+				logger.With("error", err).Info("failed to write response")
+			}
+			return
+		}
+
+		userIP := getIPFromRequest(r)
+		if userIP == "" {
+			logger.Info("Could not retrieve IP address")
+			return
+		}
+
+		metrics.MetadataRequests.Inc()
+		logger := logger.With("userIP", userIP)
+
+		hw, err := lookupHardware(r, client, userIP)
+		if err != nil {
+			metrics.Errors.WithLabelValues("metadata", "lookup").Inc()
+			logger.With("error", err).Info("failed to get hardware by ip")
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+
+		query := r.URL.Query()
+		path := strings.TrimPrefix(r.URL.Path, "/computeMetadata/v1")
+		recursive := query.Get("recursive") == "true"
+
+		resp, err := renderGCEMetadata(logger, hw, path, recursive)
+		if err != nil {
+			logger.With("error", err).Info("failed to render metadata")
+			writeGCEError(w, err)
+			return
+		}
+		etag := gceETag(resp)
+
+		if query.Get("wait_for_change") == "true" {
+			// Without a last_etag the request waits for the next change, otherwise it only waits if the client already
+			// has the current value.
+			if last := query.Get("last_etag"); last == "" || last == etag {
+				timeout := gceMaxWaitTimeout
+				if secs, err := strconv.Atoi(query.Get("timeout_sec")); err == nil && secs > 0 && time.Duration(secs)*time.Second < timeout {
+					timeout = time.Duration(secs) * time.Second
+				}
+
+				ctx, cancel := context.WithTimeout(r.Context(), timeout)
+				resp, etag, err = waitForGCEChange(ctx, logger, client, hw, path, recursive, resp, etag)
+				cancel()
+				if err != nil {
+					logger.With("error", err).Info("failed to wait for change")
+					writeGCEError(w, err)
+					return
+				}
+			}
+		}
+
+		w.Header().Set("Metadata-Flavor", gceMetadataFlavor)
+		w.Header().Set("ETag", etag)
+		if recursive {
+			w.Header().Set("Content-Type", "application/json")
+		} else {
+			w.Header().Set("Content-Type", "application/text")
+		}
+
+		if _, err := w.Write(resp); err != nil {
+			logger.With("error", err).Info("failed to write response")
+		}
+	})
+}
+
+// waitForGCEChange watches hw until the value at path no longer has etag, returning the new value and its etag. If ctx
+// is done before a change the current value, resp, is returned.
+func waitForGCEChange(ctx context.Context, logger log.Logger, client hardware.Client, hw hardware.Hardware, path string, recursive bool, resp []byte, etag string) ([]byte, string, error) {
+	id, err := hw.ID()
+	if err != nil {
+		return nil, "", errors.Wrap(err, "get hardware id")
+	}
+
+	watcher, err := client.Watch(ctx, id)
+	if err != nil {
+		return nil, "", errors.Wrap(err, "watch hardware")
+	}
+	if watcher == nil {
+		return nil, "", errors.New("hardware client doesn't support watching")
+	}
+
+	for {
+		hw, err := watcher.Recv()
+		if err != nil {
+			if ctx.Err() != nil {
+				return resp, etag, nil
+			}
+			return nil, "", errors.Wrap(err, "receive hardware update")
+		}
+
+		update, err := renderGCEMetadata(logger, hw, path, recursive)
+		if err != nil {
+			return nil, "", err
+		}
+
+		if updateETag := gceETag(update); updateETag != etag {
+			return update, updateETag, nil
+		}
+	}
+}
+
+// renderGCEMetadata renders the value at path in hw's GCE metadata tree. Directories are rendered as a listing of
+// their entries, with a trailing slash for sub-directories, unless recursive is set in which case they're rendered as
+// JSON. Strings are rendered raw and all other values as JSON.
+func renderGCEMetadata(logger log.Logger, hw hardware.Hardware, path string, recursive bool) ([]byte, error) {
+	ehw, err := hw.Export()
+	if err != nil {
+		return nil, errors.Wrap(err, "export hardware")
+	}
+
+	parsed, err := parseExportedHardware(ehw)
+	if err != nil {
+		return nil, err
+	}
+	parsed.Metadata.Userdata = string(renderUserdata(logger, hw, ehw, []byte(parsed.Metadata.Userdata)))
+
+	// The tree is navigated generically so it's converted to plain JSON values.
+	doc, err := json.Marshal(newGCEMetadata(parsed))
+	if err != nil {
+		return nil, errors.Wrap(err, "marshal metadata")
+	}
+
+	var value interface{}
+	if err := json.Unmarshal(doc, &value); err != nil {
+		return nil, errors.Wrap(err, "unmarshal metadata")
+	}
+
+	var key string
+	for _, segment := range strings.Split(strings.Trim(path, "/"), "/") {
+		if segment == "" {
+			continue
+		}
+
+		switch v := value.(type) {
+		case map[string]interface{}:
+			var ok bool
+			if value, ok = v[segment]; !ok {
+				return nil, errGCENotFound
+			}
+		case []interface{}:
+			i, err := strconv.Atoi(segment)
+			if err != nil || i < 0 || i >= len(v) {
+				return nil, errGCENotFound
+			}
+			value = v[i]
+		default:
+			return nil, errGCENotFound
+		}
+		key = segment
+	}
+
+	if recursive {
+		return json.Marshal(gceCamelCaseKeys(key, value))
+	}
+
+	switch v := value.(type) {
+	case string:
+		return []byte(v), nil
+	case map[string]interface{}:
+		var entries []string
+		for k, child := range v {
+			if isGCEDirectory(child) {
+				k += "/"
+			}
+			entries = append(entries, k)
+		}
+		sort.Strings(entries)
+		return []byte(strings.Join(entries, "\n")), nil
+	case []interface{}:
+		if !isGCEDirectory(v) {
+			return json.Marshal(v)
+		}
+		var entries []string
+		for i := range v {
+			entries = append(entries, strconv.Itoa(i)+"/")
+		}
+		return []byte(strings.Join(entries, "\n")), nil
+	default:
+		return json.Marshal(v)
+	}
+}
+
+// isGCEDirectory returns true if value should be listed as a directory. Lists of objects, such as network-interfaces,
+// are directories indexed by position while lists of scalars, such as tags, are values.
+func isGCEDirectory(value interface{}) bool {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		return true
+	case []interface{}:
+		if len(v) == 0 {
+			return false
+		}
+		_, ok := v[0].(map[string]interface{})
+		return ok
+	default:
+		return false
+	}
+}
+
+// gceCamelCaseKeys converts the keys of value, the entry at key, to camel case as recursive GCE queries do. User
+// defined attribute keys are left as is.
+func gceCamelCaseKeys(key string, value interface{}) interface{} {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		if key == "attributes" {
+			return v
+		}
+		converted := make(map[string]interface{}, len(v))
+		for k, child := range v {
+			converted[gceCamelCase(k)] = gceCamelCaseKeys(k, child)
+		}
+		return converted
+	case []interface{}:
+		converted := make([]interface{}, len(v))
+		for i, child := range v {
+			converted[i] = gceCamelCaseKeys("", child)
+		}
+		return converted
+	default:
+		return v
+	}
+}
+
+// gceCamelCase converts a hyphenated key such as machine-type to camel case, machineType.
+func gceCamelCase(key string) string {
+	parts := strings.Split(key, "-")
+	for i := 1; i < len(parts); i++ {
+		if parts[i] != "" {
+			parts[i] = strings.ToUpper(parts[i][:1]) + parts[i][1:]
+		}
+	}
+	return strings.Join(parts, "")
+}
+
+// gceETag returns the ETag of a GCE metadata value.
+func gceETag(value []byte) string {
+	return contentHash(value)
+}
+
+func writeGCEError(w http.ResponseWriter, err error) {
+	if errors.Is(err, errGCENotFound) {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	w.WriteHeader(http.StatusInternalServerError)
+}
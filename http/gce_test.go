@@ -0,0 +1,162 @@
+package http
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/packethost/pkg/log"
+	"github.com/stretchr/testify/require"
+	"github.com/tinkerbell/hegel/datamodel"
+	"github.com/tinkerbell/hegel/hardware"
+	"github.com/tinkerbell/hegel/hardware/mock"
+)
+
+func TestGCEEndpoint(t *testing.T) {
+	logger, err := log.Init(t.Name())
+	require.NoError(t, err)
+
+	for name, test := range tinkerbellGCETests {
+		t.Run(name, func(t *testing.T) {
+			client := mock.HardwareClient{Model: datamodel.TinkServer, Data: mock.TinkerbellKantEC2}
+			handler := GCEMetadataHandler(logger, client)
+
+			req, err := http.NewRequest("GET", test.url, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			req.RemoteAddr = mock.UserIP
+			if !test.omitFlavor {
+				req.Header.Set("Metadata-Flavor", "Google")
+			}
+			resp := httptest.NewRecorder()
+
+			handler.ServeHTTP(resp, req)
+
+			if status := resp.Code; status != test.status {
+				t.Errorf("handler returned wrong status code: got %v want %v",
+					status, test.status)
+			}
+
+			if resp.Body.String() != test.response {
+				t.Errorf("handler returned wrong body: got %v want %v", resp.Body.String(), test.response)
+			}
+		})
+	}
+}
+
+func TestGCEEndpointWaitForChange(t *testing.T) {
+	logger, err := log.Init(t.Name())
+	require.NoError(t, err)
+
+	client := watchingClient{
+		HardwareClient: mock.HardwareClient{Model: datamodel.TinkServer, Data: mock.TinkerbellKantEC2},
+		updates:        make(chan hardware.Hardware, 2),
+	}
+	handler := GCEMetadataHandler(logger, client)
+
+	get := func(url string) *httptest.ResponseRecorder {
+		req, err := http.NewRequest("GET", url, nil)
+		require.NoError(t, err)
+		req.RemoteAddr = mock.UserIP
+		req.Header.Set("Metadata-Flavor", "Google")
+		resp := httptest.NewRecorder()
+		handler.ServeHTTP(resp, req)
+		return resp
+	}
+
+	current := get("/computeMetadata/v1/instance/hostname")
+	require.Equal(t, 200, current.Code)
+	etag := current.Header().Get("ETag")
+	require.NotEmpty(t, etag)
+
+	// A stale etag returns the current value immediately.
+	resp := get("/computeMetadata/v1/instance/hostname?wait_for_change=true&last_etag=stale")
+	require.Equal(t, "tink-provisioner", resp.Body.String())
+
+	// Without a change the current value is returned once the timeout expires.
+	resp = get("/computeMetadata/v1/instance/hostname?wait_for_change=true&timeout_sec=1&last_etag=" + etag)
+	require.Equal(t, "tink-provisioner", resp.Body.String())
+	require.Equal(t, etag, resp.Header().Get("ETag"))
+
+	// Updates that don't change the value are ignored.
+	unchanged := &hardware.Tinkerbell{}
+	require.NoError(t, json.Unmarshal([]byte(mock.TinkerbellKantEC2), unchanged))
+	client.updates <- unchanged
+
+	renamed := &hardware.Tinkerbell{}
+	require.NoError(t, json.Unmarshal([]byte(strings.ReplaceAll(mock.TinkerbellKantEC2, "tink-provisioner", "renamed")), renamed))
+	client.updates <- renamed
+
+	resp = get("/computeMetadata/v1/instance/hostname?wait_for_change=true&last_etag=" + etag)
+	require.Equal(t, "renamed", resp.Body.String())
+	require.NotEqual(t, etag, resp.Header().Get("ETag"))
+}
+
+// test cases for TestGCEEndpoint.
+var tinkerbellGCETests = map[string]struct {
+	url        string
+	omitFlavor bool
+	status     int
+	response   string
+}{
+	"missing flavor": {
+		url:        "/computeMetadata/v1/instance/hostname",
+		omitFlavor: true,
+		status:     403,
+		response:   "Missing Metadata-Flavor:Google header.",
+	},
+	"base": {
+		url:      "/computeMetadata/v1/",
+		status:   200,
+		response: "instance/",
+	},
+	"instance": {
+		url:    "/computeMetadata/v1/instance/",
+		status: 200,
+		response: `attributes/
+hostname
+id
+machine-type
+name
+network-interfaces/
+tags
+zone`,
+	},
+	"hostname": {
+		url:      "/computeMetadata/v1/instance/hostname",
+		status:   200,
+		response: "tink-provisioner",
+	},
+	"tags": {
+		url:      "/computeMetadata/v1/instance/tags",
+		status:   200,
+		response: `["hello","test"]`,
+	},
+	"attributes": {
+		url:      "/computeMetadata/v1/instance/attributes/",
+		status:   200,
+		response: "user-data",
+	},
+	"network-interfaces": {
+		url:      "/computeMetadata/v1/instance/network-interfaces/",
+		status:   200,
+		response: "0/",
+	},
+	"network-interface ip": {
+		url:      "/computeMetadata/v1/instance/network-interfaces/0/ip",
+		status:   200,
+		response: "192.168.1.5",
+	},
+	"recursive": {
+		url:      "/computeMetadata/v1/instance/?recursive=true",
+		status:   200,
+		response: `{"attributes":{"user-data":"#!/bin/bash\n\necho \"Hello world!\""},"hostname":"tink-provisioner","id":"7c9a5711-aadd-4fa0-8e57-789431626a27","machineType":"c3.small.x86","name":"tink-provisioner","networkInterfaces":[{"gateway":"192.168.1.1","ip":"192.168.1.5","mac":"b4:96:91:5f:af:c0","subnetmask":"255.255.255.248"}],"tags":["hello","test"],"zone":"sjc1"}`,
+	},
+	"missing item": {
+		url:    "/computeMetadata/v1/instance/network-interfaces/1/ip",
+		status: 404,
+	},
+}
@@ -1,7 +1,6 @@
 package http
 
 import (
-	"bytes"
 	"context"
 	"encoding/json"
 	"fmt"
@@ -9,6 +8,7 @@ import (
 	"net/http"
 	"runtime"
 	"strings"
+	"text/template"
 	"time"
 
 	"github.com/itchyny/gojq"
@@ -19,16 +19,23 @@ import (
 	"github.com/tinkerbell/hegel/grpc"
 	"github.com/tinkerbell/hegel/hardware"
 	"github.com/tinkerbell/hegel/metrics"
+	"github.com/tinkerbell/hegel/xff"
 )
 
+// userdataFilter is the filter of the userdata. Userdata that's a template is rendered when it's served.
+const userdataFilter = ".metadata.userdata"
+
 // ec2Filters defines the query pattern and filters for the EC2 endpoint
 // for queries that are to return another list of metadata items, the filter is a static list of the metadata items ("directory-listing filter")
 // for /meta-data, the `spot` metadata item will only show up when the instance is a spot instance (denoted by if the `spot` field inside hardware is nonnull)
 // NOTE: make sure when adding a new metadata item in a "subdirectory", to also add it to the directory-listing filter.
+// Queries under /meta-data/network/interfaces/macs aren't filters, they're rendered by renderEC2Macs.
 var ec2Filters = map[string]string{
-	"":                                    `"meta-data", "user-data"`, // base path
-	"/user-data":                          ".metadata.userdata",
-	"/meta-data":                          `["instance-id", "hostname", "local-hostname", "iqn", "plan", "facility", "tags", "operating-system", "public-keys", "public-ipv4", "public-ipv6", "local-ipv4"] + (if .metadata.instance.spot != null then ["spot"] else [] end) | sort | .[]`,
+	"":                                    `"dynamic", "meta-data", "user-data", "vendor-data"`, // base path
+	"/user-data":                          userdataFilter,
+	"/vendor-data":                        "$vendor_data",        // bound by EC2MetadataHandler
+	"/dynamic":                            `"instance-identity"`, // served by InstanceIdentityHandler
+	"/meta-data":                          `["instance-id", "hostname", "local-hostname", "iqn", "plan", "facility", "tags", "operating-system", "public-keys", "public-ipv4", "public-ipv6", "local-ipv4", "network"] + (if .metadata.instance.spot != null then ["spot"] else [] end) | sort | .[]`,
 	"/meta-data/instance-id":              ".metadata.instance.id",
 	"/meta-data/hostname":                 ".metadata.instance.hostname",
 	"/meta-data/local-hostname":           ".metadata.instance.hostname",
@@ -49,8 +56,13 @@ var ec2Filters = map[string]string{
 	"/meta-data/public-ipv4":                               ".metadata.instance.network.addresses[]? | select(.address_family == 4 and .public == true) | .address",
 	"/meta-data/public-ipv6":                               ".metadata.instance.network.addresses[]? | select(.address_family == 6 and .public == true) | .address",
 	"/meta-data/local-ipv4":                                ".metadata.instance.network.addresses[]? | select(.address_family == 4 and .public == false) | .address",
+	"/meta-data/network":                                   `"interfaces"`,
+	"/meta-data/network/interfaces":                        `"macs"`,
 }
 
+// ec2FilterCodes are the compiled ec2Filters.
+var ec2FilterCodes = mustCompileFilters(ec2Filters)
+
 func VersionHandler(logger log.Logger) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
 		payload := struct {
@@ -111,8 +123,24 @@ func HealthCheckHandler(logger log.Logger, client HealthChecker, start time.Time
 
 // GetMetadataHandler provides an http handler that retrieves metadata using client filtering it
 // using filter. filter should be a jq compatible processing string. Data is only filtered when
-// using the TinkServer data model.
-func GetMetadataHandler(logger log.Logger, client hardware.Client, filter string, model datamodel.DataModel) http.Handler {
+// using the TinkServer data model. Responses carry an ETag and If-None-Match requests are honored.
+// An error is returned if filter doesn't compile.
+func GetMetadataHandler(logger log.Logger, client hardware.Client, filter string, model datamodel.DataModel) (http.Handler, error) {
+	var code *gojq.Code
+	var tmpl *template.Template
+	switch {
+	case isTemplate(filter):
+		var err error
+		if tmpl, err = parseTemplate("endpoint", filter); err != nil {
+			return nil, err
+		}
+	case model == datamodel.TinkServer || model == datamodel.Kubernetes || model == datamodel.File:
+		var err error
+		if code, err = compileFilter(filter); err != nil {
+			return nil, errors.Wrapf(err, "compile filter %q", filter)
+		}
+	}
+
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		if r.Method != "GET" {
 			w.WriteHeader(http.StatusMethodNotAllowed)
@@ -128,7 +156,7 @@ func GetMetadataHandler(logger log.Logger, client hardware.Client, filter string
 		metrics.MetadataRequests.Inc()
 		l := logger.With("userIP", userIP)
 		l.Info("got ip from request")
-		hw, err := client.ByIP(r.Context(), userIP)
+		hw, err := lookupHardware(r, client, userIP)
 		if err != nil {
 			metrics.Errors.WithLabelValues("metadata", "lookup").Inc()
 			l.With("error", err).Info("failed to get hardware by ip")
@@ -143,24 +171,36 @@ func GetMetadataHandler(logger log.Logger, client hardware.Client, filter string
 			return
 		}
 
-		if model == datamodel.TinkServer || model == datamodel.Kubernetes {
-			hardware, err = filterMetadata(hardware, filter)
+		switch {
+		case tmpl != nil:
+			hardware, err = executeTemplate(tmpl, hardware)
+			if err != nil {
+				l.With("error", err).Info("failed to render template")
+				w.WriteHeader(http.StatusInternalServerError)
+				return
+			}
+		case code != nil:
+			ehw := hardware
+			hardware, err = filterMetadata(ehw, code, filterArgs{})
 			if err != nil {
 				l.With("error", err).Info("failed to filter metadata")
 				w.WriteHeader(http.StatusInternalServerError)
 				return
 			}
+			if filter == userdataFilter {
+				hardware = renderUserdata(l, hw, ehw, hardware)
+			}
 		}
 
-		w.WriteHeader(http.StatusOK)
-		_, err = w.Write(hardware)
-		if err != nil {
+		if err := writeMetadata(w, r, hardware); err != nil {
 			l.With("error", err).Info("failed to write response")
 		}
-	})
+	}), nil
 }
 
-func EC2MetadataHandler(logger log.Logger, client hardware.Client) http.Handler {
+// EC2MetadataHandler serves the EC2 metadata tree under /2009-04-04. vendor-data is selected from vendorData, which
+// may be nil. Responses carry an ETag and If-None-Match requests are honored.
+func EC2MetadataHandler(logger log.Logger, client hardware.Client, vendorData *VendorData) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		if r.Method != "GET" {
 			w.WriteHeader(http.StatusMethodNotAllowed)
@@ -178,7 +218,7 @@ func EC2MetadataHandler(logger log.Logger, client hardware.Client) http.Handler
 		logger := logger.With("userIP", userIP)
 		logger.Info("Retrieved IP peer IP")
 
-		hw, err := client.ByIP(r.Context(), userIP)
+		hw, err := lookupHardware(r, client, userIP)
 		if err != nil {
 			metrics.Errors.WithLabelValues("metadata", "lookup").Inc()
 			logger.With("error", err).Info("failed to get hardware by ip")
@@ -199,7 +239,31 @@ func EC2MetadataHandler(logger log.Logger, client hardware.Client) http.Handler
 
 		logger.With("exported", string(ehw)).Debug("Exported hardware")
 
-		filter, err := processEC2Query(r.URL.Path)
+		if query, ok := ec2MacsQuery(r.URL.Path); ok {
+			parsed, err := parseExportedHardware(ehw)
+			if err != nil {
+				logger.With("error", err).Info("failed to parse exported hardware")
+				w.WriteHeader(http.StatusInternalServerError)
+				return
+			}
+
+			resp, err := renderEC2Macs(parsed, query)
+			if err != nil {
+				logger.With("error", err).Info("failed to render ec2 macs query")
+				w.WriteHeader(http.StatusNotFound)
+				if _, err := w.Write([]byte("404 not found")); err != nil {
+					logger.With("error", err).Info("failed to write response")
+				}
+				return
+			}
+
+			if _, err := w.Write(resp); err != nil {
+				logger.With("error", err).Info("failed to write response")
+			}
+			return
+		}
+
+		filter, code, err := processEC2Query(r.URL.Path)
 		if err != nil {
 			logger.With("error", err).Info("failed to process ec2 query")
 			w.WriteHeader(http.StatusNotFound)
@@ -210,69 +274,38 @@ func EC2MetadataHandler(logger log.Logger, client hardware.Client) http.Handler
 			return
 		}
 
-		resp, err := filterMetadata(ehw, filter)
+		selected, err := vendorData.Select(ehw)
 		if err != nil {
-			logger.With("error", err).Info("failed to filter metadata")
+			logger.With("error", err).Info("failed to select vendor data")
+			w.WriteHeader(http.StatusInternalServerError)
+			return
 		}
 
-		_, err = w.Write(resp)
+		resp, err := filterMetadata(ehw, code, filterArgs{VendorData: selected})
 		if err != nil {
-			logger.With("error", err).Info("failed to write response")
+			logger.With("error", err).Info("failed to filter metadata")
 		}
-	})
-}
-
-func filterMetadata(hw []byte, filter string) ([]byte, error) {
-	var result bytes.Buffer
-	query, err := gojq.Parse(filter)
-	if err != nil {
-		return nil, err
-	}
-	input := make(map[string]interface{})
-	err = json.Unmarshal(hw, &input)
-	if err != nil {
-		return nil, err
-	}
-	iter := query.Run(input)
-	for {
-		v, ok := iter.Next()
-		if !ok {
-			break
+		if filter == userdataFilter {
+			resp = renderUserdata(logger, hw, ehw, resp)
 		}
 
-		if v == nil {
-			continue
-		}
-
-		switch vv := v.(type) {
-		case error:
-			return nil, errors.Wrap(vv, "error while filtering with gojq")
-		case string:
-			result.WriteString(vv)
-		default:
-			marshalled, err := json.Marshal(vv)
-			if err != nil {
-				return nil, errors.Wrap(err, "error marshalling jq result")
-			}
-			result.Write(marshalled)
+		if err := writeMetadata(w, r, resp); err != nil {
+			logger.With("error", err).Info("failed to write response")
 		}
-		result.WriteRune('\n')
-	}
-
-	return bytes.TrimSuffix(result.Bytes(), []byte("\n")), nil
+	})
 }
 
 // processEC2Query returns either a specific filter (used to parse hardware data for the value of a specific field),
-// or a comma-separated list of metadata items (to be printed).
-func processEC2Query(url string) (string, error) {
+// or a comma-separated list of metadata items (to be printed), along with the compiled filter.
+func processEC2Query(url string) (string, *gojq.Code, error) {
 	query := strings.TrimRight(strings.TrimPrefix(url, "/2009-04-04"), "/") // remove base pattern and trailing slash
 
 	filter, ok := ec2Filters[query]
 	if !ok {
-		return "", errors.Errorf("invalid metadata item: %v", query)
+		return "", nil, errors.Errorf("invalid metadata item: %v", query)
 	}
 
-	return filter, nil
+	return filter, ec2FilterCodes[query], nil
 }
 
 func getIPFromRequest(r *http.Request) string {
@@ -283,6 +316,20 @@ func getIPFromRequest(r *http.Request) string {
 	return addr
 }
 
+// lookupHardware retrieves the hardware of the client making r. A MAC supplied by a trusted proxy in the
+// xff.ClientMACHeader takes precedence over ip as the source IP isn't reliable behind NAT or during DHCP churn. Admin
+// requests are served as the machine they impersonate, see AdminHandler.
+func lookupHardware(r *http.Request, client hardware.Client, ip string) (hardware.Hardware, error) {
+	if hw, ok := impersonatedHardware(r.Context()); ok {
+		return hw, nil
+	}
+
+	if mac := r.Header.Get(xff.ClientMACHeader); mac != "" {
+		return client.ByMAC(r.Context(), mac)
+	}
+	return client.ByIP(r.Context(), ip)
+}
+
 func writeJSONError(w http.ResponseWriter, code int, err error) error {
 	return writeJSONResponse(w, code, map[string]interface{}{
 		"error": map[string]interface{}{
@@ -0,0 +1,358 @@
+package http
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"math/big"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/packethost/pkg/log"
+	"github.com/pkg/errors"
+	"github.com/tinkerbell/hegel/hardware"
+	"github.com/tinkerbell/hegel/metrics"
+)
+
+// instanceIdentityDocument is the instance identity document. Field names follow the EC2 document.
+type instanceIdentityDocument struct {
+	AvailabilityZone string `json:"availabilityZone,omitempty"`
+	InstanceID       string `json:"instanceId,omitempty"`
+	InstanceType     string `json:"instanceType,omitempty"`
+	PrivateIP        string `json:"privateIp,omitempty"`
+	PublicIP         string `json:"publicIp,omitempty"`
+	Region           string `json:"region,omitempty"`
+	Version          string `json:"version"`
+}
+
+// newInstanceIdentityDocument builds the instance identity document of hw. The private IP is the first private IPv4
+// instance address, falling back to the first IPv4 interface address.
+func newInstanceIdentityDocument(hw *exportedHardware) instanceIdentityDocument {
+	doc := instanceIdentityDocument{
+		AvailabilityZone: hw.facility(),
+		InstanceID:       hw.Metadata.Instance.ID,
+		InstanceType:     hw.Metadata.Instance.Plan,
+		Region:           hw.facility(),
+		Version:          "2017-09-30",
+	}
+
+	for _, address := range hw.Metadata.Instance.Network.Addresses {
+		if address.AddressFamily != 4 {
+			continue
+		}
+		if address.Public && doc.PublicIP == "" {
+			doc.PublicIP = address.Address
+		}
+		if !address.Public && doc.PrivateIP == "" {
+			doc.PrivateIP = address.Address
+		}
+	}
+
+	for _, iface := range hw.interfaces() {
+		if doc.PrivateIP != "" {
+			break
+		}
+		if !strings.Contains(iface.Address, ":") {
+			doc.PrivateIP = iface.Address
+		}
+	}
+
+	return doc
+}
+
+var (
+	oidData            = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 7, 1}
+	oidSignedData      = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 7, 2}
+	oidSHA256          = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 2, 1}
+	oidRSAEncryption   = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 1, 1}
+	oidECDSAWithSHA256 = asn1.ObjectIdentifier{1, 2, 840, 10045, 4, 3, 2}
+)
+
+// InstanceIdentitySigner signs instance identity documents so services can verify the identity of the machine that
+// presents one.
+type InstanceIdentitySigner struct {
+	key  crypto.Signer
+	cert *x509.Certificate
+
+	// signatureAlgorithm identifies the algorithm of key in PKCS#7 signatures.
+	signatureAlgorithm pkix.AlgorithmIdentifier
+}
+
+// NewInstanceIdentitySigner creates an InstanceIdentitySigner that signs with key. cert must be a certificate for
+// key's public key; its included in PKCS#7 signatures. Only RSA and ECDSA keys are supported.
+func NewInstanceIdentitySigner(key crypto.Signer, cert *x509.Certificate) (*InstanceIdentitySigner, error) {
+	s := &InstanceIdentitySigner{key: key, cert: cert}
+
+	switch key.(type) {
+	case *rsa.PrivateKey:
+		s.signatureAlgorithm = pkix.AlgorithmIdentifier{Algorithm: oidRSAEncryption, Parameters: asn1.NullRawValue}
+	case *ecdsa.PrivateKey:
+		s.signatureAlgorithm = pkix.AlgorithmIdentifier{Algorithm: oidECDSAWithSHA256}
+	default:
+		return nil, errors.Errorf("unsupported instance identity key type: %T", key)
+	}
+
+	public, err := x509.MarshalPKIXPublicKey(key.Public())
+	if err != nil {
+		return nil, errors.Wrap(err, "marshal public key")
+	}
+	if !bytes.Equal(public, cert.RawSubjectPublicKeyInfo) {
+		return nil, errors.New("instance identity certificate doesn't match the key")
+	}
+
+	return s, nil
+}
+
+// LoadInstanceIdentitySigner creates an InstanceIdentitySigner from a PEM encoded private key and certificate.
+func LoadInstanceIdentitySigner(keyPath, certPath string) (*InstanceIdentitySigner, error) {
+	keyPEM, err := os.ReadFile(keyPath)
+	if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode(keyPEM)
+	if block == nil {
+		return nil, errors.Errorf("no PEM data in %v", keyPath)
+	}
+	key, err := parsePrivateKey(block.Bytes)
+	if err != nil {
+		return nil, errors.Wrapf(err, "parse %v", keyPath)
+	}
+
+	certPEM, err := os.ReadFile(certPath)
+	if err != nil {
+		return nil, err
+	}
+	block, _ = pem.Decode(certPEM)
+	if block == nil {
+		return nil, errors.Errorf("no PEM data in %v", certPath)
+	}
+	cert, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		return nil, errors.Wrapf(err, "parse %v", certPath)
+	}
+
+	return NewInstanceIdentitySigner(key, cert)
+}
+
+// parsePrivateKey parses a PKCS#8, PKCS#1 or SEC 1 DER encoded private key.
+func parsePrivateKey(der []byte) (crypto.Signer, error) {
+	if key, err := x509.ParsePKCS8PrivateKey(der); err == nil {
+		signer, ok := key.(crypto.Signer)
+		if !ok {
+			return nil, errors.Errorf("unsupported private key type: %T", key)
+		}
+		return signer, nil
+	}
+	if key, err := x509.ParsePKCS1PrivateKey(der); err == nil {
+		return key, nil
+	}
+	if key, err := x509.ParseECPrivateKey(der); err == nil {
+		return key, nil
+	}
+	return nil, errors.New("unknown private key format")
+}
+
+// Sign returns the signature of the SHA-256 digest of document.
+func (s *InstanceIdentitySigner) Sign(document []byte) ([]byte, error) {
+	digest := sha256.Sum256(document)
+	return s.key.Sign(rand.Reader, digest[:], crypto.SHA256)
+}
+
+// SignPKCS7 returns a DER encoded PKCS#7 SignedData structure containing document, its signature and the signer's
+// certificate.
+func (s *InstanceIdentitySigner) SignPKCS7(document []byte) ([]byte, error) {
+	signature, err := s.Sign(document)
+	if err != nil {
+		return nil, err
+	}
+
+	sha256Algorithm := pkix.AlgorithmIdentifier{Algorithm: oidSHA256, Parameters: asn1.NullRawValue}
+
+	signedData := pkcs7SignedData{
+		Version:          1,
+		DigestAlgorithms: []pkix.AlgorithmIdentifier{sha256Algorithm},
+		ContentInfo:      pkcs7Data{ContentType: oidData, Content: document},
+		Certificates: asn1.RawValue{
+			Class:      asn1.ClassContextSpecific,
+			Tag:        0,
+			IsCompound: true,
+			Bytes:      s.cert.Raw,
+		},
+		SignerInfos: []pkcs7SignerInfo{{
+			Version: 1,
+			IssuerAndSerialNumber: pkcs7IssuerAndSerialNumber{
+				Issuer:       asn1.RawValue{FullBytes: s.cert.RawIssuer},
+				SerialNumber: s.cert.SerialNumber,
+			},
+			DigestAlgorithm:           sha256Algorithm,
+			DigestEncryptionAlgorithm: s.signatureAlgorithm,
+			EncryptedDigest:           signature,
+		}},
+	}
+
+	return asn1.Marshal(pkcs7ContentInfo{ContentType: oidSignedData, Content: signedData})
+}
+
+// PublicKey returns the PEM encoded public key documents are signed with.
+func (s *InstanceIdentitySigner) PublicKey() []byte {
+	return pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: s.cert.RawSubjectPublicKeyInfo})
+}
+
+// pkcs7ContentInfo and the types it's composed of model the subset of PKCS#7 (RFC 2315) needed to produce a
+// SignedData structure with a single signer and no authenticated attributes.
+type pkcs7ContentInfo struct {
+	ContentType asn1.ObjectIdentifier
+	Content     pkcs7SignedData `asn1:"explicit,tag:0"`
+}
+
+type pkcs7SignedData struct {
+	Version          int
+	DigestAlgorithms []pkix.AlgorithmIdentifier `asn1:"set"`
+	ContentInfo      pkcs7Data
+	Certificates     asn1.RawValue
+	SignerInfos      []pkcs7SignerInfo `asn1:"set"`
+}
+
+type pkcs7Data struct {
+	ContentType asn1.ObjectIdentifier
+	Content     []byte `asn1:"explicit,tag:0"`
+}
+
+type pkcs7SignerInfo struct {
+	Version                   int
+	IssuerAndSerialNumber     pkcs7IssuerAndSerialNumber
+	DigestAlgorithm           pkix.AlgorithmIdentifier
+	DigestEncryptionAlgorithm pkix.AlgorithmIdentifier
+	EncryptedDigest           []byte
+}
+
+type pkcs7IssuerAndSerialNumber struct {
+	Issuer       asn1.RawValue
+	SerialNumber *big.Int
+}
+
+// InstanceIdentityHandler serves the instance identity document of the requesting machine under
+// /2009-04-04/dynamic/instance-identity. When signer is set the document's signature, a PKCS#7 structure containing
+// the signed document, and the public key needed to verify them are also served. Without a signer only the document is
+// available.
+func InstanceIdentityHandler(logger log.Logger, client hardware.Client, signer *InstanceIdentitySigner) http.Handler {
+	items := "document"
+	if signer != nil {
+		items = "document\npkcs7\npublic-key\nsignature"
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "GET" {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+
+		item := strings.Trim(strings.TrimPrefix(r.URL.Path, "/2009-04-04/dynamic/instance-identity"), "/")
+
+		switch {
+		case item == "":
+			writeIdentityResponse(logger, w, []byte(items))
+			return
+		case item == "document":
+		case signer == nil:
+			w.WriteHeader(http.StatusNotFound)
+			return
+		case item == "public-key":
+			// The public key isn't specific to the requesting machine so there's no need to look it up.
+			writeIdentityResponse(logger, w, signer.PublicKey())
+			return
+		case item == "signature", item == "pkcs7":
+			// A signature vouches that the document was served to the machine it describes, which isn't true of
+			// admin requests impersonating it.
+			if _, ok := impersonatedHardware(r.Context()); ok {
+				w.WriteHeader(http.StatusForbidden)
+				return
+			}
+		default:
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+
+		userIP := getIPFromRequest(r)
+		if userIP == "" {
+			logger.Info("Could not retrieve IP address")
+			return
+		}
+
+		metrics.MetadataRequests.Inc()
+		logger := logger.With("userIP", userIP)
+
+		hw, err := lookupHardware(r, client, userIP)
+		if err != nil {
+			metrics.Errors.WithLabelValues("metadata", "lookup").Inc()
+			logger.With("error", err).Info("failed to get hardware by ip")
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+
+		ehw, err := hw.Export()
+		if err != nil {
+			logger.With("error", err).Info("failed to export hardware")
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+
+		parsed, err := parseExportedHardware(ehw)
+		if err != nil {
+			logger.With("error", err).Info("failed to parse exported hardware")
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+
+		document, err := json.Marshal(newInstanceIdentityDocument(parsed))
+		if err != nil {
+			logger.With("error", err).Info("failed to marshal instance identity document")
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+
+		var resp []byte
+		switch item {
+		case "document":
+			w.Header().Set("Content-Type", "application/json")
+			resp = document
+		case "signature":
+			signature, err := signer.Sign(document)
+			if err != nil {
+				logger.Error(err, "failed to sign instance identity document")
+				w.WriteHeader(http.StatusInternalServerError)
+				return
+			}
+			resp = []byte(base64.StdEncoding.EncodeToString(signature))
+		case "pkcs7":
+			signed, err := signer.SignPKCS7(document)
+			if err != nil {
+				logger.Error(err, "failed to sign instance identity document")
+				w.WriteHeader(http.StatusInternalServerError)
+				return
+			}
+			// Match EC2 which serves the body of a PEM block without the header and footer.
+			block := pem.EncodeToMemory(&pem.Block{Type: "PKCS7", Bytes: signed})
+			lines := strings.Split(strings.TrimSpace(string(block)), "\n")
+			resp = []byte(strings.Join(lines[1:len(lines)-1], "\n"))
+		}
+
+		writeIdentityResponse(logger, w, resp)
+	})
+}
+
+func writeIdentityResponse(logger log.Logger, w http.ResponseWriter, resp []byte) {
+	if _, err := w.Write(resp); err != nil {
+		logger.With("error", err).Info("failed to write response")
+	}
+}
@@ -0,0 +1,115 @@
+package http
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/base64"
+	"encoding/pem"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/packethost/pkg/log"
+	"github.com/stretchr/testify/require"
+	"github.com/tinkerbell/hegel/datamodel"
+	"github.com/tinkerbell/hegel/hardware/mock"
+)
+
+func TestInstanceIdentity(t *testing.T) {
+	logger, err := log.Init(t.Name())
+	require.NoError(t, err)
+
+	signer := newTestIdentitySigner(t)
+	client := mock.HardwareClient{Model: datamodel.TinkServer, Data: mock.TinkerbellKantEC2}
+	handler := InstanceIdentityHandler(logger, client, signer)
+
+	get := func(handler http.Handler, item string) *httptest.ResponseRecorder {
+		req, err := http.NewRequest("GET", "/2009-04-04/dynamic/instance-identity/"+item, nil)
+		require.NoError(t, err)
+		req.RemoteAddr = mock.UserIP
+		resp := httptest.NewRecorder()
+		handler.ServeHTTP(resp, req)
+		return resp
+	}
+
+	resp := get(handler, "")
+	require.Equal(t, "document\npkcs7\npublic-key\nsignature", resp.Body.String())
+
+	resp = get(handler, "document")
+	require.Equal(t, 200, resp.Code)
+	document := resp.Body.Bytes()
+	require.JSONEq(t, `{
+		"availabilityZone": "sjc1",
+		"instanceId": "7c9a5711-aadd-4fa0-8e57-789431626a27",
+		"instanceType": "c3.small.x86",
+		"privateIp": "10.87.63.3",
+		"publicIp": "139.175.86.114",
+		"region": "sjc1",
+		"version": "2017-09-30"
+	}`, string(document))
+
+	resp = get(handler, "public-key")
+	require.Equal(t, 200, resp.Code)
+	block, _ := pem.Decode(resp.Body.Bytes())
+	require.NotNil(t, block)
+	public, err := x509.ParsePKIXPublicKey(block.Bytes)
+	require.NoError(t, err)
+
+	resp = get(handler, "signature")
+	require.Equal(t, 200, resp.Code)
+	signature, err := base64.StdEncoding.DecodeString(resp.Body.String())
+	require.NoError(t, err)
+	digest := sha256.Sum256(document)
+	require.True(t, ecdsa.VerifyASN1(public.(*ecdsa.PublicKey), digest[:], signature))
+
+	resp = get(handler, "pkcs7")
+	require.Equal(t, 200, resp.Code)
+	signed, err := base64.StdEncoding.DecodeString(strings.ReplaceAll(resp.Body.String(), "\n", ""))
+	require.NoError(t, err)
+	var contentInfo struct {
+		ContentType asn1.ObjectIdentifier
+		Content     asn1.RawValue `asn1:"explicit,tag:0"`
+	}
+	_, err = asn1.Unmarshal(signed, &contentInfo)
+	require.NoError(t, err)
+	require.True(t, contentInfo.ContentType.Equal(oidSignedData))
+	require.True(t, bytes.Contains(contentInfo.Content.Bytes, document))
+
+	// Without a signer only the document is served.
+	handler = InstanceIdentityHandler(logger, client, nil)
+	require.Equal(t, "document", get(handler, "").Body.String())
+	require.Equal(t, 200, get(handler, "document").Code)
+	require.Equal(t, 404, get(handler, "signature").Code)
+	require.Equal(t, 404, get(handler, "public-key").Code)
+}
+
+// newTestIdentitySigner creates an InstanceIdentitySigner with a new ECDSA key and a self-signed certificate.
+func newTestIdentitySigner(t *testing.T) *InstanceIdentitySigner {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "hegel"},
+		NotBefore:    time.Now(),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, key.Public(), key)
+	require.NoError(t, err)
+	cert, err := x509.ParseCertificate(der)
+	require.NoError(t, err)
+
+	signer, err := NewInstanceIdentitySigner(key, cert)
+	require.NoError(t, err)
+	return signer
+}
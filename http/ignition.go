@@ -0,0 +1,192 @@
+package http
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/packethost/pkg/log"
+	"github.com/pkg/errors"
+	"github.com/tinkerbell/hegel/hardware"
+	"github.com/tinkerbell/hegel/metrics"
+)
+
+// ignitionContentType is the media type of Ignition configs.
+const ignitionContentType = "application/vnd.coreos.ignition+json"
+
+// ignitionConfig is the subset of an Ignition spec 3.3.0 config Hegel generates.
+type ignitionConfig struct {
+	Ignition struct {
+		Version string `json:"version"`
+	} `json:"ignition"`
+	Passwd  *ignitionPasswd  `json:"passwd,omitempty"`
+	Storage *ignitionStorage `json:"storage,omitempty"`
+}
+
+type ignitionPasswd struct {
+	Users []ignitionUser `json:"users"`
+}
+
+type ignitionUser struct {
+	Name              string   `json:"name"`
+	SSHAuthorizedKeys []string `json:"sshAuthorizedKeys"`
+}
+
+type ignitionStorage struct {
+	Files []ignitionFile `json:"files"`
+}
+
+type ignitionFile struct {
+	Path      string `json:"path"`
+	Mode      int    `json:"mode"`
+	Overwrite bool   `json:"overwrite"`
+	Contents  struct {
+		Source string `json:"source"`
+	} `json:"contents"`
+}
+
+// newIgnitionFile returns a file with mode 0644 that Ignition writes with contents.
+func newIgnitionFile(path, contents string) ignitionFile {
+	file := ignitionFile{Path: path, Mode: 0o644, Overwrite: true}
+	file.Contents.Source = "data:," + uriEscape(contents)
+	return file
+}
+
+// renderIgnition renders the Ignition config of the hardware. Userdata that's already an Ignition config is served as
+// is. Otherwise a minimal spec 3.3.0 config is generated that sets the hostname, authorizes the SSH keys for the core
+// user and writes a systemd-networkd unit per interface configuring its static address, or DHCP when it has none.
+func renderIgnition(logger log.Logger, h hardware.Hardware, ehw []byte) ([]byte, error) {
+	hw, err := parseExportedHardware(ehw)
+	if err != nil {
+		return nil, err
+	}
+
+	if userdata := string(renderUserdata(logger, h, ehw, []byte(hw.userdata()))); isIgnitionConfig(userdata) {
+		var buf bytes.Buffer
+		if err := json.Compact(&buf, []byte(userdata)); err != nil {
+			return nil, errors.Wrap(err, "compact ignition userdata")
+		}
+		return buf.Bytes(), nil
+	}
+
+	config := ignitionConfig{}
+	config.Ignition.Version = "3.3.0"
+
+	if keys := hw.sshKeys(); len(keys) > 0 {
+		config.Passwd = &ignitionPasswd{Users: []ignitionUser{{Name: "core", SSHAuthorizedKeys: keys}}}
+	}
+
+	var files []ignitionFile
+	if hostname := hw.hostname(); hostname != "" {
+		files = append(files, newIgnitionFile("/etc/hostname", hostname))
+	}
+
+	interfaces := hw.interfaces()
+	gateway := -1
+	for i, iface := range interfaces {
+		if iface.Address != "" && iface.Gateway != "" {
+			gateway = i
+			break
+		}
+	}
+	for i, iface := range interfaces {
+		unit := []string{"[Match]", "MACAddress=" + iface.MAC, "", "[Network]"}
+		if iface.Address == "" {
+			unit = append(unit, "DHCP=yes")
+		} else {
+			unit = append(unit, "Address="+addressCIDR(iface.Address, iface.Netmask))
+		}
+		if i == gateway {
+			unit = append(unit, "Gateway="+iface.Gateway)
+		}
+		path := fmt.Sprintf("/etc/systemd/network/%d-eth%d.network", 10+i, i)
+		files = append(files, newIgnitionFile(path, strings.Join(unit, "\n")+"\n"))
+	}
+	if len(files) > 0 {
+		config.Storage = &ignitionStorage{Files: files}
+	}
+
+	resp, err := json.Marshal(config)
+	if err != nil {
+		return nil, errors.Wrap(err, "marshal ignition config")
+	}
+	return resp, nil
+}
+
+// isIgnitionConfig reports whether userdata is an Ignition config, a JSON object with an ignition version.
+func isIgnitionConfig(userdata string) bool {
+	var config struct {
+		Ignition struct {
+			Version string `json:"version"`
+		} `json:"ignition"`
+	}
+	return json.Unmarshal([]byte(userdata), &config) == nil && config.Ignition.Version != ""
+}
+
+// uriEscape percent-encodes every byte of s that isn't an unreserved URI character as data URLs require.
+func uriEscape(s string) string {
+	const hex = "0123456789ABCDEF"
+
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case 'a' <= c && c <= 'z', 'A' <= c && c <= 'Z', '0' <= c && c <= '9', c == '-', c == '_', c == '.', c == '~':
+			b.WriteByte(c)
+		default:
+			b.WriteByte('%')
+			b.WriteByte(hex[c>>4])
+			b.WriteByte(hex[c&0xf])
+		}
+	}
+	return b.String()
+}
+
+// IgnitionHandler serves the Ignition config of the requesting machine under /ignition for Ignition based
+// distributions, such as Flatcar and Fedora CoreOS, that can't consume cloud-config userdata.
+func IgnitionHandler(logger log.Logger, client hardware.Client) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "GET" {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+
+		userIP := getIPFromRequest(r)
+		if userIP == "" {
+			logger.Info("Could not retrieve IP address")
+			return
+		}
+
+		metrics.MetadataRequests.Inc()
+		logger := logger.With("userIP", userIP)
+
+		hw, err := lookupHardware(r, client, userIP)
+		if err != nil {
+			metrics.Errors.WithLabelValues("metadata", "lookup").Inc()
+			logger.With("error", err).Info("failed to get hardware by ip")
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+
+		ehw, err := hw.Export()
+		if err != nil {
+			logger.With("error", err).Info("failed to export hardware")
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+
+		resp, err := renderIgnition(logger, hw, ehw)
+		if err != nil {
+			logger.With("error", err).Info("failed to render ignition config")
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", ignitionContentType)
+		if _, err := w.Write(resp); err != nil {
+			logger.With("error", err).Info("failed to write response")
+		}
+	})
+}
@@ -0,0 +1,69 @@
+package http
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/packethost/pkg/log"
+	"github.com/stretchr/testify/require"
+	"github.com/tinkerbell/hegel/datamodel"
+	"github.com/tinkerbell/hegel/hardware/mock"
+)
+
+func TestIgnitionEndpoint(t *testing.T) {
+	logger, err := log.Init(t.Name())
+	require.NoError(t, err)
+
+	for name, test := range tinkerbellIgnitionTests {
+		t.Run(name, func(t *testing.T) {
+			client := mock.HardwareClient{Model: datamodel.TinkServer, Data: test.json}
+			handler := IgnitionHandler(logger, client)
+
+			req, err := http.NewRequest("GET", "/ignition", nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			req.RemoteAddr = mock.UserIP
+			resp := httptest.NewRecorder()
+
+			handler.ServeHTTP(resp, req)
+
+			if status := resp.Code; status != test.status {
+				t.Errorf("handler returned wrong status code: got %v want %v",
+					status, test.status)
+			}
+
+			if resp.Body.String() != test.response {
+				t.Errorf("handler returned wrong body: got %v want %v", resp.Body.String(), test.response)
+			}
+
+			if contentType := resp.Header().Get("Content-Type"); contentType != ignitionContentType {
+				t.Errorf("handler returned wrong content type: got %v want %v", contentType, ignitionContentType)
+			}
+		})
+	}
+}
+
+// test cases for TestIgnitionEndpoint.
+var tinkerbellIgnitionTests = map[string]struct {
+	status   int
+	response string
+	json     string
+}{
+	"generated": {
+		status:   200,
+		response: `{"ignition":{"version":"3.3.0"},"storage":{"files":[{"path":"/etc/hostname","mode":420,"overwrite":true,"contents":{"source":"data:,tink-provisioner"}},{"path":"/etc/systemd/network/10-eth0.network","mode":420,"overwrite":true,"contents":{"source":"data:,%5BMatch%5D%0AMACAddress%3Db4%3A96%3A91%3A5f%3Aaf%3Ac0%0A%0A%5BNetwork%5D%0AAddress%3D192.168.1.5%2F29%0AGateway%3D192.168.1.1%0A"}}]}}`,
+		json:     mock.TinkerbellKantEC2,
+	},
+	"ignition userdata": {
+		status:   200,
+		response: `{"ignition":{"version":"3.1.0"},"passwd":{"users":[{"name":"core"}]}}`,
+		json:     `{"id": "ignition", "metadata": "{\"userdata\": \"{\\\"ignition\\\": {\\\"version\\\": \\\"3.1.0\\\"}, \\\"passwd\\\": {\\\"users\\\": [{\\\"name\\\": \\\"core\\\"}]}}\"}"}`,
+	},
+	"cloud-config userdata": {
+		status:   200,
+		response: `{"ignition":{"version":"3.3.0"},"storage":{"files":[{"path":"/etc/hostname","mode":420,"overwrite":true,"contents":{"source":"data:,host"}}]}}`,
+		json:     `{"id": "cloud-config", "metadata": "{\"userdata\": \"#cloud-config\\n\", \"instance\": {\"hostname\": \"host\"}}"}`,
+	},
+}
@@ -0,0 +1,188 @@
+package http
+
+import (
+	"math"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/tinkerbell/hegel/metrics"
+	"golang.org/x/time/rate"
+)
+
+// limiterSweepInterval is how often idle clients are removed from a requestLimiter.
+const limiterSweepInterval = time.Minute
+
+// monitoringPaths are monitoring endpoints that aren't subject to RequestLimits or the allowed sources so Hegel can be
+// observed while it's rejecting requests.
+var monitoringPaths = map[string]bool{
+	"/metrics":             true,
+	"/_packet/healthcheck": true,
+	"/_packet/version":     true,
+}
+
+// streamingPaths are long lived event streams, see isLongLived.
+var streamingPaths = map[string]bool{
+	"/events":    true,
+	"/v0/events": true,
+}
+
+// isLongLived returns true if r is an event stream or a GCE wait_for_change request, which last as long as their client
+// waits. They're rate limited but don't count towards RequestLimits.MaxInFlight as they'd hold on to it.
+func isLongLived(r *http.Request) bool {
+	if streamingPaths[r.URL.Path] {
+		return true
+	}
+	return strings.HasPrefix(r.URL.Path, "/computeMetadata/v1") && r.URL.Query().Get("wait_for_change") == "true"
+}
+
+// RequestLimits configures the limits applied to requests to the metadata APIs.
+type RequestLimits struct {
+	// Rate is the number of requests per second each client, identified by its IP once X-Forwarded-For is resolved, may
+	// make. Requests beyond it are rejected with http.StatusTooManyRequests. 0 disables rate limiting.
+	Rate float64
+
+	// Burst is the number of requests a client may make at once. It defaults to Rate rounded up.
+	Burst int
+
+	// MaxInFlight is the number of requests served at once. Requests beyond it are rejected with
+	// http.StatusServiceUnavailable. 0 disables the limit.
+	MaxInFlight int
+}
+
+// Validate returns an error if any limit is negative.
+func (l RequestLimits) Validate() error {
+	if l.Rate < 0 {
+		return errors.New("rate must not be negative")
+	}
+	if l.Burst < 0 {
+		return errors.New("burst must not be negative")
+	}
+	if l.MaxInFlight < 0 {
+		return errors.New("max in flight must not be negative")
+	}
+	return nil
+}
+
+// enabled returns true if any limit is configured.
+func (l RequestLimits) enabled() bool {
+	return l.Rate > 0 || l.MaxInFlight > 0
+}
+
+// requestLimiter enforces RequestLimits. Clients are tracked while they have used some of their burst; idle clients
+// are removed periodically.
+type requestLimiter struct {
+	rate     rate.Limit
+	burst    int
+	inFlight chan struct{}
+	now      func() time.Time
+
+	mu        sync.Mutex
+	clients   map[string]*clientLimiter
+	nextSweep time.Time
+}
+
+// clientLimiter is the rate limiter of a single client.
+type clientLimiter struct {
+	limiter  *rate.Limiter
+	lastSeen time.Time
+}
+
+// newRequestLimiter creates a requestLimiter enforcing limits.
+func newRequestLimiter(limits RequestLimits) *requestLimiter {
+	burst := limits.Burst
+	if burst == 0 {
+		burst = int(math.Ceil(limits.Rate))
+	}
+
+	limiter := &requestLimiter{
+		rate:    rate.Limit(limits.Rate),
+		burst:   burst,
+		now:     time.Now,
+		clients: make(map[string]*clientLimiter),
+	}
+
+	if limits.MaxInFlight > 0 {
+		limiter.inFlight = make(chan struct{}, limits.MaxInFlight)
+	}
+
+	return limiter
+}
+
+// Handler wraps next rejecting requests that exceed the limits. It must be wrapped by the X-Forwarded-For handler so
+// clients behind proxies are limited individually.
+func (l *requestLimiter) Handler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !l.allow(getIPFromRequest(r)) {
+			metrics.RejectedRequests.WithLabelValues("rate-limit").Inc()
+			w.Header().Set("Retry-After", "1")
+			w.WriteHeader(http.StatusTooManyRequests)
+			return
+		}
+
+		if l.inFlight != nil && !isLongLived(r) {
+			select {
+			case l.inFlight <- struct{}{}:
+				defer func() { <-l.inFlight }()
+			default:
+				metrics.RejectedRequests.WithLabelValues("in-flight").Inc()
+				w.Header().Set("Retry-After", "1")
+				w.WriteHeader(http.StatusServiceUnavailable)
+				return
+			}
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// exceptMonitoring serves requests to monitoringPaths with monitoring and all other requests with next.
+func exceptMonitoring(next, monitoring http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if monitoringPaths[r.URL.Path] {
+			monitoring.ServeHTTP(w, r)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// allow returns true if the client with ip may make a request now.
+func (l *requestLimiter) allow(ip string) bool {
+	if l.rate == 0 {
+		return true
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := l.now()
+	l.sweep(now)
+
+	client, ok := l.clients[ip]
+	if !ok {
+		client = &clientLimiter{limiter: rate.NewLimiter(l.rate, l.burst)}
+		l.clients[ip] = client
+	}
+	client.lastSeen = now
+
+	return client.limiter.AllowN(now, 1)
+}
+
+// sweep removes clients whose burst has been replenished as they're indistinguishable from new clients. It runs at
+// most once per limiterSweepInterval. l.mu must be held.
+func (l *requestLimiter) sweep(now time.Time) {
+	if now.Before(l.nextSweep) {
+		return
+	}
+	l.nextSweep = now.Add(limiterSweepInterval)
+
+	replenish := time.Duration(float64(l.burst) / float64(l.rate) * float64(time.Second))
+	for ip, client := range l.clients {
+		if now.Sub(client.lastSeen) >= replenish {
+			delete(l.clients, ip)
+		}
+	}
+}
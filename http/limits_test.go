@@ -0,0 +1,78 @@
+package http
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"github.com/tinkerbell/hegel/hardware/mock"
+)
+
+func TestRequestLimiterRate(t *testing.T) {
+	now := time.Now()
+	limiter := newRequestLimiter(RequestLimits{Rate: 1, Burst: 2})
+	limiter.now = func() time.Time { return now }
+
+	noop := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+	handler := exceptMonitoring(limiter.Handler(noop), noop)
+	get := func(ip, path string) int {
+		req := httptest.NewRequest("GET", path, nil)
+		req.RemoteAddr = ip + ":4242"
+		resp := httptest.NewRecorder()
+		handler.ServeHTTP(resp, req)
+		return resp.Code
+	}
+
+	require.Equal(t, http.StatusOK, get("10.0.0.1", "/2009-04-04/meta-data"))
+	require.Equal(t, http.StatusOK, get("10.0.0.1", "/2009-04-04/meta-data"))
+	require.Equal(t, http.StatusTooManyRequests, get("10.0.0.1", "/2009-04-04/meta-data"))
+
+	// Clients are limited individually and monitoring isn't limited.
+	require.Equal(t, http.StatusOK, get("10.0.0.2", "/2009-04-04/meta-data"))
+	require.Equal(t, http.StatusOK, get("10.0.0.1", "/metrics"))
+
+	now = now.Add(time.Second)
+	require.Equal(t, http.StatusOK, get("10.0.0.1", "/2009-04-04/meta-data"))
+
+	// Idle clients are forgotten once their burst is replenished.
+	now = now.Add(limiterSweepInterval)
+	require.Equal(t, http.StatusOK, get("10.0.0.3", "/2009-04-04/meta-data"))
+	require.Len(t, limiter.clients, 1)
+}
+
+func TestRequestLimiterInFlight(t *testing.T) {
+	limiter := newRequestLimiter(RequestLimits{MaxInFlight: 1})
+
+	started := make(chan struct{})
+	release := make(chan struct{})
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/slow" {
+			close(started)
+			<-release
+		}
+	})
+	handler := exceptMonitoring(limiter.Handler(next), next)
+	get := func(path string) int {
+		req := httptest.NewRequest("GET", path, nil)
+		req.RemoteAddr = mock.UserIP
+		resp := httptest.NewRecorder()
+		handler.ServeHTTP(resp, req)
+		return resp.Code
+	}
+
+	done := make(chan int)
+	go func() { done <- get("/slow") }()
+	<-started
+
+	require.Equal(t, http.StatusServiceUnavailable, get("/2009-04-04/meta-data"))
+	require.Equal(t, http.StatusOK, get("/events"))
+	require.Equal(t, http.StatusOK, get("/computeMetadata/v1/instance/hostname?wait_for_change=true"))
+	require.Equal(t, http.StatusServiceUnavailable, get("/computeMetadata/v1/instance/hostname"))
+	require.Equal(t, http.StatusOK, get("/_packet/healthcheck"))
+
+	close(release)
+	require.Equal(t, http.StatusOK, <-done)
+	require.Equal(t, http.StatusOK, get("/2009-04-04/meta-data"))
+}
@@ -0,0 +1,276 @@
+package http
+
+import (
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/packethost/pkg/log"
+	"github.com/pkg/errors"
+	"github.com/tinkerbell/hegel/hardware"
+	"github.com/tinkerbell/hegel/metrics"
+	"sigs.k8s.io/yaml"
+)
+
+// bondModes maps Linux bonding modes to their names in network-config.
+var bondModes = map[int]string{
+	0: "balance-rr",
+	1: "active-backup",
+	2: "balance-xor",
+	3: "broadcast",
+	4: "802.3ad",
+	5: "balance-tlb",
+	6: "balance-alb",
+}
+
+// networkConfig is a cloud-init network-config version 2 document.
+type networkConfig struct {
+	Version   int                              `json:"version"`
+	Ethernets map[string]networkConfigEthernet `json:"ethernets"`
+	Bonds     map[string]networkConfigBond     `json:"bonds,omitempty"`
+}
+
+// networkConfigDevice is the addressing of an ethernet or bond.
+type networkConfigDevice struct {
+	DHCP4     bool                 `json:"dhcp4,omitempty"`
+	Addresses []string             `json:"addresses,omitempty"`
+	Routes    []networkConfigRoute `json:"routes,omitempty"`
+}
+
+type networkConfigEthernet struct {
+	Match   networkConfigMatch `json:"match"`
+	SetName string             `json:"set-name"`
+	networkConfigDevice
+}
+
+type networkConfigMatch struct {
+	MACAddress string `json:"macaddress"`
+}
+
+type networkConfigBond struct {
+	Interfaces []string                    `json:"interfaces"`
+	Parameters networkConfigBondParameters `json:"parameters"`
+	networkConfigDevice
+}
+
+type networkConfigBondParameters struct {
+	Mode               string `json:"mode,omitempty"`
+	LACPRate           string `json:"lacp-rate,omitempty"`
+	MIIMonitorInterval int    `json:"mii-monitor-interval,omitempty"`
+	TransmitHashPolicy string `json:"transmit-hash-policy,omitempty"`
+}
+
+type networkConfigRoute struct {
+	To  string `json:"to"`
+	Via string `json:"via"`
+}
+
+// networkConfigAddress is an address to configure on a device.
+type networkConfigAddress struct {
+	device  string
+	address string
+	prefix  int
+	gateway string
+	public  bool
+}
+
+// cidr returns the address in CIDR notation. Without a prefix IPv4 addresses are treated as a /32 and IPv6 addresses
+// as a /64.
+func (a networkConfigAddress) cidr() string {
+	switch {
+	case a.prefix > 0:
+		return a.address + "/" + strconv.Itoa(a.prefix)
+	case strings.Contains(a.address, ":"):
+		return a.address + "/64"
+	default:
+		return a.address + "/32"
+	}
+}
+
+// newNetworkConfig builds the network-config of hw from its interfaces and instance addresses. Each interface is
+// matched by MAC. Without a bonding mode interfaces are configured with their own address, or DHCP when they have
+// none, and instance addresses that aren't assigned to an interface are added to the first interface. With a bonding
+// mode all interfaces are enslaved to bond0 which carries every address. A default route is added per address family
+// using the gateway of a public address if there is one. Hardware without interfaces has no network-config, nil is
+// returned, so clients fall back to their default network configuration.
+func newNetworkConfig(hw *exportedHardware) *networkConfig {
+	interfaces := hw.interfaces()
+	if len(interfaces) == 0 {
+		return nil
+	}
+	mode := hw.bondingMode()
+
+	var addresses []networkConfigAddress
+	add := func(address networkConfigAddress) {
+		if mode != 0 {
+			address.device = "bond0"
+		}
+		for _, a := range addresses {
+			if a.address == address.address {
+				return
+			}
+		}
+		addresses = append(addresses, address)
+	}
+
+	for i, iface := range interfaces {
+		if iface.Address == "" {
+			continue
+		}
+		prefix, _ := prefixLength(iface.Netmask)
+		add(networkConfigAddress{device: "eth" + strconv.Itoa(i), address: iface.Address, prefix: prefix, gateway: iface.Gateway})
+	}
+	for _, address := range append(hw.Metadata.Instance.Network.Addresses, hw.Instance.IPAddresses...) {
+		if address.Address == "" || (address.Enabled != nil && !*address.Enabled) {
+			continue
+		}
+		prefix, _ := prefixLength(address.Netmask)
+		if address.CIDR != nil {
+			prefix = *address.CIDR
+		}
+		add(networkConfigAddress{device: "eth0", address: address.Address, prefix: prefix, gateway: address.Gateway, public: address.Public})
+	}
+
+	// Default routes prefer the gateway of a public address.
+	var routes []networkConfigAddress
+	for _, v6 := range []bool{false, true} {
+		for _, public := range []bool{true, false} {
+			if route, ok := networkConfigDefaultRoute(addresses, v6, public); ok {
+				routes = append(routes, route)
+				break
+			}
+		}
+	}
+
+	device := func(name string) networkConfigDevice {
+		var d networkConfigDevice
+		for _, address := range addresses {
+			if address.device == name {
+				d.Addresses = append(d.Addresses, address.cidr())
+			}
+		}
+		d.DHCP4 = len(d.Addresses) == 0
+
+		for _, route := range routes {
+			if route.device != name {
+				continue
+			}
+			to := "0.0.0.0/0"
+			if strings.Contains(route.address, ":") {
+				to = "::/0"
+			}
+			d.Routes = append(d.Routes, networkConfigRoute{To: to, Via: route.gateway})
+		}
+		return d
+	}
+
+	config := &networkConfig{Version: 2, Ethernets: make(map[string]networkConfigEthernet, len(interfaces))}
+	names := make([]string, 0, len(interfaces))
+	for i, iface := range interfaces {
+		name := "eth" + strconv.Itoa(i)
+		names = append(names, name)
+
+		ethernet := networkConfigEthernet{Match: networkConfigMatch{MACAddress: iface.MAC}, SetName: name}
+		if mode == 0 {
+			ethernet.networkConfigDevice = device(name)
+		}
+		config.Ethernets[name] = ethernet
+	}
+
+	if mode != 0 {
+		sort.Strings(names)
+		bond := networkConfigBond{
+			Interfaces:          names,
+			Parameters:          networkConfigBondParameters{Mode: bondModes[mode]},
+			networkConfigDevice: device("bond0"),
+		}
+		if mode == 4 {
+			bond.Parameters.LACPRate = "fast"
+			bond.Parameters.MIIMonitorInterval = 100
+			bond.Parameters.TransmitHashPolicy = "layer3+4"
+		}
+		config.Bonds = map[string]networkConfigBond{"bond0": bond}
+	}
+
+	return config
+}
+
+// networkConfigDefaultRoute returns the first address of an address family, IPv6 if v6 is set, with a gateway that's
+// public, or not, as requested.
+func networkConfigDefaultRoute(addresses []networkConfigAddress, v6, public bool) (networkConfigAddress, bool) {
+	for _, address := range addresses {
+		if address.public == public && address.gateway != "" && strings.Contains(address.address, ":") == v6 {
+			return address, true
+		}
+	}
+	return networkConfigAddress{}, false
+}
+
+// renderNetworkConfig renders hw's network-config as YAML. It returns nil if there's nothing to configure.
+func renderNetworkConfig(hw hardware.Hardware) ([]byte, error) {
+	ehw, err := hw.Export()
+	if err != nil {
+		return nil, errors.Wrap(err, "export hardware")
+	}
+
+	parsed, err := parseExportedHardware(ehw)
+	if err != nil {
+		return nil, err
+	}
+
+	config := newNetworkConfig(parsed)
+	if config == nil {
+		return nil, nil
+	}
+
+	resp, err := yaml.Marshal(config)
+	if err != nil {
+		return nil, errors.Wrap(err, "marshal network config")
+	}
+	return resp, nil
+}
+
+// NetworkConfigHandler serves the cloud-init network-config version 2 document of the requesting machine under
+// /network-config. Hardware without interfaces is reported as not found.
+func NetworkConfigHandler(logger log.Logger, client hardware.Client) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "GET" {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+
+		userIP := getIPFromRequest(r)
+		if userIP == "" {
+			logger.Info("Could not retrieve IP address")
+			return
+		}
+
+		metrics.MetadataRequests.Inc()
+		logger := logger.With("userIP", userIP)
+
+		hw, err := lookupHardware(r, client, userIP)
+		if err != nil {
+			metrics.Errors.WithLabelValues("metadata", "lookup").Inc()
+			logger.With("error", err).Info("failed to get hardware by ip")
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+
+		resp, err := renderNetworkConfig(hw)
+		if err != nil {
+			logger.With("error", err).Info("failed to render network config")
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		if resp == nil {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/yaml")
+		if _, err := w.Write(resp); err != nil {
+			logger.With("error", err).Info("failed to write response")
+		}
+	})
+}
@@ -0,0 +1,96 @@
+package http
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/packethost/pkg/log"
+	"github.com/stretchr/testify/require"
+	"github.com/tinkerbell/hegel/datamodel"
+	"github.com/tinkerbell/hegel/hardware/mock"
+)
+
+func TestNetworkConfigEndpoint(t *testing.T) {
+	logger, err := log.Init(t.Name())
+	require.NoError(t, err)
+
+	for name, test := range tinkerbellNetworkConfigTests {
+		t.Run(name, func(t *testing.T) {
+			client := mock.HardwareClient{Model: datamodel.TinkServer, Data: test.json}
+			handler := NetworkConfigHandler(logger, client)
+
+			req, err := http.NewRequest("GET", "/network-config", nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			req.RemoteAddr = mock.UserIP
+			resp := httptest.NewRecorder()
+
+			handler.ServeHTTP(resp, req)
+
+			if status := resp.Code; status != test.status {
+				t.Errorf("handler returned wrong status code: got %v want %v",
+					status, test.status)
+			}
+
+			if resp.Body.String() != test.response {
+				t.Errorf("handler returned wrong body: got %v want %v", resp.Body.String(), test.response)
+			}
+		})
+	}
+}
+
+// test cases for TestNetworkConfigEndpoint.
+var tinkerbellNetworkConfigTests = map[string]struct {
+	status   int
+	response string
+	json     string
+}{
+	"static": {
+		status: 200,
+		response: `ethernets:
+  eth0:
+    addresses:
+    - 192.168.1.5/29
+    match:
+      macaddress: ec:0d:9a:c0:01:0c
+    routes:
+    - to: 0.0.0.0/0
+      via: 192.168.1.1
+    set-name: eth0
+version: 2
+`,
+		json: mock.TinkerbellNoMetadata,
+	},
+	"bonded": {
+		status: 200,
+		response: `bonds:
+  bond0:
+    addresses:
+    - 192.168.1.5/29
+    - 139.175.86.114/31
+    - 2604:1380:1000:ca00::7/127
+    - 10.87.63.3/31
+    interfaces:
+    - eth0
+    parameters:
+      lacp-rate: fast
+      mii-monitor-interval: 100
+      mode: 802.3ad
+      transmit-hash-policy: layer3+4
+    routes:
+    - to: 0.0.0.0/0
+      via: 139.175.86.113
+    - to: ::/0
+      via: 2604:1380:1000:ca00::6
+ethernets:
+  eth0:
+    match:
+      macaddress: b4:96:91:5f:af:c0
+    set-name: eth0
+version: 2
+`,
+		json: mock.TinkerbellKantEC2,
+	},
+}
@@ -0,0 +1,156 @@
+package http
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/packethost/pkg/log"
+	"github.com/pkg/errors"
+	"github.com/tinkerbell/hegel/hardware"
+	"github.com/tinkerbell/hegel/metrics"
+	"sigs.k8s.io/yaml"
+)
+
+// nocloudFilters defines the query pattern and filters for the NoCloud endpoint relative to its prefix. The YAML
+// documents are rendered by nocloudDocuments instead.
+var nocloudFilters = map[string]string{
+	"":             `"meta-data", "network-config", "user-data", "vendor-data"`, // base path
+	"/user-data":   userdataFilter,
+	"/vendor-data": "$vendor_data", // bound by NoCloudHandler
+}
+
+// nocloudFilterCodes are the compiled nocloudFilters.
+var nocloudFilterCodes = mustCompileFilters(nocloudFilters)
+
+// nocloudDocuments render the NoCloud items that are YAML documents. Optional documents, such as network-config, are
+// nil when there's nothing to configure.
+var nocloudDocuments = map[string]func(hw *exportedHardware) interface{}{
+	"/meta-data": func(hw *exportedHardware) interface{} { return newNoCloudMetaData(hw) },
+	"/network-config": func(hw *exportedHardware) interface{} {
+		if config := newNetworkConfig(hw); config != nil {
+			return config
+		}
+		return nil
+	},
+}
+
+// nocloudMetaData is the NoCloud meta-data document.
+type nocloudMetaData struct {
+	InstanceID    string `json:"instance-id,omitempty"`
+	LocalHostname string `json:"local-hostname,omitempty"`
+}
+
+func newNoCloudMetaData(hw *exportedHardware) nocloudMetaData {
+	md := nocloudMetaData{InstanceID: hw.Metadata.Instance.ID, LocalHostname: hw.Metadata.Instance.Hostname}
+	if md.InstanceID == "" {
+		md.InstanceID = hw.ID
+	}
+	return md
+}
+
+// NoCloudHandler serves cloud-init's NoCloud datasource under prefix so machines can boot with
+// ds=nocloud-net;s=http://<hegel>/<prefix>/. vendor-data is selected from vendorData, which may be nil.
+func NoCloudHandler(logger log.Logger, client hardware.Client, prefix string, vendorData *VendorData) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "GET" {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+
+		userIP := getIPFromRequest(r)
+		if userIP == "" {
+			logger.Info("Could not retrieve IP address")
+			return
+		}
+
+		metrics.MetadataRequests.Inc()
+		logger := logger.With("userIP", userIP)
+
+		item := strings.TrimRight(strings.TrimPrefix(r.URL.Path, strings.TrimRight(prefix, "/")), "/")
+		filter, ok := nocloudFilters[item]
+		document, isDocument := nocloudDocuments[item]
+		if !ok && !isDocument {
+			logger.With("error", errors.Errorf("invalid metadata item: %v", item)).Info("failed to process nocloud query")
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+
+		hw, err := lookupHardware(r, client, userIP)
+		if err != nil {
+			metrics.Errors.WithLabelValues("metadata", "lookup").Inc()
+			logger.With("error", err).Info("failed to get hardware by ip")
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+
+		ehw, err := hw.Export()
+		if err != nil {
+			logger.With("error", err).Info("failed to export hardware")
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+
+		if isDocument {
+			resp, err := renderNoCloudDocument(ehw, document)
+			if err != nil {
+				logger.With("error", err).Info("failed to render metadata")
+				w.WriteHeader(http.StatusInternalServerError)
+				return
+			}
+
+			// Optional documents are reported as not found when there's nothing to configure so cloud-init falls
+			// back to its defaults.
+			if resp == nil {
+				w.WriteHeader(http.StatusNotFound)
+				return
+			}
+
+			w.Header().Set("Content-Type", "application/yaml")
+			if _, err := w.Write(resp); err != nil {
+				logger.With("error", err).Info("failed to write response")
+			}
+			return
+		}
+
+		selected, err := vendorData.Select(ehw)
+		if err != nil {
+			logger.With("error", err).Info("failed to select vendor data")
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+
+		resp, err := filterMetadata(ehw, nocloudFilterCodes[item], filterArgs{VendorData: selected})
+		if err != nil {
+			logger.With("error", err).Info("failed to filter metadata")
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		if filter == userdataFilter {
+			resp = renderUserdata(logger, hw, ehw, resp)
+		}
+
+		if _, err := w.Write(resp); err != nil {
+			logger.With("error", err).Info("failed to write response")
+		}
+	})
+}
+
+// renderNoCloudDocument renders the document built by document from the exported hardware as YAML. nil is returned
+// if there's no document.
+func renderNoCloudDocument(ehw []byte, document func(*exportedHardware) interface{}) ([]byte, error) {
+	hw, err := parseExportedHardware(ehw)
+	if err != nil {
+		return nil, err
+	}
+
+	doc := document(hw)
+	if doc == nil {
+		return nil, nil
+	}
+
+	resp, err := yaml.Marshal(doc)
+	if err != nil {
+		return nil, errors.Wrap(err, "marshal document")
+	}
+	return resp, nil
+}
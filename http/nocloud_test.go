@@ -0,0 +1,123 @@
+package http
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/packethost/pkg/log"
+	"github.com/stretchr/testify/require"
+	"github.com/tinkerbell/hegel/datamodel"
+	"github.com/tinkerbell/hegel/hardware/mock"
+)
+
+func TestNoCloudEndpoint(t *testing.T) {
+	logger, err := log.Init(t.Name())
+	require.NoError(t, err)
+
+	for name, test := range tinkerbellNoCloudTests {
+		t.Run(name, func(t *testing.T) {
+			client := mock.HardwareClient{Model: datamodel.TinkServer, Data: test.json}
+			handler := NoCloudHandler(logger, client, "/nocloud/", nil)
+
+			req, err := http.NewRequest("GET", test.url, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			req.RemoteAddr = mock.UserIP
+			resp := httptest.NewRecorder()
+
+			handler.ServeHTTP(resp, req)
+
+			if status := resp.Code; status != test.status {
+				t.Errorf("handler returned wrong status code: got %v want %v",
+					status, test.status)
+			}
+
+			if resp.Body.String() != test.response {
+				t.Errorf("handler returned wrong body: got %v want %v", resp.Body.String(), test.response)
+			}
+		})
+	}
+}
+
+// test cases for TestNoCloudEndpoint.
+var tinkerbellNoCloudTests = map[string]struct {
+	url      string
+	status   int
+	response string
+	json     string
+}{
+	"base": {
+		url:    "/nocloud/",
+		status: 200,
+		response: `meta-data
+network-config
+user-data
+vendor-data`,
+		json: mock.TinkerbellKantEC2,
+	},
+	"meta-data": {
+		url:    "/nocloud/meta-data",
+		status: 200,
+		response: `instance-id: 7c9a5711-aadd-4fa0-8e57-789431626a27
+local-hostname: tink-provisioner
+`,
+		json: mock.TinkerbellKantEC2,
+	},
+	"meta-data without metadata": {
+		url:      "/nocloud/meta-data",
+		status:   200,
+		response: "instance-id: 363115b0-f03d-4ce5-9a15-5514193d131a\n",
+		json:     mock.TinkerbellNoMetadata,
+	},
+	"user-data": {
+		url:    "/nocloud/user-data",
+		status: 200,
+		response: `#!/bin/bash
+
+echo "Hello world!"`,
+		json: mock.TinkerbellKantEC2,
+	},
+	"vendor-data": {
+		url:    "/nocloud/vendor-data",
+		status: 200,
+		json:   mock.TinkerbellKantEC2,
+	},
+	"network-config": {
+		url:    "/nocloud/network-config",
+		status: 200,
+		response: `bonds:
+  bond0:
+    addresses:
+    - 192.168.1.5/29
+    - 139.175.86.114/31
+    - 2604:1380:1000:ca00::7/127
+    - 10.87.63.3/31
+    interfaces:
+    - eth0
+    parameters:
+      lacp-rate: fast
+      mii-monitor-interval: 100
+      mode: 802.3ad
+      transmit-hash-policy: layer3+4
+    routes:
+    - to: 0.0.0.0/0
+      via: 139.175.86.113
+    - to: ::/0
+      via: 2604:1380:1000:ca00::6
+ethernets:
+  eth0:
+    match:
+      macaddress: b4:96:91:5f:af:c0
+    set-name: eth0
+version: 2
+`,
+		json: mock.TinkerbellKantEC2,
+	},
+	"invalid item": {
+		url:    "/nocloud/instance-id",
+		status: 404,
+		json:   mock.TinkerbellKantEC2,
+	},
+}
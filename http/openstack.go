@@ -0,0 +1,255 @@
+package http
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/itchyny/gojq"
+	"github.com/packethost/pkg/log"
+	"github.com/pkg/errors"
+	"github.com/tinkerbell/hegel/hardware"
+	"github.com/tinkerbell/hegel/metrics"
+)
+
+// openstackFilters defines the query pattern and filters for the OpenStack endpoint. As with ec2Filters, queries that
+// return a list of metadata items use a directory-listing filter. Documents built from the hardware's network
+// interfaces and instance are rendered by openstackDocuments instead.
+// NOTE: make sure when adding a new metadata item to also add it to the directory-listing filter.
+var openstackFilters = map[string]string{
+	"":                         `"latest"`, // base path
+	"/latest":                  `"meta_data.json", "network_data.json", "user_data", "vendor_data.json"`,
+	"/latest/user_data":        userdataFilter,
+	"/latest/vendor_data.json": `if $vendor_data == "" then {} else {"cloud-init": $vendor_data} end`, // bound by OpenStackMetadataHandler
+}
+
+// openstackFilterCodes are the compiled openstackFilters.
+var openstackFilterCodes = mustCompileFilters(openstackFilters)
+
+// openstackDocuments render the JSON documents of the OpenStack endpoint that aren't served by a filter.
+var openstackDocuments = map[string]func(hw *exportedHardware) interface{}{
+	"/latest/meta_data.json":    func(hw *exportedHardware) interface{} { return newOpenStackMetaData(hw) },
+	"/latest/network_data.json": func(hw *exportedHardware) interface{} { return newOpenStackNetworkData(hw) },
+}
+
+// openstackMetaData is meta_data.json. OpenStack only defines string values for meta so tags are joined.
+type openstackMetaData struct {
+	UUID             string            `json:"uuid,omitempty"`
+	Name             string            `json:"name,omitempty"`
+	Hostname         string            `json:"hostname,omitempty"`
+	AvailabilityZone string            `json:"availability_zone,omitempty"`
+	LaunchIndex      int               `json:"launch_index"`
+	PublicKeys       map[string]string `json:"public_keys"`
+	Keys             []openstackKey    `json:"keys"`
+	Meta             map[string]string `json:"meta"`
+}
+
+type openstackKey struct {
+	Name string `json:"name"`
+	Type string `json:"type"`
+	Data string `json:"data"`
+}
+
+// openstackNetworkData is network_data.json with a physical link per interface and a static network for each
+// interface with an address.
+type openstackNetworkData struct {
+	Links    []openstackLink    `json:"links"`
+	Networks []openstackNetwork `json:"networks"`
+	Services []struct{}         `json:"services"`
+}
+
+type openstackLink struct {
+	ID                 string `json:"id"`
+	Type               string `json:"type"`
+	EthernetMACAddress string `json:"ethernet_mac_address"`
+}
+
+type openstackNetwork struct {
+	ID        string           `json:"id"`
+	Link      string           `json:"link"`
+	Type      string           `json:"type"`
+	IPAddress string           `json:"ip_address"`
+	Netmask   string           `json:"netmask,omitempty"`
+	Routes    []openstackRoute `json:"routes"`
+}
+
+type openstackRoute struct {
+	Network string `json:"network"`
+	Netmask string `json:"netmask"`
+	Gateway string `json:"gateway"`
+}
+
+func newOpenStackMetaData(hw *exportedHardware) openstackMetaData {
+	instance := hw.Metadata.Instance
+	md := openstackMetaData{
+		UUID:             instance.ID,
+		Name:             instance.Hostname,
+		Hostname:         instance.Hostname,
+		AvailabilityZone: hw.facility(),
+		PublicKeys:       map[string]string{},
+		Keys:             []openstackKey{},
+		Meta:             map[string]string{},
+	}
+
+	for i, key := range instance.SSHKeys {
+		name := "key-" + strconv.Itoa(i)
+		md.PublicKeys[name] = key
+		md.Keys = append(md.Keys, openstackKey{Name: name, Type: "ssh", Data: key})
+	}
+
+	if instance.Plan != "" {
+		md.Meta["plan"] = instance.Plan
+	}
+	if tags := strings.Join(instance.Tags, ","); tags != "" {
+		md.Meta["tags"] = tags
+	}
+
+	return md
+}
+
+func newOpenStackNetworkData(hw *exportedHardware) openstackNetworkData {
+	nd := openstackNetworkData{
+		Links:    []openstackLink{},
+		Networks: []openstackNetwork{},
+		Services: []struct{}{},
+	}
+
+	for i, iface := range hw.interfaces() {
+		link := "interface" + strconv.Itoa(i)
+		nd.Links = append(nd.Links, openstackLink{ID: link, Type: "phy", EthernetMACAddress: iface.MAC})
+
+		if iface.Address == "" {
+			continue
+		}
+
+		network := openstackNetwork{
+			ID:        "network" + strconv.Itoa(i),
+			Link:      link,
+			Type:      "ipv4",
+			IPAddress: iface.Address,
+			Netmask:   iface.Netmask,
+			Routes:    []openstackRoute{},
+		}
+		anyAddress := "0.0.0.0"
+		if strings.Contains(iface.Address, ":") {
+			network.Type = "ipv6"
+			anyAddress = "::"
+		}
+		if iface.Gateway != "" {
+			network.Routes = append(network.Routes, openstackRoute{Network: anyAddress, Netmask: anyAddress, Gateway: iface.Gateway})
+		}
+		nd.Networks = append(nd.Networks, network)
+	}
+
+	return nd
+}
+
+// OpenStackMetadataHandler serves the OpenStack metadata service format under /openstack so images configured with the
+// OpenStack datasource can retrieve their metadata. vendor_data.json is selected from vendorData, which may be nil.
+func OpenStackMetadataHandler(logger log.Logger, client hardware.Client, vendorData *VendorData) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "GET" {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+
+		userIP := getIPFromRequest(r)
+		if userIP == "" {
+			logger.Info("Could not retrieve IP address")
+			return
+		}
+
+		metrics.MetadataRequests.Inc()
+		logger := logger.With("userIP", userIP)
+
+		filter, code, document, err := processOpenStackQuery(r.URL.Path)
+		if err != nil {
+			logger.With("error", err).Info("failed to process openstack query")
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+
+		hw, err := lookupHardware(r, client, userIP)
+		if err != nil {
+			metrics.Errors.WithLabelValues("metadata", "lookup").Inc()
+			logger.With("error", err).Info("failed to get hardware by ip")
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+
+		ehw, err := hw.Export()
+		if err != nil {
+			logger.With("error", err).Info("failed to export hardware")
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+
+		selected, err := vendorData.Select(ehw)
+		if err != nil {
+			logger.With("error", err).Info("failed to select vendor data")
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+
+		var resp []byte
+		if document != nil {
+			resp, err = renderOpenStackDocument(ehw, document)
+		} else {
+			resp, err = filterMetadata(ehw, code, filterArgs{VendorData: selected})
+		}
+		if err != nil {
+			logger.With("error", err).Info("failed to render metadata")
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		if filter == userdataFilter {
+			resp = renderUserdata(logger, hw, ehw, resp)
+		}
+
+		// OpenStack reports missing user data as not found rather than an empty document.
+		if len(resp) == 0 {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+
+		if strings.HasSuffix(r.URL.Path, ".json") {
+			w.Header().Set("Content-Type", "application/json")
+		}
+
+		if _, err := w.Write(resp); err != nil {
+			logger.With("error", err).Info("failed to write response")
+		}
+	})
+}
+
+// processOpenStackQuery returns either the filter, along with its compiled code, or the document renderer for an
+// OpenStack metadata path.
+func processOpenStackQuery(url string) (string, *gojq.Code, func(*exportedHardware) interface{}, error) {
+	query := strings.TrimRight(strings.TrimPrefix(url, "/openstack"), "/")
+
+	if document, ok := openstackDocuments[query]; ok {
+		return "", nil, document, nil
+	}
+
+	filter, ok := openstackFilters[query]
+	if !ok {
+		return "", nil, nil, errors.Errorf("invalid metadata item: %v", query)
+	}
+
+	return filter, openstackFilterCodes[query], nil, nil
+}
+
+// renderOpenStackDocument renders the document built by document from the exported hardware as JSON.
+func renderOpenStackDocument(ehw []byte, document func(*exportedHardware) interface{}) ([]byte, error) {
+	hw, err := parseExportedHardware(ehw)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := json.Marshal(document(hw))
+	if err != nil {
+		return nil, errors.Wrap(err, "marshal document")
+	}
+	return resp, nil
+}
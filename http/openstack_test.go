@@ -0,0 +1,102 @@
+package http
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/packethost/pkg/log"
+	"github.com/stretchr/testify/require"
+	"github.com/tinkerbell/hegel/datamodel"
+	"github.com/tinkerbell/hegel/hardware/mock"
+)
+
+func TestOpenStackEndpoint(t *testing.T) {
+	logger, err := log.Init(t.Name())
+	require.NoError(t, err)
+
+	for name, test := range tinkerbellOpenStackTests {
+		t.Run(name, func(t *testing.T) {
+			client := mock.HardwareClient{Model: datamodel.TinkServer, Data: test.json}
+			handler := OpenStackMetadataHandler(logger, client, nil)
+
+			req, err := http.NewRequest("GET", test.url, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			req.RemoteAddr = mock.UserIP
+			resp := httptest.NewRecorder()
+
+			handler.ServeHTTP(resp, req)
+
+			if status := resp.Code; status != test.status {
+				t.Errorf("handler returned wrong status code: got %v want %v",
+					status, test.status)
+			}
+
+			if resp.Body.String() != test.response {
+				t.Errorf("handler returned wrong body: got %v want %v", resp.Body.String(), test.response)
+			}
+		})
+	}
+}
+
+// test cases for TestOpenStackEndpoint.
+var tinkerbellOpenStackTests = map[string]struct {
+	url      string
+	status   int
+	response string
+	json     string
+}{
+	"base": {
+		url:      "/openstack",
+		status:   200,
+		response: "latest",
+		json:     mock.TinkerbellKantEC2,
+	},
+	"latest": {
+		url:    "/openstack/latest/",
+		status: 200,
+		response: `meta_data.json
+network_data.json
+user_data
+vendor_data.json`,
+		json: mock.TinkerbellKantEC2,
+	},
+	"meta_data.json": {
+		url:      "/openstack/latest/meta_data.json",
+		status:   200,
+		response: `{"uuid":"7c9a5711-aadd-4fa0-8e57-789431626a27","name":"tink-provisioner","hostname":"tink-provisioner","availability_zone":"sjc1","launch_index":0,"public_keys":{},"keys":[],"meta":{"plan":"c3.small.x86","tags":"hello,test"}}`,
+		json:     mock.TinkerbellKantEC2,
+	},
+	"network_data.json": {
+		url:      "/openstack/latest/network_data.json",
+		status:   200,
+		response: `{"links":[{"id":"interface0","type":"phy","ethernet_mac_address":"b4:96:91:5f:af:c0"}],"networks":[{"id":"network0","link":"interface0","type":"ipv4","ip_address":"192.168.1.5","netmask":"255.255.255.248","routes":[{"network":"0.0.0.0","netmask":"0.0.0.0","gateway":"192.168.1.1"}]}],"services":[]}`,
+		json:     mock.TinkerbellKantEC2,
+	},
+	"user_data": {
+		url:    "/openstack/latest/user_data",
+		status: 200,
+		response: `#!/bin/bash
+
+echo "Hello world!"`,
+		json: mock.TinkerbellKantEC2,
+	},
+	"user_data missing": {
+		url:    "/openstack/latest/user_data",
+		status: 404,
+		json:   mock.TinkerbellNoMetadata,
+	},
+	"vendor_data.json": {
+		url:      "/openstack/latest/vendor_data.json",
+		status:   200,
+		response: "{}",
+		json:     mock.TinkerbellKantEC2,
+	},
+	"invalid query": {
+		url:    "/openstack/2012-08-10/meta_data.json",
+		status: 404,
+		json:   mock.TinkerbellKantEC2,
+	},
+}
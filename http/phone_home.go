@@ -0,0 +1,177 @@
+package http
+
+import (
+	"encoding/json"
+	"mime"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/packethost/pkg/log"
+	"github.com/pkg/errors"
+	"github.com/tinkerbell/hegel/hardware"
+	"github.com/tinkerbell/hegel/metrics"
+)
+
+// maxPhoneHomePayload bounds the size of phone home request bodies.
+const maxPhoneHomePayload = 64 << 10
+
+// PhoneHomeStore records the phone home reports machines send once they finish booting. The last report of each
+// machine is kept in memory and, if the hardware client implements hardware.PhoneHomeRecorder, persisted with the
+// hardware.
+type PhoneHomeStore struct {
+	now func() time.Time
+
+	mu      sync.Mutex
+	reports map[string]hardware.PhoneHome
+}
+
+// NewPhoneHomeStore creates an empty PhoneHomeStore.
+func NewPhoneHomeStore() *PhoneHomeStore {
+	return &PhoneHomeStore{
+		now:     time.Now,
+		reports: make(map[string]hardware.PhoneHome),
+	}
+}
+
+// Get returns the last report of the hardware with id.
+func (s *PhoneHomeStore) Get(id string) (hardware.PhoneHome, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	report, ok := s.reports[id]
+	return report, ok
+}
+
+// List returns the last report of every hardware that phoned home indexed by hardware ID.
+func (s *PhoneHomeStore) List() map[string]hardware.PhoneHome {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	reports := make(map[string]hardware.PhoneHome, len(s.reports))
+	for id, report := range s.reports {
+		reports[id] = report
+	}
+	return reports
+}
+
+// PhoneHomeHandler serves POST /phone-home recording the report of the requesting machine. It accepts the form
+// encoded payload sent by cloud-init's phone_home module as well as a JSON object of strings.
+func (s *PhoneHomeStore) PhoneHomeHandler(logger log.Logger, client hardware.Client) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+
+		userIP := getIPFromRequest(r)
+		if userIP == "" {
+			logger.Info("Could not retrieve IP address")
+			return
+		}
+
+		w.WriteHeader(s.handle(logger, w, r, client, userIP))
+	})
+}
+
+// AdminHandler serves the recorded reports as JSON. The report of a single machine is served when the id query
+// parameter is set. Reports include the machines' addresses and payloads so it must not be served to machines.
+func (s *PhoneHomeStore) AdminHandler(logger log.Logger) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "GET" {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+
+		var payload interface{} = s.List()
+		if id := r.URL.Query().Get("id"); id != "" {
+			report, ok := s.Get(id)
+			if !ok {
+				w.WriteHeader(http.StatusNotFound)
+				return
+			}
+			payload = report
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		if err := writeJSONResponse(w, http.StatusOK, payload); err != nil {
+			logger.With("error", err).Info("failed to write response")
+		}
+	})
+}
+
+func (s *PhoneHomeStore) v0PhoneHomeHandler(logger log.Logger, client hardware.Client) gin.HandlerFunc {
+	fn := func(c *gin.Context) {
+		c.Status(s.handle(logger, c.Writer, c.Request, client, c.ClientIP()))
+	}
+	return gin.HandlerFunc(fn)
+}
+
+// handle records the report in r sent by the machine with ip and returns the status code to respond with.
+func (s *PhoneHomeStore) handle(logger log.Logger, w http.ResponseWriter, r *http.Request, client hardware.Client, ip string) int {
+	metrics.MetadataRequests.Inc()
+	logger = logger.With("userIP", ip)
+
+	hw, err := lookupHardware(r, client, ip)
+	if err != nil {
+		metrics.Errors.WithLabelValues("metadata", "lookup").Inc()
+		logger.With("error", err).Info("failed to get hardware by ip")
+		return http.StatusNotFound
+	}
+
+	id, err := hw.ID()
+	if err != nil {
+		logger.With("error", err).Info("failed to get hardware id")
+		return http.StatusInternalServerError
+	}
+
+	r.Body = http.MaxBytesReader(w, r.Body, maxPhoneHomePayload)
+	payload, err := parsePhoneHomePayload(r)
+	if err != nil {
+		logger.With("error", err).Info("failed to parse phone home payload")
+		return http.StatusBadRequest
+	}
+
+	report := hardware.PhoneHome{Time: s.now(), IP: ip, Payload: payload}
+
+	s.mu.Lock()
+	s.reports[id] = report
+	s.mu.Unlock()
+
+	metrics.PhoneHomes.Inc()
+	logger.With("hardwareID", id).Info("machine phoned home")
+
+	// The report is already recorded in memory so failing to persist it isn't reported to the machine; it has no way to
+	// handle it.
+	if recorder, ok := client.(hardware.PhoneHomeRecorder); ok {
+		if err := recorder.RecordPhoneHome(r.Context(), id, report); err != nil {
+			metrics.Errors.WithLabelValues("phone-home", "record").Inc()
+			logger.With("error", err, "hardwareID", id).Info("failed to record phone home")
+		}
+	}
+
+	return http.StatusOK
+}
+
+// parsePhoneHomePayload parses the form encoded or JSON payload of a phone home request. Only the first value of
+// repeated form fields is kept.
+func parsePhoneHomePayload(r *http.Request) (map[string]string, error) {
+	if mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type")); mediaType == "application/json" {
+		var payload map[string]string
+		if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+			return nil, errors.Wrap(err, "decode json payload")
+		}
+		return payload, nil
+	}
+
+	if err := r.ParseForm(); err != nil {
+		return nil, errors.Wrap(err, "parse form payload")
+	}
+
+	payload := make(map[string]string, len(r.PostForm))
+	for key, values := range r.PostForm {
+		payload[key] = values[0]
+	}
+	return payload, nil
+}
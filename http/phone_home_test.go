@@ -0,0 +1,94 @@
+package http
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/packethost/pkg/log"
+	"github.com/stretchr/testify/require"
+	"github.com/tinkerbell/hegel/datamodel"
+	"github.com/tinkerbell/hegel/hardware"
+	"github.com/tinkerbell/hegel/hardware/mock"
+)
+
+func TestPhoneHome(t *testing.T) {
+	logger, err := log.Init(t.Name())
+	require.NoError(t, err)
+
+	const id = "0eba0bf8-3772-4b4a-ab9f-6ebe93b90a94"
+	now := time.Date(2022, 1, 2, 3, 4, 5, 0, time.UTC)
+
+	for name, test := range phoneHomeTests {
+		t.Run(name, func(t *testing.T) {
+			client := mock.HardwareClient{Model: datamodel.TinkServer, Data: mock.TinkerbellKantEC2}
+			store := NewPhoneHomeStore()
+			store.now = func() time.Time { return now }
+
+			req, err := http.NewRequest(test.method, "/phone-home", strings.NewReader(test.body))
+			require.NoError(t, err)
+			req.Header.Set("Content-Type", test.contentType)
+			req.RemoteAddr = mock.UserIP
+			resp := httptest.NewRecorder()
+
+			store.PhoneHomeHandler(logger, client).ServeHTTP(resp, req)
+			require.Equal(t, test.status, resp.Code)
+
+			report, ok := store.Get(id)
+			if test.status != http.StatusOK {
+				require.False(t, ok)
+				return
+			}
+			require.True(t, ok)
+			require.Equal(t, hardware.PhoneHome{Time: now, IP: mock.UserIP, Payload: test.payload}, report)
+
+			req, err = http.NewRequest(http.MethodGet, "/admin/phone-home?id="+id, nil)
+			require.NoError(t, err)
+			resp = httptest.NewRecorder()
+
+			store.AdminHandler(logger).ServeHTTP(resp, req)
+			require.Equal(t, http.StatusOK, resp.Code)
+
+			var served hardware.PhoneHome
+			require.NoError(t, json.Unmarshal(resp.Body.Bytes(), &served))
+			require.Equal(t, report, served)
+		})
+	}
+}
+
+// test cases for TestPhoneHome.
+var phoneHomeTests = map[string]struct {
+	method      string
+	contentType string
+	body        string
+	status      int
+	payload     map[string]string
+}{
+	"form": {
+		method:      http.MethodPost,
+		contentType: "application/x-www-form-urlencoded",
+		body:        "instance_id=0eba0bf8&hostname=server001&hostname=ignored",
+		status:      http.StatusOK,
+		payload:     map[string]string{"instance_id": "0eba0bf8", "hostname": "server001"},
+	},
+	"json": {
+		method:      http.MethodPost,
+		contentType: "application/json; charset=utf-8",
+		body:        `{"boot": "done"}`,
+		status:      http.StatusOK,
+		payload:     map[string]string{"boot": "done"},
+	},
+	"invalid json": {
+		method:      http.MethodPost,
+		contentType: "application/json",
+		body:        `{"boot": 1}`,
+		status:      http.StatusBadRequest,
+	},
+	"get": {
+		method: http.MethodGet,
+		status: http.StatusMethodNotAllowed,
+	},
+}
@@ -5,12 +5,14 @@ import (
 	"encoding/json"
 	"fmt"
 	"net/http"
+	"strings"
 	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/packethost/pkg/log"
 	"github.com/pkg/errors"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/tinkerbell/hegel/allowlist"
 	"github.com/tinkerbell/hegel/datamodel"
 	"github.com/tinkerbell/hegel/grpc"
 	"github.com/tinkerbell/hegel/hardware"
@@ -18,69 +20,270 @@ import (
 	"go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
 )
 
-func Serve(
-	ctx context.Context,
-	logger log.Logger,
-	client hardware.Client,
-	grpcsrv *grpc.Server,
-	port int,
-	start time.Time,
-	model datamodel.DataModel,
-	customEndpoints string,
-	unparsedProxies string,
-	hegelAPI bool,
-) error {
+// ServerConfig configures the HTTP server started by Serve.
+type ServerConfig struct {
+	// Port is the port to listen on.
+	Port int
+
+	// Start is when Hegel started. It's reported by the health check.
+	Start time.Time
+
+	// Model is the data model hardware is retrieved with.
+	Model datamodel.DataModel
+
+	// CustomEndpoints is a JSON object mapping paths to jq filters. It's ignored when CustomEndpointsFile is set.
+	CustomEndpoints string
+
+	// CustomEndpointsFile is the path of a custom endpoints config file, see CustomEndpoints.
+	CustomEndpointsFile string
+
+	// TrustedProxies is a comma separated list of IPs and CIDRs whose X-Forwarded-For headers are trusted.
+	TrustedProxies string
+
+	// AllowedSources is a comma separated list of IPs and CIDRs allowed to make requests, once X-Forwarded-For is
+	// resolved. Empty allows all sources. Monitoring endpoints and the admin API are served regardless.
+	AllowedSources string
+
+	// MetadataAPI enables the EC2, OpenStack, GCE and NoCloud compatible metadata APIs and the endpoints served
+	// alongside them.
+	MetadataAPI bool
+
+	// HegelAPI enables Hegel's experimental API under /v0.
+	HegelAPI bool
+
+	// EC2TokenMode is whether EC2 metadata requests must carry an IMDSv2 session token.
+	EC2TokenMode EC2TokenMode
+
+	// IdentitySigner signs EC2 instance identity documents. It may be nil.
+	IdentitySigner *InstanceIdentitySigner
+
+	// NoCloudPrefix is the path the NoCloud datasource is served under. Empty disables it.
+	NoCloudPrefix string
+
+	// VendorData is the cloud-init vendor-data served to machines. It may be nil.
+	VendorData *VendorData
+
+	// Admin configures the admin API served on its own port. It's disabled when Admin.Port is 0.
+	Admin AdminConfig
+
+	// Limits are the rate and concurrency limits applied to requests. The admin API isn't limited.
+	Limits RequestLimits
+
+	// ShutdownTimeout is how long in-flight requests may take to finish once Serve's context is done before their
+	// connections are closed. Event streams are ended straight away so their clients reconnect elsewhere.
+	ShutdownTimeout time.Duration
+}
+
+// Serve serves the APIs enabled in config until ctx is done and then shuts down gracefully, see
+// ServerConfig.ShutdownTimeout. Monitoring, health check, subscription and custom endpoints are served regardless of
+// the APIs enabled.
+func Serve(ctx context.Context, logger log.Logger, client hardware.Client, grpcsrv *grpc.Server, config ServerConfig) error {
 	logger.Info("in the http serve func")
-	var mux http.ServeMux
-	var httpHandler http.Handler
+
+	ec2Tokens := NewEC2TokenStore(config.EC2TokenMode)
+	phoneHomes := NewPhoneHomeStore()
+	mux := newRouter(logger, client, grpcsrv, ec2Tokens, phoneHomes, config)
+
+	// Endpoints from the config file are served as the fallback so they can be added and removed at runtime.
+	if config.CustomEndpointsFile != "" {
+		endpoints, err := NewCustomEndpoints(logger, client, config.Model, config.CustomEndpointsFile)
+		if err != nil {
+			return fmt.Errorf("load custom endpoints: %w", err)
+		}
+		defer endpoints.Close()
+		mux.Handle("/", endpoints)
+	} else {
+		err := registerCustomEndpoints(logger, client, mux, config.Model, config.CustomEndpoints)
+		if err != nil {
+			return fmt.Errorf("register custom endpoints: %w", err)
+		}
+	}
+
+	// Tokens are checked in front of every route so required mode covers all metadata formats, including custom
+	// endpoints.
+	httpHandler := ec2Tokens.RequireToken(mux)
+
+	if err := config.Limits.Validate(); err != nil {
+		return fmt.Errorf("request limits: %w", err)
+	}
+
+	allowed, err := allowlist.Parse(config.AllowedSources)
+	if err != nil {
+		return fmt.Errorf("allowed sources: %w", err)
+	}
+
+	metadata := endStreams(ctx.Done(), httpHandler)
+
+	handler := metadata
+	if config.Limits.enabled() {
+		handler = newRequestLimiter(config.Limits).Handler(handler)
+	}
+
+	// Disallowed sources are rejected before they count towards the limits or reach any backend lookup.
+	handler = allowlist.HTTPHandler(handler, allowed)
+	handler = exceptMonitoring(handler, metadata)
+
+	// Add an X-Forward-For middleware for proxies. It wraps the limits and allowed sources so clients behind proxies
+	// are handled individually.
+	proxies := xff.ParseTrustedProxies(config.TrustedProxies)
+	handler, err = xff.HTTPHandler(handler, proxies)
+	if err != nil {
+		return err
+	}
+
+	address := fmt.Sprintf(":%d", config.Port)
+	server := &http.Server{Addr: address, Handler: handler}
+	defer server.Close()
+
+	servers := []*http.Server{server}
+	errs := make(chan error, 2)
+
+	// The admin API renders metadata as seen by any machine so it's served on its own port and doesn't trust proxies.
+	if config.Admin.Port != 0 {
+		adminServer, err := newAdminServer(logger, client, metadata, phoneHomes, config.Admin)
+		if err != nil {
+			return fmt.Errorf("admin api: %w", err)
+		}
+		defer adminServer.Close()
+		servers = append(servers, adminServer)
+
+		go func() {
+			logger.With("address", adminServer.Addr).Info("Starting admin http server")
+			errs <- adminServer.ListenAndServeTLS(config.Admin.TLSCertPath, config.Admin.TLSKeyPath)
+		}()
+	}
+
+	go func() {
+		logger.With("address", address).Info("Starting http server")
+		errs <- server.ListenAndServe()
+	}()
+
+	select {
+	case <-ctx.Done():
+	case err := <-errs:
+		return err
+	}
+
+	logger.With("timeout", config.ShutdownTimeout).Info("shutting down http servers")
+	return shutdown(config.ShutdownTimeout, servers...)
+}
+
+// shutdown gracefully shuts down servers concurrently. Connections still active after timeout are left to be closed
+// by the caller.
+func shutdown(timeout time.Duration, servers ...*http.Server) error {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	errs := make(chan error, len(servers))
+	for _, server := range servers {
+		go func(server *http.Server) {
+			errs <- server.Shutdown(ctx)
+		}(server)
+	}
+
+	var err error
+	for range servers {
+		if serr := <-errs; serr != nil && err == nil {
+			err = errors.Wrap(serr, "timed out waiting for http requests to finish")
+		}
+	}
+	return err
+}
+
+// endStreams ends long lived requests, event streams and GCE wait_for_change requests, once done is closed. They'd
+// otherwise last as long as their client waits and hold up a graceful shutdown.
+func endStreams(done <-chan struct{}, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !isLongLived(r) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		ctx, cancel := context.WithCancel(r.Context())
+		defer cancel()
+
+		go func() {
+			select {
+			case <-done:
+				cancel()
+			case <-ctx.Done():
+			}
+		}()
+
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// newRouter creates a router serving the APIs enabled in config alongside the monitoring, health check and
+// subscription endpoints. Hegel's API is served by a gin router mounted under /v0. EC2 session tokens are issued by
+// ec2Tokens when either API is enabled and phone home reports are recorded in phoneHomes.
+func newRouter(logger log.Logger, client hardware.Client, grpcsrv *grpc.Server, ec2Tokens *EC2TokenStore, phoneHomes *PhoneHomeStore, config ServerConfig) *http.ServeMux {
+	mux := http.NewServeMux()
 
 	mux.Handle("/metrics", promhttp.Handler())
-	mux.Handle("/_packet/healthcheck", HealthCheckHandler(logger, client, start))
+	mux.Handle("/_packet/healthcheck", HealthCheckHandler(logger, client, config.Start))
 	mux.Handle("/_packet/version", VersionHandler(logger))
 
-	if !hegelAPI {
-		ec2MetadataHandler := otelhttp.WithRouteTag("/2009-04-04", EC2MetadataHandler(logger, client))
-		mux.Handle("/2009-04-04/", ec2MetadataHandler)
-		mux.Handle("/2009-04-04", ec2MetadataHandler)
+	if config.MetadataAPI || config.HegelAPI {
+		mux.Handle("/latest/api/token", otelhttp.WithRouteTag("/latest/api/token", ec2Tokens.TokenHandler(logger)))
+	}
 
-		httpHandler = &mux
-	} else {
+	if config.MetadataAPI {
+		registerMetadataAPI(logger, client, mux, phoneHomes, config)
+	}
+
+	if config.HegelAPI {
 		router := gin.Default()
 		router.RedirectTrailingSlash = true
 		v0 := router.Group("/v0")
-		v0HegelMetadataHandler(logger, client, v0)
+		v0HegelMetadataHandler(logger, client, config.VendorData, v0)
+		v0.POST("/phone-home", phoneHomes.v0PhoneHomeHandler(logger, client))
 
-		httpHandler = router
+		mux.Handle("/v0/", router)
 	}
 
 	subscriptionHandler := otelhttp.WithRouteTag("/subscriptions", SubscriptionsHandler(grpcsrv, logger))
 	mux.Handle("/subscriptions/", subscriptionHandler)
 	mux.Handle("/subscriptions", subscriptionHandler)
 
-	err := registerCustomEndpoints(logger, client, &mux, model, customEndpoints)
-	if err != nil {
-		return fmt.Errorf("register custom endpoints: %w", err)
-	}
+	return mux
+}
 
-	// Add an X-Forward-For middleware for proxies.
-	proxies := xff.ParseTrustedProxies(unparsedProxies)
-	handler, err := xff.HTTPHandler(httpHandler, proxies)
-	if err != nil {
-		return err
-	}
+// registerMetadataAPI registers the EC2, OpenStack, GCE and NoCloud compatible metadata APIs and the endpoints served
+// alongside them on mux.
+func registerMetadataAPI(logger log.Logger, client hardware.Client, mux *http.ServeMux, phoneHomes *PhoneHomeStore, config ServerConfig) {
+	ec2MetadataHandler := otelhttp.WithRouteTag("/2009-04-04", EC2MetadataHandler(logger, client, config.VendorData))
+	mux.Handle("/2009-04-04/", ec2MetadataHandler)
+	mux.Handle("/2009-04-04", ec2MetadataHandler)
 
-	address := fmt.Sprintf(":%d", port)
-	server := &http.Server{Addr: address, Handler: handler}
-	go func() {
-		<-ctx.Done()
+	identityHandler := otelhttp.WithRouteTag("/2009-04-04/dynamic/instance-identity", InstanceIdentityHandler(logger, client, config.IdentitySigner))
+	mux.Handle("/2009-04-04/dynamic/instance-identity/", identityHandler)
+	mux.Handle("/2009-04-04/dynamic/instance-identity", identityHandler)
 
-		// todo(chrisdoherty4) Refactor server construction and 'listen' to be separate so we can more gracefully
-		// shutdown and introduce a timeout before calling Close().
-		server.Close()
-	}()
+	openstackMetadataHandler := otelhttp.WithRouteTag("/openstack", OpenStackMetadataHandler(logger, client, config.VendorData))
+	mux.Handle("/openstack/", openstackMetadataHandler)
+	mux.Handle("/openstack", openstackMetadataHandler)
+
+	gceMetadataHandler := otelhttp.WithRouteTag("/computeMetadata/v1", GCEMetadataHandler(logger, client))
+	mux.Handle("/computeMetadata/v1/", gceMetadataHandler)
+	mux.Handle("/computeMetadata/v1", gceMetadataHandler)
 
-	logger.With("address", address).Info("Starting http server")
-	return server.ListenAndServe()
+	networkConfigHandler := otelhttp.WithRouteTag("/network-config", NetworkConfigHandler(logger, client))
+	mux.Handle("/network-config", networkConfigHandler)
+
+	ignitionHandler := otelhttp.WithRouteTag("/ignition", IgnitionHandler(logger, client))
+	mux.Handle("/ignition", ignitionHandler)
+
+	mux.Handle("/events", otelhttp.WithRouteTag("/events", EventsHandler(logger, client)))
+
+	mux.Handle("/phone-home", otelhttp.WithRouteTag("/phone-home", phoneHomes.PhoneHomeHandler(logger, client)))
+
+	if config.NoCloudPrefix != "" {
+		nocloudPrefix := strings.TrimRight(config.NoCloudPrefix, "/")
+		nocloudHandler := otelhttp.WithRouteTag(nocloudPrefix, NoCloudHandler(logger, client, nocloudPrefix, config.VendorData))
+		mux.Handle(nocloudPrefix+"/", nocloudHandler)
+		mux.Handle(nocloudPrefix, nocloudHandler)
+	}
 }
 
 func registerCustomEndpoints(logger log.Logger, client hardware.Client, mux *http.ServeMux, model datamodel.DataModel, customEndpoints string) error {
@@ -91,8 +294,11 @@ func registerCustomEndpoints(logger log.Logger, client hardware.Client, mux *htt
 	}
 
 	for endpoint, filter := range endpoints {
-		handler := otelhttp.WithRouteTag(endpoint, GetMetadataHandler(logger, client, filter, model))
-		mux.Handle(endpoint, handler)
+		handler, err := GetMetadataHandler(logger, client, filter, model)
+		if err != nil {
+			return errors.Wrapf(err, "custom endpoint %v", endpoint)
+		}
+		mux.Handle(endpoint, otelhttp.WithRouteTag(endpoint, handler))
 	}
 
 	return nil
@@ -4,15 +4,18 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
+	"net"
 	"net/http"
 	"net/http/httptest"
 	"path"
 	"reflect"
 	"sort"
 	"strings"
+	"sync"
 	"testing"
 	"time"
 
+	"github.com/gin-gonic/gin"
 	"github.com/packethost/pkg/log"
 	"github.com/stretchr/testify/require"
 	"github.com/tinkerbell/hegel/datamodel"
@@ -32,7 +35,7 @@ func TestTrustedProxies(t *testing.T) {
 			client := mock.HardwareClient{Model: datamodel.TinkServer, Data: test.json}
 
 			mux := &http.ServeMux{}
-			mux.Handle("/2009-04-04/", EC2MetadataHandler(logger, client))
+			mux.Handle("/2009-04-04/", EC2MetadataHandler(logger, client, nil))
 
 			trustedProxies := xff.ParseTrustedProxies(test.trustedProxies)
 			xffHandler, err := xff.HTTPHandler(mux, trustedProxies)
@@ -61,6 +64,46 @@ func TestTrustedProxies(t *testing.T) {
 	}
 }
 
+// TestClientMACHeader tests the hardware is looked up by the client MAC header only when it is set by a trusted proxy.
+func TestClientMACHeader(t *testing.T) {
+	logger := log.Test(t, t.Name())
+
+	tests := map[string]struct {
+		remoteAddr string
+		status     int
+	}{
+		"trusted proxy": {
+			remoteAddr: "172.18.0.1:8080",
+			status:     http.StatusOK,
+		},
+		"untrusted client": {
+			remoteAddr: "172.19.0.1:8080",
+			status:     http.StatusNotFound,
+		},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			client := mock.HardwareClient{Data: mock.CacherDataModel}
+
+			metadataHandler, err := GetMetadataHandler(logger, client, "", datamodel.Cacher)
+			require.NoError(t, err)
+
+			handler, err := xff.HTTPHandler(metadataHandler, []string{"172.18.0.0/16"})
+			require.NoError(t, err)
+
+			req := httptest.NewRequest("GET", "/metadata", nil)
+			req.Header.Set(xff.ClientMACHeader, mock.UserMAC)
+			req.RemoteAddr = test.remoteAddr
+
+			resp := httptest.NewRecorder()
+			handler.ServeHTTP(resp, req)
+
+			require.Equal(t, test.status, resp.Code)
+		})
+	}
+}
+
 func TestGetMetadataCacher(t *testing.T) {
 	logger, err := log.Init(t.Name())
 	require.NoError(t, err)
@@ -76,7 +119,9 @@ func TestGetMetadataCacher(t *testing.T) {
 
 		req.RemoteAddr = mock.UserIP
 		resp := httptest.NewRecorder()
-		http.Handle("/metadata", GetMetadataHandler(logger, client, "", datamodel.Cacher)) // filter not used in cacher mode
+		handler, err := GetMetadataHandler(logger, client, "", datamodel.Cacher) // filter not used in cacher mode
+		require.NoError(t, err)
+		http.Handle("/metadata", handler)
 
 		http.DefaultServeMux.ServeHTTP(resp, req)
 
@@ -244,8 +289,8 @@ func TestEC2Endpoint(t *testing.T) {
 			http.DefaultServeMux = &http.ServeMux{} // reset registered patterns
 
 			// workaround for making trailing slash optional
-			http.Handle("/2009-04-04", EC2MetadataHandler(logger, client))
-			http.Handle("/2009-04-04/", EC2MetadataHandler(logger, client))
+			http.Handle("/2009-04-04", EC2MetadataHandler(logger, client, nil))
+			http.Handle("/2009-04-04/", EC2MetadataHandler(logger, client, nil))
 
 			req, err := http.NewRequest("GET", test.url, nil)
 			if err != nil {
@@ -268,10 +313,40 @@ func TestEC2Endpoint(t *testing.T) {
 	}
 }
 
+// watchingClient is a mock.HardwareClient whose watches stream updates.
+type watchingClient struct {
+	mock.HardwareClient
+	updates chan hardware.Hardware
+}
+
+func (c watchingClient) Watch(ctx context.Context, _ string) (hardware.Watcher, error) {
+	return chanWatcher{ctx: ctx, updates: c.updates}, nil
+}
+
+type chanWatcher struct {
+	ctx     context.Context
+	updates chan hardware.Hardware
+}
+
+func (w chanWatcher) Recv() (hardware.Hardware, error) {
+	select {
+	case hw := <-w.updates:
+		return hw, nil
+	case <-w.ctx.Done():
+		return nil, w.ctx.Err()
+	}
+}
+
 func TestFilterMetadata(t *testing.T) {
 	for name, test := range tinkerbellFilterMetadataTests {
 		t.Run(name, func(t *testing.T) {
-			res, err := filterMetadata([]byte(test.json), test.filter)
+			res, err := func() ([]byte, error) {
+				code, err := compileFilter(test.filter)
+				if err != nil {
+					return nil, err
+				}
+				return filterMetadata([]byte(test.json), code, filterArgs{})
+			}()
 			if test.error != "" {
 				if err == nil {
 					t.Errorf("FilterMetadata should have returned error: %v", test.error)
@@ -290,7 +365,7 @@ func TestFilterMetadata(t *testing.T) {
 func TestProcessEC2Query(t *testing.T) {
 	for name, test := range processEC2QueryTests {
 		t.Run(name, func(t *testing.T) {
-			res, err := processEC2Query(test.url)
+			res, code, err := processEC2Query(test.url)
 			if test.error != "" {
 				if err == nil {
 					t.Fatalf("processEC2Query should have returned error: %v", test.error)
@@ -302,6 +377,9 @@ func TestProcessEC2Query(t *testing.T) {
 			if !reflect.DeepEqual(res, test.result) {
 				t.Errorf("handler returned wrong result: got %v want %v", res, test.result)
 			}
+			if (code != nil) != (test.result != "") {
+				t.Errorf("handler returned wrong compiled filter: got %v for %q", code, res)
+			}
 		})
 	}
 }
@@ -324,8 +402,8 @@ func TestEC2FiltersMap(t *testing.T) {
 		t.Run(basePath, func(t *testing.T) {
 			hw := `{"metadata":{"instance":{"spot":{}}}}` // to make sure the 'spot' metadata item will be included
 			query := strings.TrimSuffix(basePath, "/")
-			dirListFilter := ec2Filters[query] // get the directory-list filter
-			itemsFromFilter, err := filterMetadata([]byte(hw), dirListFilter)
+			dirListCode := ec2FilterCodes[query] // get the directory-list filter
+			itemsFromFilter, err := filterMetadata([]byte(hw), dirListCode, filterArgs{})
 			if err != nil {
 				t.Errorf("failed to filter metadata: %s", err)
 			}
@@ -596,9 +674,9 @@ var registerEndpointTests = map[string]struct {
 	"custom endpoints invalid format (invalid jq filter syntax)": {
 		customEndpoints:     `{"/userdata":"invalid"}`,
 		url:                 "/userdata",
-		status:              500,
-		expectResponseEmpty: true,
-		error:               "",
+		status:              404,
+		expectResponseEmpty: false,
+		error:               `custom endpoint /userdata: compile filter "invalid": function not defined: invalid/0`,
 		json:                mock.TinkerbellDataModel,
 	},
 	"custom endpoints invalid format (valid jq filter syntax, nonexistent field)": {
@@ -635,6 +713,7 @@ instance-id
 iqn
 local-hostname
 local-ipv4
+network
 operating-system
 plan
 public-ipv4
@@ -701,15 +780,19 @@ test`,
 	"base endpoint": {
 		url:    "/2009-04-04",
 		status: 200,
-		response: `meta-data
-user-data`,
+		response: `dynamic
+meta-data
+user-data
+vendor-data`,
 		json: mock.TinkerbellKantEC2,
 	},
 	"base endpoint with trailing slash": {
 		url:    "/2009-04-04/",
 		status: 200,
-		response: `meta-data
-user-data`,
+		response: `dynamic
+meta-data
+user-data
+vendor-data`,
 		json: mock.TinkerbellKantEC2,
 	},
 	"spot instance with empty (but still present) spot field": {
@@ -721,6 +804,7 @@ instance-id
 iqn
 local-hostname
 local-ipv4
+network
 operating-system
 plan
 public-ipv4
@@ -736,6 +820,59 @@ tags`,
 		response: "now",
 		json:     mock.TinkerbellKantEC2SpotWithTermination,
 	},
+	"macs": {
+		url:      "/2009-04-04/meta-data/network/interfaces/macs",
+		status:   200,
+		response: "b4:96:91:5f:af:c0",
+		json:     mock.TinkerbellKantEC2,
+	},
+	"mac": {
+		url:    "/2009-04-04/meta-data/network/interfaces/macs/b4:96:91:5f:af:c0/",
+		status: 200,
+		response: `device-number
+ipv6s
+local-ipv4s
+mac
+subnet-ipv4-cidr-block`,
+		json: mock.TinkerbellKantEC2,
+	},
+	"device-number": {
+		url:      "/2009-04-04/meta-data/network/interfaces/macs/b4:96:91:5f:af:c0/device-number",
+		status:   200,
+		response: "0",
+		json:     mock.TinkerbellKantEC2,
+	},
+	"local-ipv4s": {
+		url:    "/2009-04-04/meta-data/network/interfaces/macs/B4:96:91:5F:AF:C0/local-ipv4s",
+		status: 200,
+		response: `192.168.1.5
+10.87.63.3`,
+		json: mock.TinkerbellKantEC2,
+	},
+	"ipv6s": {
+		url:      "/2009-04-04/meta-data/network/interfaces/macs/b4:96:91:5f:af:c0/ipv6s",
+		status:   200,
+		response: "2604:1380:1000:ca00::7",
+		json:     mock.TinkerbellKantEC2,
+	},
+	"subnet-ipv4-cidr-block": {
+		url:      "/2009-04-04/meta-data/network/interfaces/macs/b4:96:91:5f:af:c0/subnet-ipv4-cidr-block",
+		status:   200,
+		response: "192.168.1.0/29",
+		json:     mock.TinkerbellKantEC2,
+	},
+	"unknown mac": {
+		url:      "/2009-04-04/meta-data/network/interfaces/macs/b4:96:91:5f:af:c1/local-ipv4s",
+		status:   200,
+		response: "",
+		json:     mock.TinkerbellKantEC2,
+	},
+	"invalid mac": {
+		url:      "/2009-04-04/meta-data/network/interfaces/macs/not-a-mac/local-ipv4s",
+		status:   404,
+		response: "404 not found",
+		json:     mock.TinkerbellKantEC2,
+	},
 }
 
 // test cases for TestFilterMetadata.
@@ -784,6 +921,7 @@ instance-id
 iqn
 local-hostname
 local-ipv4
+network
 operating-system
 plan
 public-ipv4
@@ -796,7 +934,7 @@ tags`,
 	},
 	"invalid filter syntax": {
 		filter: "invalid",
-		error:  "error while filtering with gojq: function not defined: invalid/0",
+		error:  "function not defined: invalid/0",
 		json:   mock.TinkerbellFilterMetadata,
 	},
 	"valid filter syntax, nonexistent field": {
@@ -891,18 +1029,47 @@ func TestServe(t *testing.T) {
 
 	customEndpoints := `{"/metadata":".metadata.instance"}`
 
+	ctx, cancel := context.WithCancel(context.Background())
+	served := make(chan struct{})
+	defer func() {
+		cancel()
+		<-served
+	}()
+
 	go func() {
-		if err := Serve(context.Background(), logger, mock.HardwareClient{}, &grpc.Server{}, mport, time.Now(), "", customEndpoints, "", false); err != nil {
+		defer close(served)
+		config := ServerConfig{
+			Port:            mport,
+			Start:           time.Now(),
+			CustomEndpoints: customEndpoints,
+			MetadataAPI:     true,
+			EC2TokenMode:    EC2TokenOptional,
+			NoCloudPrefix:   "/nocloud",
+			ShutdownTimeout: time.Second,
+		}
+		if err := Serve(ctx, logger, mock.HardwareClient{}, &grpc.Server{}, config); err != nil {
 			t.Errorf("Serve() error = %v", err)
 		}
 	}()
 
+	// Serve doesn't report when it's listening.
+	require.Eventually(t, func() bool {
+		conn, err := net.Dial("tcp", fmt.Sprintf("localhost:%v", mport))
+		if err != nil {
+			return false
+		}
+		conn.Close()
+		return true
+	}, 10*time.Second, 10*time.Millisecond)
+
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
 			req, err := http.NewRequest("GET", fmt.Sprintf("http://localhost:%v"+"%v", mport, tt.httpreq), nil)
 			if err != nil {
 				t.Fatalf("request creation failed: %v", err)
 			}
+			// Spare keep-alive connections would hold up the shutdown until it times out.
+			req.Close = true
 
 			resp, err := http.DefaultClient.Do(req)
 			if err != nil {
@@ -921,3 +1088,117 @@ func TestServe(t *testing.T) {
 		})
 	}
 }
+
+func TestServeShutdown(t *testing.T) {
+	mport := 52001
+
+	logger, err := log.Init(t.Name())
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	errs := make(chan error, 1)
+	go func() {
+		config := ServerConfig{
+			Port:            mport,
+			Start:           time.Now(),
+			CustomEndpoints: "{}",
+			ShutdownTimeout: time.Second,
+		}
+		errs <- Serve(ctx, logger, mock.HardwareClient{}, &grpc.Server{}, config)
+	}()
+
+	require.Eventually(t, func() bool {
+		conn, err := net.Dial("tcp", fmt.Sprintf("localhost:%v", mport))
+		if err != nil {
+			return false
+		}
+		conn.Close()
+		return true
+	}, 10*time.Second, 10*time.Millisecond)
+
+	cancel()
+
+	select {
+	case err := <-errs:
+		require.NoError(t, err)
+	case <-time.After(5 * time.Second):
+		t.Fatal("Serve didn't return after its context was done")
+	}
+
+	_, err = net.Dial("tcp", fmt.Sprintf("localhost:%v", mport))
+	require.Error(t, err)
+}
+
+func TestEndStreams(t *testing.T) {
+	done := make(chan struct{})
+	handler := endStreams(done, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-r.Context().Done()
+	}))
+
+	var ended sync.WaitGroup
+	for _, url := range []string{"/events", "/computeMetadata/v1/instance/hostname?wait_for_change=true"} {
+		ended.Add(1)
+		go func(url string) {
+			defer ended.Done()
+			handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", url, nil))
+		}(url)
+	}
+
+	close(done)
+
+	allEnded := make(chan struct{})
+	go func() {
+		ended.Wait()
+		close(allEnded)
+	}()
+
+	select {
+	case <-allEnded:
+	case <-time.After(5 * time.Second):
+		t.Fatal("long lived requests didn't end when done was closed")
+	}
+}
+
+func TestRouter(t *testing.T) {
+	logger, err := log.Init(t.Name())
+	require.NoError(t, err)
+
+	gin.SetMode(gin.TestMode)
+	client := mock.HardwareClient{Model: datamodel.TinkServer, Data: mock.TinkerbellKantEC2}
+
+	// Monitoring and subscriptions are served whichever APIs are enabled.
+	paths := map[string]func(config ServerConfig) bool{
+		"/metrics":                       func(ServerConfig) bool { return true },
+		"/_packet/version":               func(ServerConfig) bool { return true },
+		"/subscriptions/unknown":         func(ServerConfig) bool { return true },
+		"/2009-04-04/meta-data/hostname": func(config ServerConfig) bool { return config.MetadataAPI },
+		"/v0/vendor-data":                func(config ServerConfig) bool { return config.HegelAPI },
+	}
+
+	for _, metadataAPI := range []bool{false, true} {
+		for _, hegelAPI := range []bool{false, true} {
+			config := ServerConfig{MetadataAPI: metadataAPI, HegelAPI: hegelAPI, EC2TokenMode: EC2TokenOptional}
+			router := newRouter(logger, client, grpc.NewServer(logger, client), NewEC2TokenStore(config.EC2TokenMode), NewPhoneHomeStore(), config)
+
+			for path, served := range paths {
+				t.Run(fmt.Sprintf("metadata %v hegel %v %v", metadataAPI, hegelAPI, path), func(t *testing.T) {
+					req, err := http.NewRequest("GET", path, nil)
+					require.NoError(t, err)
+					req.RemoteAddr = mock.UserIP
+					resp := httptest.NewRecorder()
+
+					router.ServeHTTP(resp, req)
+
+					// Unknown subscriptions are reported as a JSON error rather than the router's 404 page.
+					if served(config) {
+						require.NotContains(t, resp.Body.String(), "404 page not found")
+					} else {
+						require.Equal(t, http.StatusNotFound, resp.Code)
+					}
+				})
+			}
+		}
+	}
+}
@@ -0,0 +1,175 @@
+package http
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"strings"
+	"sync"
+	"text/template"
+
+	"github.com/packethost/pkg/log"
+	"github.com/pkg/errors"
+	"github.com/tinkerbell/hegel/hardware"
+)
+
+// templateMarker is the first line of userdata and custom endpoint filters that are Go text/templates rendered against
+// the exported hardware rather than served as is or evaluated as jq. It mirrors cloud-init's "## template: jinja".
+const templateMarker = "## template: go"
+
+// templateFuncs are the helper functions available to templates in addition to the text/template builtins.
+var templateFuncs = template.FuncMap{
+	"base64":         templateBase64,
+	"firstIPv4":      templateFirstIPv4,
+	"interfaceByMAC": templateInterfaceByMAC,
+}
+
+// isTemplate returns true if text starts with the template marker line.
+func isTemplate(text string) bool {
+	line := text
+	if i := strings.IndexByte(text, '\n'); i >= 0 {
+		line = text[:i]
+	}
+	return strings.TrimSpace(line) == templateMarker
+}
+
+// parseTemplate parses text, excluding its template marker line if it has one, with the template helper functions.
+func parseTemplate(name, text string) (*template.Template, error) {
+	if isTemplate(text) {
+		if i := strings.IndexByte(text, '\n'); i >= 0 {
+			text = text[i+1:]
+		} else {
+			text = ""
+		}
+	}
+
+	tmpl, err := template.New(name).Funcs(templateFuncs).Parse(text)
+	if err != nil {
+		return nil, errors.Wrap(err, "parse template")
+	}
+	return tmpl, nil
+}
+
+// executeTemplate renders tmpl against the exported hardware ehw.
+func executeTemplate(tmpl *template.Template, ehw []byte) ([]byte, error) {
+	var data interface{}
+	if err := json.Unmarshal(ehw, &data); err != nil {
+		return nil, errors.Wrap(err, "unmarshal hardware")
+	}
+
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, data); err != nil {
+		return nil, errors.Wrap(err, "execute template")
+	}
+	return buf.Bytes(), nil
+}
+
+// userdataTemplates caches the parsed userdata template of each hardware.
+var userdataTemplates = &templateCache{templates: map[string]cachedTemplate{}}
+
+// templateCache caches parsed templates by key, they're parsed again when their text changes.
+type templateCache struct {
+	mu        sync.Mutex
+	templates map[string]cachedTemplate
+}
+
+type cachedTemplate struct {
+	text string
+	tmpl *template.Template
+}
+
+// parse returns the parsed template of text cached under key, parsing it if it isn't cached or its text changed.
+func (c *templateCache) parse(key, text string) (*template.Template, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if cached, ok := c.templates[key]; ok && cached.text == text {
+		return cached.tmpl, nil
+	}
+
+	tmpl, err := parseTemplate("userdata", text)
+	if err != nil {
+		return nil, err
+	}
+	c.templates[key] = cachedTemplate{text: text, tmpl: tmpl}
+	return tmpl, nil
+}
+
+// renderUserdata renders userdata, as served to hw, if it's a template. ehw is hw exported. Userdata that fails to
+// render is logged and served as is rather than failing the request.
+func renderUserdata(logger log.Logger, hw hardware.Hardware, ehw, userdata []byte) []byte {
+	if !isTemplate(string(userdata)) {
+		return userdata
+	}
+
+	rendered, err := func() ([]byte, error) {
+		id, err := hw.ID()
+		if err != nil {
+			return nil, err
+		}
+
+		tmpl, err := userdataTemplates.parse(id, string(userdata))
+		if err != nil {
+			return nil, err
+		}
+		return executeTemplate(tmpl, ehw)
+	}()
+	if err != nil {
+		logger.Error(err, "failed to render userdata template, serving it unrendered")
+		return userdata
+	}
+	return rendered
+}
+
+// templateHardware decodes a template's data, the exported hardware, so helpers can read it.
+func templateHardware(data interface{}) (*exportedHardware, error) {
+	ehw, err := json.Marshal(data)
+	if err != nil {
+		return nil, errors.Wrap(err, "marshal template data")
+	}
+	return parseExportedHardware(ehw)
+}
+
+// templateFirstIPv4 returns the first IPv4 address of the hardware, preferring interface addresses over instance
+// addresses.
+func templateFirstIPv4(data interface{}) (string, error) {
+	hw, err := templateHardware(data)
+	if err != nil {
+		return "", err
+	}
+
+	var addresses []string
+	for _, iface := range hw.interfaces() {
+		addresses = append(addresses, iface.Address)
+	}
+	for _, address := range append(hw.Metadata.Instance.Network.Addresses, hw.Instance.IPAddresses...) {
+		addresses = append(addresses, address.Address)
+	}
+
+	for _, address := range addresses {
+		if address != "" && !strings.Contains(address, ":") {
+			return address, nil
+		}
+	}
+	return "", nil
+}
+
+// templateInterfaceByMAC returns the {mac, address, netmask, gateway} object of the hardware's interface with mac or
+// nil if there's no such interface.
+func templateInterfaceByMAC(data interface{}, mac string) (map[string]string, error) {
+	hw, err := templateHardware(data)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, iface := range hw.interfaces() {
+		if iface.MAC == strings.ToLower(mac) {
+			return map[string]string{"mac": iface.MAC, "address": iface.Address, "netmask": iface.Netmask, "gateway": iface.Gateway}, nil
+		}
+	}
+	return nil, nil
+}
+
+func templateBase64(s string) string {
+	return base64.StdEncoding.EncodeToString([]byte(s))
+}
@@ -0,0 +1,122 @@
+package http
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/packethost/pkg/log"
+	"github.com/stretchr/testify/require"
+	"github.com/tinkerbell/hegel/datamodel"
+	"github.com/tinkerbell/hegel/hardware"
+	"github.com/tinkerbell/hegel/hardware/mock"
+)
+
+func TestTemplates(t *testing.T) {
+	logger, err := log.Init(t.Name())
+	require.NoError(t, err)
+
+	for name, test := range tinkerbellTemplateTests {
+		t.Run(name, func(t *testing.T) {
+			client := mock.HardwareClient{Model: datamodel.TinkServer, Data: tinkerbellTemplate}
+			handler, err := test.handler(logger, client)
+			require.NoError(t, err)
+
+			req, err := http.NewRequest("GET", test.url, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			req.RemoteAddr = mock.UserIP
+			resp := httptest.NewRecorder()
+
+			handler.ServeHTTP(resp, req)
+
+			if status := resp.Code; status != http.StatusOK {
+				t.Errorf("handler returned wrong status code: got %v want %v",
+					status, http.StatusOK)
+			}
+
+			if resp.Body.String() != test.response {
+				t.Errorf("handler returned wrong body: got %v want %v", resp.Body.String(), test.response)
+			}
+		})
+	}
+}
+
+// test cases for TestTemplates.
+var tinkerbellTemplateTests = map[string]struct {
+	handler  func(log.Logger, hardware.Client) (http.Handler, error)
+	url      string
+	response string
+}{
+	"userdata": {
+		handler: func(logger log.Logger, client hardware.Client) (http.Handler, error) {
+			return EC2MetadataHandler(logger, client, nil), nil
+		},
+		url: "/2009-04-04/user-data",
+		response: `#cloud-config
+hostname: server001
+ip: 192.168.1.5
+netmask: 255.255.255.248
+secret: aGVsbG8=`,
+	},
+	"custom endpoint": {
+		handler: func(logger log.Logger, client hardware.Client) (http.Handler, error) {
+			return GetMetadataHandler(logger, client, "## template: go\n{{ .id }} {{ firstIPv4 . }}", datamodel.TinkServer)
+		},
+		url:      "/custom",
+		response: "template 192.168.1.5",
+	},
+	"custom endpoints config userdata": {
+		handler: func(logger log.Logger, client hardware.Client) (http.Handler, error) {
+			return CustomEndpointHandler(logger, client, CustomEndpoint{Filter: userdataFilter}, datamodel.TinkServer)
+		},
+		url: "/userdata",
+		response: `#cloud-config
+hostname: server001
+ip: 192.168.1.5
+netmask: 255.255.255.248
+secret: aGVsbG8=`,
+	},
+}
+
+// tinkerbellTemplate has userdata that's a template.
+const tinkerbellTemplate = `{"id": "template", "metadata": "{\"userdata\": \"## template: go\\n#cloud-config\\nhostname: {{ .metadata.instance.hostname }}\\nip: {{ firstIPv4 . }}\\nnetmask: {{ (interfaceByMAC . \\\"EC:0D:9A:C0:01:0C\\\").netmask }}\\nsecret: {{ base64 \\\"hello\\\" }}\", \"instance\": {\"hostname\": \"server001\"}}", "network": {"interfaces": [{"dhcp": {"mac": "ec:0d:9a:c0:01:0c", "ip": {"address": "192.168.1.5", "netmask": "255.255.255.248", "gateway": "192.168.1.1"}}}]}}`
+
+func TestUserdataTemplateFallback(t *testing.T) {
+	logger, err := log.Init(t.Name())
+	require.NoError(t, err)
+
+	// The template doesn't parse so the userdata is served as is.
+	json := `{"id": "broken", "metadata": "{\"userdata\": \"## template: go\\n#cloud-config\\nhostname: {{ .metadata.instance.hostname \"}"}`
+	client := mock.HardwareClient{Model: datamodel.TinkServer, Data: json}
+	handler := EC2MetadataHandler(logger, client, nil)
+
+	req, err := http.NewRequest("GET", "/2009-04-04/user-data", nil)
+	require.NoError(t, err)
+	req.RemoteAddr = mock.UserIP
+	resp := httptest.NewRecorder()
+
+	handler.ServeHTTP(resp, req)
+
+	require.Equal(t, http.StatusOK, resp.Code)
+	require.Equal(t, "## template: go\n#cloud-config\nhostname: {{ .metadata.instance.hostname ", resp.Body.String())
+}
+
+func TestTemplateCache(t *testing.T) {
+	cache := &templateCache{templates: map[string]cachedTemplate{}}
+
+	first, err := cache.parse("hw", "## template: go\n{{ .id }}")
+	require.NoError(t, err)
+
+	second, err := cache.parse("hw", "## template: go\n{{ .id }}")
+	require.NoError(t, err)
+	require.Same(t, first, second)
+
+	changed, err := cache.parse("hw", "## template: go\n{{ .metadata }}")
+	require.NoError(t, err)
+	require.NotSame(t, first, changed)
+
+	_, err = cache.parse("hw", "## template: go\n{{ .id ")
+	require.Error(t, err)
+}
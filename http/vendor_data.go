@@ -0,0 +1,90 @@
+package http
+
+import (
+	"net/http"
+	"os"
+
+	"github.com/gin-gonic/gin"
+	"github.com/packethost/pkg/log"
+	"github.com/pkg/errors"
+	"github.com/tinkerbell/hegel/hardware"
+	"sigs.k8s.io/yaml"
+)
+
+// VendorData is operator provided cloud-config served to machines as cloud-init vendor-data. Cloud-init merges it with
+// the machine's userdata so platform wide settings, such as NTP servers or CA certificates, needn't be copied into
+// every machine's userdata.
+type VendorData struct {
+	// Default is served to machines that don't match a plan or facility.
+	Default string `json:"default"`
+
+	// Facilities maps facility codes to vendor data.
+	Facilities map[string]string `json:"facilities"`
+
+	// Plans maps plan slugs to vendor data. Plans take precedence over facilities.
+	Plans map[string]string `json:"plans"`
+}
+
+// LoadVendorData reads VendorData from a YAML or JSON file.
+func LoadVendorData(path string) (*VendorData, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var vd VendorData
+	if err := yaml.UnmarshalStrict(data, &vd); err != nil {
+		return nil, errors.Wrapf(err, "parse %v", path)
+	}
+	return &vd, nil
+}
+
+// Select returns the vendor data for the exported hardware ehw. The vendor data of the hardware's plan is preferred
+// over that of its facility which is preferred over the default. A nil VendorData selects nothing.
+func (vd *VendorData) Select(ehw []byte) (string, error) {
+	if vd == nil {
+		return "", nil
+	}
+
+	hw, err := parseExportedHardware(ehw)
+	if err != nil {
+		return "", err
+	}
+
+	if plan := hw.plan(); plan != "" {
+		if data, ok := vd.Plans[plan]; ok {
+			return data, nil
+		}
+	}
+	if facility := hw.facility(); facility != "" {
+		if data, ok := vd.Facilities[facility]; ok {
+			return data, nil
+		}
+	}
+	return vd.Default, nil
+}
+
+func vendorDataHandler(logger log.Logger, client hardware.Client, vendorData *VendorData) gin.HandlerFunc {
+	fn := func(c *gin.Context) {
+		hw, err := lookupHardware(c.Request, client, c.ClientIP())
+		if err != nil {
+			logger.With("error", err).Info("failed to get hardware in v0 metadata handler")
+			c.JSON(http.StatusNotFound, nil)
+			return
+		}
+		ehw, err := hw.Export()
+		if err != nil {
+			logger.With("error", err).Info("failed to export hardware in v0 metadata handler")
+			c.JSON(http.StatusInternalServerError, nil)
+			return
+		}
+		data, err := vendorData.Select(ehw)
+		if err != nil {
+			logger.With("error", err).Info("failed to select vendor data in v0 metadata handler")
+			c.JSON(http.StatusInternalServerError, nil)
+			return
+		}
+		c.String(http.StatusOK, data)
+	}
+	return gin.HandlerFunc(fn)
+}
@@ -0,0 +1,129 @@
+package http
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/packethost/pkg/log"
+	"github.com/stretchr/testify/require"
+	"github.com/tinkerbell/hegel/datamodel"
+	"github.com/tinkerbell/hegel/hardware"
+	"github.com/tinkerbell/hegel/hardware/mock"
+)
+
+func TestVendorData(t *testing.T) {
+	logger, err := log.Init(t.Name())
+	require.NoError(t, err)
+
+	for name, test := range vendorDataTests {
+		t.Run(name, func(t *testing.T) {
+			client := mock.HardwareClient{Model: datamodel.TinkServer, Data: mock.TinkerbellKantEC2}
+			handler := test.handler(logger, client, test.vendorData)
+
+			req, err := http.NewRequest("GET", test.url, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			req.RemoteAddr = mock.UserIP
+			resp := httptest.NewRecorder()
+
+			handler.ServeHTTP(resp, req)
+
+			if status := resp.Code; status != http.StatusOK {
+				t.Errorf("handler returned wrong status code: got %v want %v",
+					status, http.StatusOK)
+			}
+
+			if resp.Body.String() != test.response {
+				t.Errorf("handler returned wrong body: got %v want %v", resp.Body.String(), test.response)
+			}
+		})
+	}
+}
+
+func TestLoadVendorData(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "vendor-data.yaml")
+	err := os.WriteFile(path, []byte(`default: |
+  #cloud-config
+  ntp:
+    servers: [ntp.example.com]
+plans:
+  c3.small.x86: "#cloud-config\n"
+`), 0o600)
+	require.NoError(t, err)
+
+	vendorData, err := LoadVendorData(path)
+	require.NoError(t, err)
+	require.Equal(t, &VendorData{
+		Default: "#cloud-config\nntp:\n  servers: [ntp.example.com]\n",
+		Plans:   map[string]string{"c3.small.x86": "#cloud-config\n"},
+	}, vendorData)
+
+	err = os.WriteFile(path, []byte("defaults: oops\n"), 0o600)
+	require.NoError(t, err)
+
+	_, err = LoadVendorData(path)
+	require.Error(t, err)
+}
+
+// test cases for TestVendorData.
+var vendorDataTests = map[string]struct {
+	vendorData *VendorData
+	handler    func(log.Logger, hardware.Client, *VendorData) http.Handler
+	url        string
+	response   string
+}{
+	"ec2 plan": {
+		vendorData: &VendorData{
+			Default:    "default",
+			Facilities: map[string]string{"sjc1": "facility"},
+			Plans:      map[string]string{"c3.small.x86": "plan"},
+		},
+		handler:  EC2MetadataHandler,
+		url:      "/2009-04-04/vendor-data",
+		response: "plan",
+	},
+	"ec2 facility": {
+		vendorData: &VendorData{
+			Default:    "default",
+			Facilities: map[string]string{"sjc1": "facility"},
+			Plans:      map[string]string{"m3.large.x86": "plan"},
+		},
+		handler:  EC2MetadataHandler,
+		url:      "/2009-04-04/vendor-data",
+		response: "facility",
+	},
+	"ec2 default": {
+		vendorData: &VendorData{Default: "default", Facilities: map[string]string{"ny5": "facility"}},
+		handler:    EC2MetadataHandler,
+		url:        "/2009-04-04/vendor-data",
+		response:   "default",
+	},
+	"ec2 unconfigured": {
+		handler:  EC2MetadataHandler,
+		url:      "/2009-04-04/vendor-data",
+		response: "",
+	},
+	"nocloud": {
+		vendorData: &VendorData{Default: "#cloud-config\nntp:\n  servers: [ntp.example.com]\n"},
+		handler: func(logger log.Logger, client hardware.Client, vendorData *VendorData) http.Handler {
+			return NoCloudHandler(logger, client, "/nocloud", vendorData)
+		},
+		url:      "/nocloud/vendor-data",
+		response: "#cloud-config\nntp:\n  servers: [ntp.example.com]\n",
+	},
+	"openstack": {
+		vendorData: &VendorData{Default: "#cloud-config\nntp:\n  servers: [ntp.example.com]\n"},
+		handler:    OpenStackMetadataHandler,
+		url:        "/openstack/latest/vendor_data.json",
+		response:   `{"cloud-init":"#cloud-config\nntp:\n  servers: [ntp.example.com]\n"}`,
+	},
+	"openstack unconfigured": {
+		handler:  OpenStackMetadataHandler,
+		url:      "/openstack/latest/vendor_data.json",
+		response: "{}",
+	},
+}
@@ -17,6 +17,9 @@ var (
 	InitDuration       prometheus.Observer
 	Errors             *prometheus.CounterVec
 	MetadataRequests   prometheus.Counter
+	RejectedRequests   *prometheus.CounterVec
+	DisallowedRequests *prometheus.CounterVec
+	PhoneHomes         prometheus.Counter
 	State              prometheus.Gauge
 	Subscriptions      *prometheus.GaugeVec
 	TotalSubscriptions prometheus.Counter
@@ -51,8 +54,11 @@ func init() {
 	}, []string{"op", "state"})
 
 	labelValues = []prometheus.Labels{
+		{"op": "admin", "state": "auth"},
 		{"op": "cacher", "state": "healthcheck"},
+		{"op": "custom-endpoints", "state": "reload"},
 		{"op": "metadata", "state": "lookup"},
+		{"op": "phone-home", "state": "record"},
 		{"op": "subscribe", "state": "active"},
 		{"op": "subscribe", "state": "initializing"},
 	}
@@ -63,6 +69,33 @@ func init() {
 		Help: "Number of requests to the metadata http endpoint",
 	})
 
+	RejectedRequests = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "hegel_http_rejected_requests_total",
+		Help: "Number of HTTP requests rejected for exceeding the rate limit or in-flight request limit",
+	}, []string{"reason"})
+
+	labelValues = []prometheus.Labels{
+		{"reason": "in-flight"},
+		{"reason": "rate-limit"},
+	}
+	initCounterLabels(RejectedRequests, labelValues)
+
+	DisallowedRequests = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "hegel_disallowed_requests_total",
+		Help: "Number of requests rejected as their source address isn't in the allowed sources",
+	}, []string{"server"})
+
+	labelValues = []prometheus.Labels{
+		{"server": "grpc"},
+		{"server": "http"},
+	}
+	initCounterLabels(DisallowedRequests, labelValues)
+
+	PhoneHomes = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "hegel_phone_homes_total",
+		Help: "Number of phone home reports received from machines",
+	})
+
 	State = promauto.NewGauge(prometheus.GaugeOpts{
 		Name: "hegel_state",
 		Help: "Current state of hegel, 0:started, 1:initializing, 2:ready",
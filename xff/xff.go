@@ -16,6 +16,15 @@ import (
 	"google.golang.org/grpc/peer"
 )
 
+const (
+	// ClientMACHeader is the HTTP header a trusted proxy may set to identify the requesting machine by MAC address. It is
+	// removed from requests that don't originate from a trusted proxy.
+	ClientMACHeader = "X-Hegel-Client-MAC"
+
+	// ClientMACMetadataKey is the gRPC metadata equivalent of ClientMACHeader.
+	ClientMACMetadataKey = "x-hegel-client-mac"
+)
+
 // converts a list of subnets' string to a list of net.IPNet.
 func toMasks(ips []string) ([]net.IPNet, error) {
 	var nets []net.IPNet
@@ -29,6 +38,35 @@ func toMasks(ips []string) ([]net.IPNet, error) {
 	return nets, nil
 }
 
+// containsIP reports whether ip is within any of masks.
+func containsIP(masks []net.IPNet, ip net.IP) bool {
+	for _, n := range masks {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// stripClientMAC removes the ClientMACMetadataKey from the incoming metadata unless the peer is within masks. It must
+// be called before the peer is replaced by updateRemote.
+func stripClientMAC(ctx context.Context, masks []net.IPNet) context.Context {
+	md, ok := metadata.FromIncomingContext(ctx)
+	if !ok || len(md.Get(ClientMACMetadataKey)) == 0 {
+		return ctx
+	}
+
+	if remote, ok := peer.FromContext(ctx); ok {
+		if tcpAddr, ok := remote.Addr.(*net.TCPAddr); ok && containsIP(masks, tcpAddr.IP) {
+			return ctx
+		}
+	}
+
+	md = md.Copy()
+	delete(md, ClientMACMetadataKey)
+	return metadata.NewIncomingContext(ctx, md)
+}
+
 func updateRemote(ctx context.Context, l log.Logger, masks []net.IPNet) context.Context {
 	md, ok := metadata.FromIncomingContext(ctx)
 	if !ok {
@@ -59,15 +97,7 @@ func updateRemote(ctx context.Context, l log.Logger, masks []net.IPNet) context.
 	rip := tcpAddr.IP
 	l = l.With("remote", remote)
 
-	allowed := false
-	for _, n := range masks {
-		if n.Contains(rip) {
-			allowed = true
-			break
-		}
-	}
-
-	if !allowed {
+	if !containsIP(masks, rip) {
 		l.With("masks", masks).Info("remote host not in allowed list")
 		return ctx
 	}
@@ -127,13 +157,16 @@ func ParseTrustedProxies(trustedProxies string) []string {
 // GRPCMiddlewares returns a set of grpc interceptors that will replace peer.IP with X-FORWARDED-FOR value if the peer IP is within one of the subnets in allowedSubnets
 // If allowedSubnets is nil it will look for subnets in the TRUSTED_PROXIES env var.
 // If allowedSubnets is nil and TRUSTED_PROXIES is empty then X-FORWARDED-FOR will be ignored (no proxy is trusted).
+// The ClientMACMetadataKey is removed from the metadata of all peers that aren't within allowedSubnets.
 func GRPCMiddlewares(l log.Logger, allowedSubnets []string) (grpc.StreamServerInterceptor, grpc.UnaryServerInterceptor) {
 	if len(allowedSubnets) == 0 {
 		streamer := func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
-			return handler(srv, ss)
+			wrapped := grpc_middleware.WrapServerStream(ss)
+			wrapped.WrappedContext = stripClientMAC(ss.Context(), nil)
+			return handler(srv, wrapped)
 		}
 		unaryer := func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (resp interface{}, err error) {
-			return handler(ctx, req)
+			return handler(stripClientMAC(ctx, nil), req)
 		}
 		return streamer, unaryer
 	}
@@ -145,19 +178,25 @@ func GRPCMiddlewares(l log.Logger, allowedSubnets []string) (grpc.StreamServerIn
 
 	streamer := func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
 		wrapped := grpc_middleware.WrapServerStream(ss)
-		wrapped.WrappedContext = updateRemote(ss.Context(), l, masks)
+		wrapped.WrappedContext = updateRemote(stripClientMAC(ss.Context(), masks), l, masks)
 		return handler(srv, wrapped)
 	}
 	unaryer := func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (resp interface{}, err error) {
-		return handler(updateRemote(ctx, l, masks), req)
+		return handler(updateRemote(stripClientMAC(ctx, masks), l, masks), req)
 	}
 	return streamer, unaryer
 }
 
-// HTTPHandler creates a XFF handler if there are allowedSubnets specified.
+// HTTPHandler creates a XFF handler if there are allowedSubnets specified. The ClientMACHeader is removed from all
+// requests that don't originate from allowedSubnets.
 func HTTPHandler(handler http.Handler, allowedSubnets []string) (http.Handler, error) {
 	if len(allowedSubnets) == 0 {
-		return handler, nil
+		return stripClientMACHeader(handler, nil), nil
+	}
+
+	masks, err := toMasks(allowedSubnets)
+	if err != nil {
+		return nil, errors.Errorf("parse allowed subnets: %v", err)
 	}
 
 	xffmw, err := xff.New(xff.Options{
@@ -167,5 +206,22 @@ func HTTPHandler(handler http.Handler, allowedSubnets []string) (http.Handler, e
 		return nil, errors.Errorf("create forward for handler: %v", err)
 	}
 
-	return xffmw.Handler(handler), nil
+	return stripClientMACHeader(xffmw.Handler(handler), masks), nil
+}
+
+// stripClientMACHeader removes the ClientMACHeader from requests whose remote address isn't within masks. It must wrap
+// the XFF handler so it observes the address of the proxy rather than the forwarded address.
+func stripClientMACHeader(handler http.Handler, masks []net.IPNet) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get(ClientMACHeader) != "" {
+			host, _, err := net.SplitHostPort(r.RemoteAddr)
+			if err != nil {
+				host = r.RemoteAddr
+			}
+			if ip := net.ParseIP(host); ip == nil || !containsIP(masks, ip) {
+				r.Header.Del(ClientMACHeader)
+			}
+		}
+		handler.ServeHTTP(w, r)
+	})
 }